@@ -3,7 +3,9 @@ package authentication
 
 import (
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	tollbooth "github.com/didip/tollbooth/v7"
@@ -11,12 +13,50 @@ import (
 	tollbooth_gin "github.com/didip/tollbooth_gin"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/passkey"
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
 )
 
 // LoginRequest is the payload for logging in.
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=8,alphanum"`
+	// Scope limits the session to some of the person's permissions
+	Scope string `json:"scope" binding:"max=2048"`
+}
+
+// MFALoginRequest is the payload for completing a login with a second factor.
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// MFAChallengeResponse is returned by login instead of tokens when the
+// person has a second factor.
+type MFAChallengeResponse struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	Methods     []string `json:"methods"`
+}
+
+// MFATokenRequest is the payload for starting an email or passkey second factor.
+type MFATokenRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// PasskeyMFARequest is the payload for completing a login with a passkey
+// as second factor.
+type PasskeyMFARequest struct {
+	MFAToken   string                    `json:"mfa_token" binding:"required"`
+	Credential passkey.AssertionResponse `json:"credential" binding:"required"`
+}
+
+// PasskeyLoginRequest is the payload for a passwordless login.
+type PasskeyLoginRequest struct {
+	Credential passkey.AssertionResponse `json:"credential" binding:"required"`
+	// Scope limits the session to some of the person's permissions
+	Scope string `json:"scope" binding:"max=2048"`
 }
 
 // RefreshRequest is the payload for refreshing an access token.
@@ -35,6 +75,31 @@ type TokenResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+// SwitchOrganizationRequest is the payload for choosing the organization a
+// session acts in; 0 leaves the current one.
+type SwitchOrganizationRequest struct {
+	OrganizationID uint `json:"organization_id"`
+}
+
+// AccessTokenResponse contains an access token for the current session.
+type AccessTokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// PersonIDRequest represents a URI person id parameter.
+type PersonIDRequest struct {
+	ID uint `uri:"id" binding:"required,min=1"`
+}
+
+// LockoutResponse reports a person's failed logins.
+type LockoutResponse struct {
+	PersonID       uint       `json:"person_id"`
+	FailedAttempts int        `json:"failed_attempts"`
+	LastFailedAt   *time.Time `json:"last_failed_at,omitempty"`
+	Locked         bool       `json:"locked"`
+	RetryAt        *time.Time `json:"retry_at,omitempty"`
+}
+
 // AuthHandler handles authentication-related HTTP endpoints.
 type AuthHandler struct {
 	router  *gin.RouterGroup
@@ -43,7 +108,9 @@ type AuthHandler struct {
 }
 
 // NewAuthHandler registers auth endpoints on the given router group,
-// with rate limiting applied to login, refresh, and logout.
+// with rate limiting applied to login, MFA, passkey, refresh, and logout.
+// ReadLockout and ClearLockout are mounted by the caller on a group that
+// requires an admin.
 func NewAuthHandler(router *gin.RouterGroup, service AuthenticationService, logger *zap.Logger) *AuthHandler {
 	h := &AuthHandler{router: router, service: service, logger: logger}
 
@@ -58,6 +125,42 @@ func NewAuthHandler(router *gin.RouterGroup, service AuthenticationService, logg
 		h.Login,
 	)
 
+	h.router.POST(
+		"/auth/login/mfa",
+		tollbooth_gin.LimitHandler(authLimiter),
+		h.LoginMFA,
+	)
+
+	h.router.POST(
+		"/auth/login/mfa/email",
+		tollbooth_gin.LimitHandler(authLimiter),
+		h.SendMFAEmailCode,
+	)
+
+	h.router.POST(
+		"/auth/login/mfa/passkey/begin",
+		tollbooth_gin.LimitHandler(authLimiter),
+		h.BeginPasskeyMFA,
+	)
+
+	h.router.POST(
+		"/auth/login/mfa/passkey/finish",
+		tollbooth_gin.LimitHandler(authLimiter),
+		h.LoginPasskeyMFA,
+	)
+
+	h.router.POST(
+		"/auth/passkey/begin",
+		tollbooth_gin.LimitHandler(authLimiter),
+		h.BeginPasskeyLogin,
+	)
+
+	h.router.POST(
+		"/auth/passkey/finish",
+		tollbooth_gin.LimitHandler(authLimiter),
+		h.LoginPasskey,
+	)
+
 	h.router.POST(
 		"/auth/refresh",
 		tollbooth_gin.LimitHandler(authLimiter),
@@ -75,14 +178,17 @@ func NewAuthHandler(router *gin.RouterGroup, service AuthenticationService, logg
 
 // Login godoc
 // @Summary      Login
-// @Description  Authenticate user and issue tokens
+// @Description  Authenticate user and issue tokens, or an MFA token when the user has a second factor
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        payload  body      LoginRequest  true  "Login credentials"
 // @Success      200      {object}  TokenResponse
+// @Success      202      {object}  MFAChallengeResponse
 // @Failure      400      {object}  map[string]string
 // @Failure      401      {object}  map[string]string
+// @Failure      403      {object}  map[string]string
+// @Failure      423      {object}  map[string]string
 // @Failure      500      {object}  map[string]string
 // @Router       /auth/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -92,18 +198,227 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email or password format"})
 		return
 	}
-	access, refresh, err := h.service.Login(c.Request.Context(), req.Email, req.Password)
+	ctx := WithScope(c.Request.Context(), req.Scope)
+	result, err := h.service.Login(ctx, req.Email, req.Password)
 	switch {
+	case err == nil && result.MFARequired():
+		c.JSON(http.StatusAccepted, MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    result.MFAToken,
+			Methods:     result.MFAMethods,
+		})
 	case err == nil:
-		c.JSON(http.StatusOK, TokenResponse{AccessToken: access, RefreshToken: refresh})
+		c.JSON(http.StatusOK, TokenResponse{AccessToken: result.AccessToken, RefreshToken: result.RefreshToken})
 	case errors.Is(err, ErrInvalidCredentials):
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+	case errors.Is(err, ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{"error": "email address not verified"})
+	case errors.Is(err, ErrAccountLocked):
+		respondLocked(c, err)
+	case errors.Is(err, ErrInvalidScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 	default:
 		h.logger.Error("Login service failed", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not login"})
 	}
 }
 
+// LoginMFA godoc
+// @Summary      Complete MFA Login
+// @Description  Exchange the MFA token from login and a TOTP, email or recovery code for tokens
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      MFALoginRequest  true  "MFA token and code"
+// @Success      200      {object}  TokenResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      423      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /auth/login/mfa [post]
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid mfa login payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa_token and code required"})
+		return
+	}
+	access, refresh, err := h.service.CompleteMFALogin(c.Request.Context(), req.MFAToken, req.Code)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, TokenResponse{AccessToken: access, RefreshToken: refresh})
+	case errors.Is(err, ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+	case errors.Is(err, ErrInvalidMFAToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token, login again"})
+	case errors.Is(err, ErrAccountLocked):
+		respondLocked(c, err)
+	case errors.Is(err, ErrInvalidScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("CompleteMFALogin service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not login"})
+	}
+}
+
+// SendMFAEmailCode godoc
+// @Summary      Send MFA Email Code
+// @Description  Email a code to complete a login with, to a person with email codes enabled
+// @Tags         auth
+// @Accept       json
+// @Param        payload  body      MFATokenRequest  true  "MFA token from login"
+// @Success      202
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /auth/login/mfa/email [post]
+func (h *AuthHandler) SendMFAEmailCode(c *gin.Context) {
+	var req MFATokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa_token required"})
+		return
+	}
+	err := h.service.SendMFAEmailCode(c.Request.Context(), req.MFAToken)
+	switch {
+	case err == nil:
+		c.Status(http.StatusAccepted)
+	case errors.Is(err, ErrInvalidMFAToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token, login again"})
+	case errors.Is(err, ErrNoEmailFactor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email codes not enabled"})
+	default:
+		h.logger.Error("SendMFAEmailCode service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not send code"})
+	}
+}
+
+// BeginPasskeyMFA godoc
+// @Summary      Begin Passkey MFA
+// @Description  Get the options for navigator.credentials.get to complete a login with a passkey
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      MFATokenRequest  true  "MFA token from login"
+// @Success      200      {object}  passkey.RequestOptions
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /auth/login/mfa/passkey/begin [post]
+func (h *AuthHandler) BeginPasskeyMFA(c *gin.Context) {
+	var req MFATokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa_token required"})
+		return
+	}
+	options, err := h.service.BeginPasskeyMFA(c.Request.Context(), req.MFAToken)
+	switch {
+	case err == nil:
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, options)
+	case errors.Is(err, ErrInvalidMFAToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token, login again"})
+	case errors.Is(err, ErrNoPasskey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no passkey registered"})
+	default:
+		h.logger.Error("BeginPasskeyMFA service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not start passkey login"})
+	}
+}
+
+// LoginPasskeyMFA godoc
+// @Summary      Complete Passkey MFA Login
+// @Description  Exchange the MFA token from login and a passkey assertion for tokens
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      PasskeyMFARequest  true  "MFA token and assertion"
+// @Success      200      {object}  TokenResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      423      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /auth/login/mfa/passkey/finish [post]
+func (h *AuthHandler) LoginPasskeyMFA(c *gin.Context) {
+	var req PasskeyMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid passkey mfa payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa_token and credential required"})
+		return
+	}
+	access, refresh, err := h.service.CompletePasskeyMFA(c.Request.Context(), req.MFAToken, &req.Credential)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, TokenResponse{AccessToken: access, RefreshToken: refresh})
+	case errors.Is(err, ErrInvalidPasskey):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "passkey could not be verified"})
+	case errors.Is(err, ErrInvalidMFAToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token, login again"})
+	case errors.Is(err, ErrAccountLocked):
+		respondLocked(c, err)
+	case errors.Is(err, ErrInvalidScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("CompletePasskeyMFA service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not login"})
+	}
+}
+
+// BeginPasskeyLogin godoc
+// @Summary      Begin Passkey Login
+// @Description  Get the options for navigator.credentials.get to login with a passkey instead of a password
+// @Tags         auth
+// @Produce      json
+// @Success      200      {object}  passkey.RequestOptions
+// @Failure      500      {object}  map[string]string
+// @Router       /auth/passkey/begin [post]
+func (h *AuthHandler) BeginPasskeyLogin(c *gin.Context) {
+	options, err := h.service.BeginPasskeyLogin(c.Request.Context())
+	if err != nil {
+		h.logger.Error("BeginPasskeyLogin service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not start passkey login"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, options)
+}
+
+// LoginPasskey godoc
+// @Summary      Passkey Login
+// @Description  Exchange a passkey assertion for tokens, without a password
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      PasskeyLoginRequest  true  "Assertion"
+// @Success      200      {object}  TokenResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      403      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /auth/passkey/finish [post]
+func (h *AuthHandler) LoginPasskey(c *gin.Context) {
+	var req PasskeyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid passkey login payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "credential required"})
+		return
+	}
+	ctx := WithScope(c.Request.Context(), req.Scope)
+	access, refresh, err := h.service.CompletePasskeyLogin(ctx, &req.Credential)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, TokenResponse{AccessToken: access, RefreshToken: refresh})
+	case errors.Is(err, ErrInvalidPasskey):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "passkey could not be verified"})
+	case errors.Is(err, ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{"error": "email address not verified"})
+	case errors.Is(err, ErrInvalidScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("CompletePasskeyLogin service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not login"})
+	}
+}
+
 // Refresh godoc
 // @Summary      Refresh Token
 // @Description  Rotate refresh token and issue new tokens
@@ -137,11 +452,12 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 
 // Logout godoc
 // @Summary      Logout
-// @Description  Revoke a refresh token
+// @Description  Revoke a refresh token issued at the login endpoints with its session, and the bearer access token if one of the same session is sent
 // @Tags         auth
 // @Accept       json
 // @Produce      json
-// @Param        payload  body      LogoutRequest  true  "Logout payload"
+// @Param        payload        body      LogoutRequest  true   "Logout payload"
+// @Param        Authorization  header    string         false  "Bearer access token of the session to revoke"
 // @Success      204      {object}  nil
 // @Failure      400      {object}  map[string]string
 // @Failure      401      {object}  map[string]string
@@ -154,7 +470,9 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "refresh token required"})
 		return
 	}
-	err := h.service.Logout(c.Request.Context(), req.RefreshToken)
+	// also end the access token presented alongside, if it is of the session
+	access, _ := bearerToken(c.GetHeader("Authorization"))
+	err := h.service.Logout(c.Request.Context(), req.RefreshToken, access)
 	switch {
 	case err == nil:
 		c.Status(http.StatusNoContent)
@@ -165,3 +483,278 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not logout"})
 	}
 }
+
+// ReadLockout godoc
+// @Summary      Read Lockout
+// @Description  Report a person's failed logins and whether the account is locked
+// @Tags         persons
+// @Produce      json
+// @Param        id   path      int  true  "Person ID"
+// @Success      200  {object}  LockoutResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /persons/{id}/lockout [get]
+func (h *AuthHandler) ReadLockout(c *gin.Context) {
+	var uri PersonIDRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id"})
+		return
+	}
+	status, err := h.service.ReadLockout(c.Request.Context(), uri.ID)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, LockoutResponse{
+			PersonID:       uri.ID,
+			FailedAttempts: status.FailedAttempts,
+			LastFailedAt:   status.LastFailedAt,
+			Locked:         status.RetryAt != nil,
+			RetryAt:        status.RetryAt,
+		})
+	case errors.Is(err, person.ErrPersonNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "person not found"})
+	default:
+		h.logger.Error("ReadLockout service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read lockout"})
+	}
+}
+
+// ClearLockout godoc
+// @Summary      Clear Lockout
+// @Description  Unlock a person's account and forget their failed logins
+// @Tags         persons
+// @Param        id   path      int  true  "Person ID"
+// @Success      204
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /persons/{id}/lockout [delete]
+func (h *AuthHandler) ClearLockout(c *gin.Context) {
+	var uri PersonIDRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id"})
+		return
+	}
+	err := h.service.ClearLockout(c.Request.Context(), uri.ID)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, person.ErrPersonNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "person not found"})
+	default:
+		h.logger.Error("ClearLockout service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not clear lockout"})
+	}
+}
+
+// SessionIDRequest represents URI person and session id parameters; the
+// person id is absent on the current person's routes.
+type SessionIDRequest struct {
+	PersonID  uint `uri:"id"`
+	SessionID uint `uri:"session_id" binding:"required,min=1"`
+}
+
+func currentPerson(c *gin.Context) (*person.Person, bool) {
+	raw, exists := c.Get(person.ContextUserKey)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+	return raw.(*person.Person), true
+}
+
+// ListCurrentSessions godoc
+// @Summary      List My Sessions
+// @Description  List where the current person is logged in
+// @Tags         sessions
+// @Produce      json
+// @Success      200  {array}   Session
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /persons/me/sessions [get]
+func (h *AuthHandler) ListCurrentSessions(c *gin.Context) {
+	user, ok := currentPerson(c)
+	if !ok {
+		return
+	}
+	h.listSessions(c, user.ID, c.GetString(ContextSessionKey))
+}
+
+// RevokeCurrentSession godoc
+// @Summary      Revoke My Session
+// @Description  Log the current person out of one of their sessions
+// @Tags         sessions
+// @Param        session_id  path      int  true  "Session ID"
+// @Success      204
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /persons/me/sessions/{session_id} [delete]
+func (h *AuthHandler) RevokeCurrentSession(c *gin.Context) {
+	user, ok := currentPerson(c)
+	if !ok {
+		return
+	}
+	var uri SessionIDRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing session id"})
+		return
+	}
+	h.revokeSession(c, user.ID, uri.SessionID)
+}
+
+// RevokeOtherSessions godoc
+// @Summary      Revoke My Other Sessions
+// @Description  Log the current person out everywhere but the session making the request
+// @Tags         sessions
+// @Success      204
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /persons/me/sessions [delete]
+func (h *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	user, ok := currentPerson(c)
+	if !ok {
+		return
+	}
+	if err := h.service.RevokeOtherSessions(c.Request.Context(), user.ID, c.GetString(ContextSessionKey)); err != nil {
+		h.logger.Error("RevokeOtherSessions service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not revoke sessions"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListSessions godoc
+// @Summary      List Sessions
+// @Description  List where a person is logged in
+// @Tags         sessions
+// @Produce      json
+// @Param        id   path      int  true  "Person ID"
+// @Success      200  {array}   Session
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /persons/{id}/sessions [get]
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	var uri PersonIDRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id"})
+		return
+	}
+	h.listSessions(c, uri.ID, "")
+}
+
+// RevokeSession godoc
+// @Summary      Revoke Session
+// @Description  Log a person out of one of their sessions
+// @Tags         sessions
+// @Param        id          path      int  true  "Person ID"
+// @Param        session_id  path      int  true  "Session ID"
+// @Success      204
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /persons/{id}/sessions/{session_id} [delete]
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	var uri SessionIDRequest
+	if err := c.ShouldBindUri(&uri); err != nil || uri.PersonID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id"})
+		return
+	}
+	h.revokeSession(c, uri.PersonID, uri.SessionID)
+}
+
+// RevokeAllSessions godoc
+// @Summary      Revoke All Sessions
+// @Description  Log a person out everywhere
+// @Tags         sessions
+// @Param        id   path      int  true  "Person ID"
+// @Success      204
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /persons/{id}/sessions [delete]
+func (h *AuthHandler) RevokeAllSessions(c *gin.Context) {
+	var uri PersonIDRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id"})
+		return
+	}
+	if err := h.service.RevokeSessions(c.Request.Context(), uri.ID); err != nil {
+		h.logger.Error("RevokeSessions service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not revoke sessions"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) listSessions(c *gin.Context, personID uint, currentSessionID string) {
+	sessions, err := h.service.ListSessions(c.Request.Context(), personID, currentSessionID)
+	if err != nil {
+		h.logger.Error("ListSessions service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (h *AuthHandler) revokeSession(c *gin.Context, personID, sessionID uint) {
+	err := h.service.RevokeSession(c.Request.Context(), personID, sessionID)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+	default:
+		h.logger.Error("RevokeSession service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not revoke session"})
+	}
+}
+
+// SwitchOrganization godoc
+// @Summary      Switch Organization
+// @Description  Make the current session act in an organization the person is a member of, or in none with organization_id 0, and return an access token for it
+// @Tags         sessions
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      SwitchOrganizationRequest  true  "Organization payload"
+// @Success      200      {object}  AccessTokenResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      403      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /persons/me/organization [put]
+func (h *AuthHandler) SwitchOrganization(c *gin.Context) {
+	user, ok := currentPerson(c)
+	if !ok {
+		return
+	}
+	var req SwitchOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid switch organization payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "organization_id required"})
+		return
+	}
+	access, err := h.service.SwitchOrganization(c.Request.Context(), user, c.GetString(ContextSessionKey), req.OrganizationID)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, AccessTokenResponse{AccessToken: access})
+	case errors.Is(err, ErrNotMember):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found, log in again"})
+	default:
+		h.logger.Error("SwitchOrganization service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not switch organization"})
+	}
+}
+
+// respondLocked refuses a login on a locked account, telling the client
+// when to retry.
+func respondLocked(c *gin.Context, err error) {
+	var locked *AccountLockedError
+	if errors.As(err, &locked) {
+		c.Header("Retry-After", strconv.Itoa(max(1, int(math.Ceil(time.Until(locked.RetryAt).Seconds())))))
+	}
+	c.JSON(http.StatusLocked, gin.H{"error": "too many failed attempts, try again later"})
+}
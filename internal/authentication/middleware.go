@@ -9,11 +9,44 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
+	"github.com/mehmetcc/definitive-authentication-service/internal/client"
 	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+	"github.com/mehmetcc/definitive-authentication-service/internal/rbac"
+	"github.com/mehmetcc/definitive-authentication-service/internal/tenant"
 	"github.com/mehmetcc/definitive-authentication-service/internal/utils"
 )
 
-func AuthMiddleware(personService person.PersonService, accessSecret string, logger *zap.Logger) gin.HandlerFunc {
+// ContextSessionKey is the key under which the session id of the access
+// token is stored in Gin context.
+const ContextSessionKey = "session"
+
+// ContextScopeKey is the key under which the scope of the access token is
+// stored in Gin context.
+const ContextScopeKey = "scope"
+
+// ClientInfoMiddleware records the user agent and address of each request
+// in its context, for the sessions it starts or refreshes.
+func ClientInfoMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(WithClientInfo(c.Request.Context(), ClientInfo{
+			UserAgent: c.Request.UserAgent(),
+			IPAddress: c.ClientIP(),
+		}))
+		c.Next()
+	}
+}
+
+// AuthMiddleware authenticates the bearer access token. Tokens issued to
+// persons put the Person into context under person.ContextUserKey, tokens
+// issued to clients put the Client under client.ContextClientKey; either
+// way the token's scope goes under ContextScopeKey. Tokens for an
+// organization put it into the request context, see tenant.
+func AuthMiddleware(
+	personService person.PersonService,
+	clientService client.ClientService,
+	authService AuthenticationService,
+	logger *zap.Logger,
+) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -21,19 +54,33 @@ func AuthMiddleware(personService person.PersonService, accessSecret string, log
 			return
 		}
 
-		parts := strings.Fields(authHeader)
-		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		rawToken, ok := bearerToken(authHeader)
+		if !ok {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header format must be Bearer <token>"})
 			return
 		}
-		rawToken := parts[1]
 
-		// Parse and validate access JWT
-		claims, err := utils.ParseAccessToken(rawToken, accessSecret)
-		if err != nil {
+		// Parse and validate access JWT, rejecting revoked tokens
+		claims, err := authService.ValidateAccessToken(c.Request.Context(), rawToken)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrAccessTokenRevoked):
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "access token revoked"})
+			return
+		case errors.Is(err, ErrInvalidAccessToken):
 			logger.Warn("access token parse failed", zap.Error(err))
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired access token"})
 			return
+		default:
+			logger.Error("failed to validate access token", zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not validate access token"})
+			return
+		}
+
+		c.Set(ContextScopeKey, claims.Scope)
+		if claims.IsClient() {
+			setClientPrincipal(c, clientService, claims, logger)
+			return
 		}
 
 		// Extract subject as user ID
@@ -56,24 +103,148 @@ func AuthMiddleware(personService person.PersonService, accessSecret string, log
 			return
 		}
 
+		// Changing the password and the like ends every earlier token
+		if claims.TokenVersion != user.TokenVersion {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "access token revoked"})
+			return
+		}
+
+		// Tokens for an organization only work while the person is a member,
+		// and limit every repository query to it
+		if claims.OrganizationID != 0 {
+			err := authService.CheckMembership(c.Request.Context(), user.ID, claims.OrganizationID)
+			if errors.Is(err, ErrNotMember) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "no longer a member of the organization"})
+				return
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not validate organization"})
+				return
+			}
+			c.Request = c.Request.WithContext(tenant.WithOrganization(c.Request.Context(), claims.OrganizationID))
+		}
+
 		// Set person into context and proceed
 		c.Set(person.ContextUserKey, user)
+		c.Set(ContextSessionKey, claims.SessionID)
 		c.Next()
 	}
 }
 
-func RoleMiddleware(requiredRole person.Role, logger *zap.Logger) gin.HandlerFunc {
+// setClientPrincipal loads the client a token was issued to into context.
+func setClientPrincipal(c *gin.Context, clientService client.ClientService, claims *utils.AccessClaims, logger *zap.Logger) {
+	cl, err := clientService.ReadClientByClientID(c.Request.Context(), claims.Subject)
+	if err != nil {
+		if errors.Is(err, client.ErrClientNotFound) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "client not found"})
+			return
+		}
+		logger.Error("failed to load client by client_id", zap.Error(err), zap.String("clientID", claims.Subject))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not validate client"})
+		return
+	}
+
+	c.Set(client.ContextClientKey, cl)
+	c.Next()
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header value.
+func bearerToken(authHeader string) (string, bool) {
+	parts := strings.Fields(authHeader)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// RoleMiddleware lets through persons with at least requiredRole: the role
+// itself or one inheriting it, such as admin where user is required. Only
+// roles held outside organizations count.
+func RoleMiddleware(rbacService rbac.RBACService, requiredRole string, logger *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		raw, exists := c.Get(person.ContextUserKey)
-		if !exists {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		user, ok := requirePerson(c)
+		if !ok {
+			return
+		}
+		allowed, err := rbacService.HasRole(c.Request.Context(), user.ID, requiredRole)
+		if err != nil {
+			logger.Error("failed to check role", zap.Uint("personID", user.ID), zap.String("role", requiredRole), zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not check role"})
+			return
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission lets through persons one of whose roles grants
+// permission, with an access token whose scope includes it.
+func RequirePermission(rbacService rbac.RBACService, permission string, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := requirePerson(c)
+		if !ok {
 			return
 		}
-		user := raw.(*person.Person)
-		if user.Role != requiredRole {
+		if !hasScope(c.GetString(ContextScopeKey), permission) {
+			abortInsufficientScope(c, permission)
+			return
+		}
+		allowed, err := rbacService.HasPermission(c.Request.Context(), user.ID, permission)
+		if err != nil {
+			logger.Error("failed to check permission", zap.Uint("personID", user.ID), zap.String("permission", permission), zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not check permission"})
+			return
+		}
+		if !allowed {
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
 			return
 		}
 		c.Next()
 	}
 }
+
+// RequireScopes lets through persons and clients whose access token has
+// every one of scopes. It has to run after AuthMiddleware.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	required := strings.Join(scopes, " ")
+	return func(c *gin.Context) {
+		scope, exists := c.Get(ContextScopeKey)
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		for _, want := range scopes {
+			if !hasScope(scope.(string), want) {
+				abortInsufficientScope(c, required)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// abortInsufficientScope refuses a request whose token lacks scope, as
+// described by RFC 6750 section 3.1.
+func abortInsufficientScope(c *gin.Context, scope string) {
+	c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient scope"})
+}
+
+// requirePerson returns the person authenticated by AuthMiddleware, or
+// aborts the request if there is none.
+func requirePerson(c *gin.Context) (*person.Person, bool) {
+	raw, exists := c.Get(person.ContextUserKey)
+	if !exists {
+		// clients have no roles
+		if _, isClient := c.Get(client.ContextClientKey); isClient {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return nil, false
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+	return raw.(*person.Person), true
+}
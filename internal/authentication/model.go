@@ -6,9 +6,148 @@ import (
 	"gorm.io/gorm"
 )
 
+// RefreshTokenRecord is a session: it lives from login to logout, its
+// refresh token being replaced on every refresh.
 type RefreshTokenRecord struct {
 	gorm.Model
 	PersonID     uint      `gorm:"index;not null"`
+	FamilyID     string    `gorm:"index"`
 	RefreshToken string    `gorm:"uniqueIndex;not null"`
 	ExpiresAt    time.Time `gorm:"index;not null"`
+	UserAgent    string
+	// IPAddress is where the session was last used from
+	IPAddress   string
+	DeviceLabel string
+	LastUsedAt  time.Time
+	// OrganizationID is the organization the session acts in, 0 for none
+	OrganizationID uint `gorm:"not null;default:0"`
+	// Scope is the scope the session was asked for at login, empty for
+	// every permission of the person
+	Scope string `gorm:"not null;default:''"`
+	// ClientID is the OAuth client the session was started for, empty
+	// for logins at this service's own endpoints
+	ClientID string `gorm:"index;not null;default:''"`
+}
+
+// Session is a person's login as shown to them and to admins.
+type Session struct {
+	ID          uint      `json:"id"`
+	DeviceLabel string    `json:"device_label"`
+	UserAgent   string    `json:"user_agent"`
+	IPAddress   string    `json:"ip_address"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Current marks the session the request was made with
+	Current bool `json:"current"`
+	// OrganizationID is the organization the session acts in
+	OrganizationID uint `json:"organization_id,omitempty"`
+	// Scope is the scope asked for at login, empty for full access
+	Scope string `json:"scope,omitempty"`
+}
+
+// SupersededRefreshToken keeps the hash of a refresh token that has been
+// rotated away, so presenting it again can be detected as reuse.
+type SupersededRefreshToken struct {
+	gorm.Model
+	PersonID     uint      `gorm:"index;not null"`
+	FamilyID     string    `gorm:"index;not null"`
+	RefreshToken string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt    time.Time `gorm:"index;not null"`
+}
+
+// RevokedAccessToken denylists an access token by its jti until the
+// token would have expired anyway.
+type RevokedAccessToken struct {
+	gorm.Model
+	JTI       string    `gorm:"uniqueIndex;not null"`
+	PersonID  uint      `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
+
+// Lockout counts a person's failed password attempts since their last
+// successful login.
+type Lockout struct {
+	gorm.Model
+	PersonID       uint      `gorm:"uniqueIndex;not null"`
+	FailedAttempts int       `gorm:"not null;default:0"`
+	LastFailedAt   time.Time `gorm:"not null"`
+	LockedUntil    *time.Time
+}
+
+// LockoutPolicy slows down and then stops password guessing against a
+// single account, wherever the guesses come from.
+type LockoutPolicy struct {
+	// DelayAfter is the number of failures after which each further
+	// attempt has to wait, 0 disables delays
+	DelayAfter int
+	// BaseDelay is the first wait, doubled with every further failure
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Threshold is the number of failures that locks the account, 0
+	// disables lockouts
+	Threshold int
+	Duration  time.Duration
+}
+
+// LockoutStatus is a person's standing with the lockout policy.
+type LockoutStatus struct {
+	FailedAttempts int
+	LastFailedAt   *time.Time
+	// RetryAt is when the next password attempt is accepted, nil if now
+	RetryAt *time.Time
+}
+
+// AccountLockedError is returned, matching ErrAccountLocked, when a
+// password attempt comes too early.
+type AccountLockedError struct {
+	RetryAt time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return ErrAccountLocked.Error()
+}
+
+func (e *AccountLockedError) Unwrap() error {
+	return ErrAccountLocked
+}
+
+// TokenTypeHint names the kind of token, as used by RFC 7662 and RFC 7009.
+type TokenTypeHint string
+
+const (
+	AccessTokenHint  TokenTypeHint = "access_token"
+	RefreshTokenHint TokenTypeHint = "refresh_token"
+)
+
+// TokenIntrospection is the state of a token as reported by Introspect.
+type TokenIntrospection struct {
+	Active    bool
+	TokenType TokenTypeHint
+	Subject   string
+	// ClientID is the client the token was issued to, on its own behalf or
+	// a person's; empty for logins at this service's own endpoints
+	ClientID string
+	// OrganizationID is set for tokens of persons acting in an organization
+	OrganizationID uint
+	Roles          []string
+	Groups         []string
+	Scope          string
+	IssuedAt       time.Time
+	ExpiresAt      time.Time
+}
+
+// LoginResult is the outcome of the password step of a login: either the
+// token pair, or an MFA challenge token when the person has a second factor.
+type LoginResult struct {
+	AccessToken  string
+	RefreshToken string
+	MFAToken     string
+	// MFAMethods lists the second factors that can complete the login
+	MFAMethods []string
+}
+
+// MFARequired reports whether the login has to be completed with a second factor.
+func (r *LoginResult) MFARequired() bool {
+	return r.MFAToken != ""
 }
@@ -6,7 +6,11 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/tenant"
 )
 
 var (
@@ -16,16 +20,29 @@ var (
 	ErrRecordNotFoundByGivenPersonID = errors.New("no tokens found for given person")
 	ErrUnresponsiveDatabase          = errors.New("error occurred during writing to records table")
 	ErrRecordExpired                 = errors.New("token expired")
+	ErrRecordNotFoundByGivenFamilyID = errors.New("no tokens found for given family")
+	ErrLockoutNotFound               = errors.New("no failed logins recorded for given person")
 )
 
 type RecordRepository interface {
 	Create(ctx context.Context, record *RefreshTokenRecord) error
 	ReadByToken(ctx context.Context, token string) (*RefreshTokenRecord, error)
 	ReadByID(ctx context.Context, id uint) (*RefreshTokenRecord, error)
-	Rotate(ctx context.Context, oldToken, newToken string, newExpiry time.Time) error
+	// Rotate replaces a session's refresh token and records the use
+	Rotate(ctx context.Context, oldToken, newToken string, newExpiry time.Time, client ClientInfo) error
 	Delete(ctx context.Context, id uint) error
 	DeleteByToken(ctx context.Context, token string) error
 	DeleteByPersonID(ctx context.Context, personID uint) error
+	DeleteByPersonIDExcept(ctx context.Context, personID uint, familyID string) (int64, error)
+	ListByPersonID(ctx context.Context, personID uint, now time.Time) ([]RefreshTokenRecord, error)
+	// SetOrganization changes the organization a session of the person acts
+	// in and returns the session
+	SetOrganization(ctx context.Context, personID uint, familyID string, organizationID uint) (*RefreshTokenRecord, error)
+	ReadSupersededByToken(ctx context.Context, token string) (*SupersededRefreshToken, error)
+	DeleteByFamilyID(ctx context.Context, familyID string) error
+	// FamilyExists reports whether the session with familyID has not ended
+	FamilyExists(ctx context.Context, familyID string) (bool, error)
+	DeleteExpiredSuperseded(ctx context.Context, now time.Time) (int64, error)
 }
 
 type recordRepository struct {
@@ -49,6 +66,7 @@ func (r *recordRepository) Rotate(
 	ctx context.Context,
 	oldToken, newToken string,
 	newExpiry time.Time,
+	client ClientInfo,
 ) error {
 	return r.db.
 		WithContext(ctx).
@@ -67,8 +85,26 @@ func (r *recordRepository) Rotate(
 				return ErrUnresponsiveDatabase
 			}
 
+			if rec.FamilyID == "" {
+				// records issued before families existed start one on first rotation
+				rec.FamilyID = uuid.NewString()
+			}
+			superseded := &SupersededRefreshToken{
+				PersonID:     rec.PersonID,
+				FamilyID:     rec.FamilyID,
+				RefreshToken: rec.RefreshToken,
+				ExpiresAt:    rec.ExpiresAt,
+			}
+			if err := tx.Create(superseded).Error; err != nil {
+				return ErrUnresponsiveDatabase
+			}
+
 			rec.RefreshToken = newToken
 			rec.ExpiresAt = newExpiry
+			rec.LastUsedAt = time.Now()
+			if client.IPAddress != "" {
+				rec.IPAddress = client.IPAddress
+			}
 			if err := tx.Save(&rec).Error; err != nil {
 				return ErrUnresponsiveDatabase
 			}
@@ -99,6 +135,7 @@ func (r *recordRepository) ReadByID(ctx context.Context, id uint) (*RefreshToken
 	var record RefreshTokenRecord
 	err := r.db.WithContext(ctx).
 		Joins("JOIN persons ON persons.id = refresh_token_records.person_id").
+		Scopes(tenant.Members(ctx, "refresh_token_records.person_id")).
 		Where("refresh_token_records.id = ?", id).
 		Where("persons.deleted_at IS NULL").
 		First(&record).
@@ -113,11 +150,12 @@ func (r *recordRepository) ReadByID(ctx context.Context, id uint) (*RefreshToken
 	return &record, nil
 }
 
+// Delete and DeleteByToken filter on live persons with a subquery, as
+// joins are not applied to updates and deletes.
 func (r *recordRepository) Delete(ctx context.Context, id uint) error {
 	res := r.db.WithContext(ctx).
-		Joins("JOIN persons ON persons.id = refresh_token_records.person_id").
-		Where("refresh_token_records.id = ?", id).
-		Where("persons.deleted_at IS NULL").
+		Where("id = ?", id).
+		Where("person_id IN (?)", r.livePersons()).
 		Delete(&RefreshTokenRecord{})
 	if res.Error != nil {
 		return ErrUnresponsiveDatabase
@@ -130,9 +168,8 @@ func (r *recordRepository) Delete(ctx context.Context, id uint) error {
 
 func (r *recordRepository) DeleteByToken(ctx context.Context, token string) error {
 	res := r.db.WithContext(ctx).
-		Joins("JOIN persons ON persons.id = refresh_token_records.person_id").
-		Where("refresh_token_records.refresh_token = ?", token).
-		Where("persons.deleted_at IS NULL").
+		Where("refresh_token = ?", token).
+		Where("person_id IN (?)", r.livePersons()).
 		Delete(&RefreshTokenRecord{})
 	if res.Error != nil {
 		return ErrUnresponsiveDatabase
@@ -143,19 +180,304 @@ func (r *recordRepository) DeleteByToken(ctx context.Context, token string) erro
 	return nil
 }
 
+// DeleteByPersonID revokes every live and superseded token of a person.
 func (r *recordRepository) DeleteByPersonID(ctx context.Context, personID uint) error {
 	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		res := tx.
-			Joins("JOIN persons ON persons.id = refresh_token_records.person_id").
-			Where("refresh_token_records.person_id = ?", personID).
-			Where("persons.deleted_at IS NULL").
+			Scopes(tenant.Members(ctx, "person_id")).
+			Where("person_id = ?", personID).
 			Delete(&RefreshTokenRecord{})
 		if res.Error != nil {
 			return ErrUnresponsiveDatabase
 		}
-		if res.RowsAffected == 0 {
+		superseded := tx.
+			Unscoped().
+			Scopes(tenant.Members(ctx, "person_id")).
+			Where("person_id = ?", personID).
+			Delete(&SupersededRefreshToken{})
+		if superseded.Error != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if res.RowsAffected == 0 && superseded.RowsAffected == 0 {
 			return ErrRecordNotFoundByGivenPersonID
 		}
 		return nil
 	})
 }
+
+// DeleteByPersonIDExcept revokes every session of a person but the one
+// with the given family.
+func (r *recordRepository) DeleteByPersonIDExcept(ctx context.Context, personID uint, familyID string) (int64, error) {
+	var deleted int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.
+			Scopes(tenant.Members(ctx, "person_id")).
+			Where("person_id = ?", personID).
+			Where("family_id <> ?", familyID).
+			Delete(&RefreshTokenRecord{})
+		if res.Error != nil {
+			return ErrUnresponsiveDatabase
+		}
+		deleted = res.RowsAffected
+		superseded := tx.
+			Unscoped().
+			Scopes(tenant.Members(ctx, "person_id")).
+			Where("person_id = ?", personID).
+			Where("family_id <> ?", familyID).
+			Delete(&SupersededRefreshToken{})
+		if superseded.Error != nil {
+			return ErrUnresponsiveDatabase
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// ListByPersonID returns the person's unexpired sessions, most recently
+// used first.
+func (r *recordRepository) ListByPersonID(ctx context.Context, personID uint, now time.Time) ([]RefreshTokenRecord, error) {
+	var records []RefreshTokenRecord
+	err := r.db.WithContext(ctx).
+		Scopes(tenant.Members(ctx, "person_id")).
+		Where("person_id = ?", personID).
+		Where("expires_at > ?", now).
+		Order("last_used_at DESC").
+		Find(&records).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return records, nil
+}
+
+func (r *recordRepository) SetOrganization(
+	ctx context.Context,
+	personID uint,
+	familyID string,
+	organizationID uint,
+) (*RefreshTokenRecord, error) {
+	var rec RefreshTokenRecord
+	res := r.db.WithContext(ctx).
+		Model(&rec).
+		Clauses(clause.Returning{}).
+		Where("person_id = ? AND family_id = ?", personID, familyID).
+		Update("organization_id", organizationID)
+	if res.Error != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrRecordNotFoundByGivenFamilyID
+	}
+	return &rec, nil
+}
+
+func (r *recordRepository) livePersons() *gorm.DB {
+	return r.db.Table("persons").Select("id").Where("deleted_at IS NULL")
+}
+
+func (r *recordRepository) ReadSupersededByToken(ctx context.Context, token string) (*SupersededRefreshToken, error) {
+	var superseded SupersededRefreshToken
+	err := r.db.WithContext(ctx).
+		Where("refresh_token = ?", token).
+		First(&superseded).
+		Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordNotFoundByGivenToken
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &superseded, nil
+}
+
+// DeleteByFamilyID revokes every live and superseded token of a family.
+func (r *recordRepository) DeleteByFamilyID(ctx context.Context, familyID string) error {
+	if familyID == "" {
+		return ErrRecordNotFoundByGivenFamilyID
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.
+			Where("family_id = ?", familyID).
+			Delete(&RefreshTokenRecord{})
+		if res.Error != nil {
+			return ErrUnresponsiveDatabase
+		}
+		superseded := tx.
+			Unscoped().
+			Where("family_id = ?", familyID).
+			Delete(&SupersededRefreshToken{})
+		if superseded.Error != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if res.RowsAffected == 0 && superseded.RowsAffected == 0 {
+			return ErrRecordNotFoundByGivenFamilyID
+		}
+		return nil
+	})
+}
+
+func (r *recordRepository) FamilyExists(ctx context.Context, familyID string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&RefreshTokenRecord{}).
+		Where("family_id = ?", familyID).
+		Count(&count).
+		Error
+	if err != nil {
+		return false, ErrUnresponsiveDatabase
+	}
+	return count > 0, nil
+}
+
+// DeleteExpiredSuperseded drops superseded hashes whose token has expired;
+// presenting them again fails signature validation before any lookup.
+func (r *recordRepository) DeleteExpiredSuperseded(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("expires_at <= ?", now).
+		Delete(&SupersededRefreshToken{})
+	if res.Error != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	return res.RowsAffected, nil
+}
+
+type DenylistRepository interface {
+	Create(ctx context.Context, entry *RevokedAccessToken) error
+	Exists(ctx context.Context, jti string, now time.Time) (bool, error)
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type denylistRepository struct {
+	db *gorm.DB
+}
+
+func NewDenylistRepository(db *gorm.DB) DenylistRepository {
+	return &denylistRepository{db: db}
+}
+
+// Create denylists a jti. Revoking an already revoked token is not an error.
+func (r *denylistRepository) Create(ctx context.Context, entry *RevokedAccessToken) error {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "jti"}}, DoNothing: true}).
+		Create(entry).
+		Error
+	if err != nil {
+		return ErrUnresponsiveDatabase
+	}
+	return nil
+}
+
+func (r *denylistRepository) Exists(ctx context.Context, jti string, now time.Time) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&RevokedAccessToken{}).
+		Where("jti = ? AND expires_at > ?", jti, now).
+		Count(&count).
+		Error
+	if err != nil {
+		return false, ErrUnresponsiveDatabase
+	}
+	return count > 0, nil
+}
+
+func (r *denylistRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("expires_at <= ?", now).
+		Delete(&RevokedAccessToken{})
+	if res.Error != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	return res.RowsAffected, nil
+}
+
+type LockoutRepository interface {
+	ReadByPersonID(ctx context.Context, personID uint) (*Lockout, error)
+	// RecordFailure counts a failed attempt, atomically with attempts
+	// running in parallel, and returns the updated count
+	RecordFailure(ctx context.Context, personID uint, now time.Time) (*Lockout, error)
+	// Lock locks the account until the given time and restarts the count
+	Lock(ctx context.Context, personID uint, until time.Time) error
+	DeleteByPersonID(ctx context.Context, personID uint) error
+}
+
+type lockoutRepository struct {
+	db *gorm.DB
+}
+
+func NewLockoutRepository(db *gorm.DB) LockoutRepository {
+	return &lockoutRepository{db: db}
+}
+
+func (r *lockoutRepository) ReadByPersonID(ctx context.Context, personID uint) (*Lockout, error) {
+	var lockout Lockout
+	err := r.db.WithContext(ctx).
+		Scopes(tenant.Members(ctx, "person_id")).
+		Where("person_id = ?", personID).
+		First(&lockout).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLockoutNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &lockout, nil
+}
+
+func (r *lockoutRepository) RecordFailure(ctx context.Context, personID uint, now time.Time) (*Lockout, error) {
+	lockout := Lockout{PersonID: personID, FailedAttempts: 1, LastFailedAt: now}
+	err := r.db.WithContext(ctx).
+		Clauses(
+			clause.OnConflict{
+				Columns: []clause.Column{{Name: "person_id"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"failed_attempts": gorm.Expr("lockouts.failed_attempts + 1"),
+					"last_failed_at":  now,
+					"updated_at":      now,
+				}),
+			},
+			clause.Returning{},
+		).
+		Create(&lockout).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &lockout, nil
+}
+
+func (r *lockoutRepository) Lock(ctx context.Context, personID uint, until time.Time) error {
+	err := r.db.WithContext(ctx).
+		Model(&Lockout{}).
+		Where("person_id = ?", personID).
+		Updates(map[string]interface{}{
+			"failed_attempts": 0,
+			"locked_until":    until,
+		}).
+		Error
+	if err != nil {
+		return ErrUnresponsiveDatabase
+	}
+	return nil
+}
+
+func (r *lockoutRepository) DeleteByPersonID(ctx context.Context, personID uint) error {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Scopes(tenant.Members(ctx, "person_id")).
+		Where("person_id = ?", personID).
+		Delete(&Lockout{})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrLockoutNotFound
+	}
+	return nil
+}
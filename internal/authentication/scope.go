@@ -0,0 +1,50 @@
+package authentication
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/rbac"
+)
+
+// identityScopes are the OpenID Connect scopes, which ask for facts about
+// the person rather than for permissions. Every person can grant them.
+var identityScopes = map[string]bool{
+	"openid": true,
+	"email":  true,
+}
+
+type scopeKey struct{}
+
+// WithScope returns a context carrying the space separated scope a login
+// asks for, for the session it starts. Without one the session's tokens
+// carry every permission of the person.
+func WithScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+func requestedScope(ctx context.Context) string {
+	scope, _ := ctx.Value(scopeKey{}).(string)
+	return scope
+}
+
+// ValidScope reports whether every value of the space separated scope is
+// one that some person could grant: an identity scope or a permission.
+func ValidScope(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if !identityScopes[s] && !rbac.IsPermission(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasScope reports whether the space separated scope contains want.
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
@@ -5,92 +5,616 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/mehmetcc/definitive-authentication-service/internal/client"
+	"github.com/mehmetcc/definitive-authentication-service/internal/mfa"
+	"github.com/mehmetcc/definitive-authentication-service/internal/passkey"
 	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+	"github.com/mehmetcc/definitive-authentication-service/internal/rbac"
+	"github.com/mehmetcc/definitive-authentication-service/internal/security"
 	"github.com/mehmetcc/definitive-authentication-service/internal/utils"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// how often expired denylist entries and superseded refresh tokens are purged
+const purgeInterval = time.Hour
+
 var (
 	ErrInvalidCredentials  = errors.New("invalid username or password")
 	ErrLoginFailed         = errors.New("login failed")
 	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrInvalidAccessToken  = errors.New("invalid access token")
+	ErrAccessTokenRevoked  = errors.New("access token revoked")
+	ErrMFARequired         = errors.New("second factor required")
+	ErrInvalidMFACode      = errors.New("invalid second factor code")
+	ErrInvalidMFAToken     = errors.New("invalid or expired mfa token")
+	ErrInvalidPasskey      = errors.New("passkey could not be verified")
+	ErrNoPasskey           = errors.New("no passkey registered")
+	ErrNoEmailFactor       = errors.New("email codes not enrolled")
+	ErrEmailNotVerified    = errors.New("email address not verified")
+	ErrAccountLocked       = errors.New("account temporarily locked")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrNotMember           = errors.New("not a member of the organization")
+	ErrInvalidScope        = errors.New("requested scope exceeds the person's permissions")
+	// ErrPasskeyRequired is returned where only a code can be entered but
+	// the person's only second factor is a passkey
+	ErrPasskeyRequired = errors.New("passkey required as second factor")
+)
+
+// second factor methods reported with an MFA challenge
+const (
+	MethodTOTP         = "totp"
+	MethodEmail        = "email"
+	MethodPasskey      = "passkey"
+	MethodRecoveryCode = "recovery_code"
 )
 
 type AuthenticationService interface {
-	Login(ctx context.Context, email, password string) (accessToken, refreshToken string, err error)
+	Login(ctx context.Context, email, password string) (*LoginResult, error)
+	LoginPerson(ctx context.Context, user *person.Person) (*LoginResult, error)
+	CompleteMFALogin(ctx context.Context, mfaToken, code string) (accessToken, refreshToken string, err error)
+	SendMFAEmailCode(ctx context.Context, mfaToken string) error
+	BeginPasskeyMFA(ctx context.Context, mfaToken string) (*passkey.RequestOptions, error)
+	CompletePasskeyMFA(ctx context.Context, mfaToken string, resp *passkey.AssertionResponse) (accessToken, refreshToken string, err error)
+	BeginPasskeyLogin(ctx context.Context) (*passkey.RequestOptions, error)
+	CompletePasskeyLogin(ctx context.Context, resp *passkey.AssertionResponse) (accessToken, refreshToken string, err error)
+	Authenticate(ctx context.Context, email, password string) (*person.Person, error)
+	VerifySecondFactor(ctx context.Context, user *person.Person, code string) error
+	IssueTokens(ctx context.Context, user *person.Person) (accessToken, refreshToken string, err error)
+	CheckScope(ctx context.Context, personID uint, scope string) error
+	IssueClientToken(ctx context.Context, cl *client.Client, scope string) (accessToken string, err error)
 	Refresh(ctx context.Context, refreshJWT string) (newAccessToken, newRefreshToken string, err error)
-	Logout(ctx context.Context, refreshJWT string) error
+	// Logout ends the session of a refresh token issued at the login
+	// endpoints of this service, and accessJWT, if not empty, when it was
+	// issued for the same session
+	Logout(ctx context.Context, refreshJWT, accessJWT string) error
+	RevokeSessions(ctx context.Context, personID uint) error
+	// ListSessions marks the session with currentSessionID, the sid of the
+	// access token the request was made with, as current
+	ListSessions(ctx context.Context, personID uint, currentSessionID string) ([]Session, error)
+	RevokeSession(ctx context.Context, personID, sessionID uint) error
+	RevokeOtherSessions(ctx context.Context, personID uint, currentSessionID string) error
+	// SwitchOrganization makes the session with sessionID act in the
+	// organization, or in none if organizationID is 0, and returns an
+	// access token for it
+	SwitchOrganization(ctx context.Context, user *person.Person, sessionID string, organizationID uint) (accessToken string, err error)
+	// CheckMembership returns ErrNotMember unless the person is a member of
+	// the organization
+	CheckMembership(ctx context.Context, personID, organizationID uint) error
+	ReadLockout(ctx context.Context, personID uint) (*LockoutStatus, error)
+	ClearLockout(ctx context.Context, personID uint) error
+	Introspect(ctx context.Context, token string, hint TokenTypeHint) (*TokenIntrospection, error)
+	ValidateAccessToken(ctx context.Context, accessJWT string) (*utils.AccessClaims, error)
+	Revoke(ctx context.Context, token string, hint TokenTypeHint) error
+	AccessTokenTTL() time.Duration
+	Run(ctx context.Context)
+}
+
+// Memberships tells which organizations persons are members of.
+type Memberships interface {
+	IsMember(ctx context.Context, organizationID, personID uint) (bool, error)
+}
+
+// Groups tells which groups persons are members of.
+type Groups interface {
+	GroupNames(ctx context.Context, personID uint) ([]string, error)
 }
 
 type authenticationService struct {
 	personService   person.PersonService
+	clientService   client.ClientService
+	mfaService      mfa.MFAService
+	passkeyService  passkey.PasskeyService
+	rbacService     rbac.RBACService
+	memberships     Memberships
+	groups          Groups
 	recordRepo      RecordRepository
+	denylistRepo    DenylistRepository
+	lockoutRepo     LockoutRepository
+	events          security.EventPublisher
 	logger          *zap.Logger
-	accessSecret    string
-	refreshSecret   string
+	accessKeys      utils.KeyRing
+	refreshKeys     utils.KeyRing
 	accessTokenTTL  time.Duration
 	refreshTokenTTL time.Duration
+	// requireVerifiedEmail refuses login until the person confirmed their address
+	requireVerifiedEmail bool
+	lockoutPolicy        LockoutPolicy
 }
 
 func NewAuthenticationService(
 	personService person.PersonService,
+	clientService client.ClientService,
+	mfaService mfa.MFAService,
+	passkeyService passkey.PasskeyService,
+	rbacService rbac.RBACService,
+	memberships Memberships,
+	groups Groups,
 	recordRepo RecordRepository,
+	denylistRepo DenylistRepository,
+	lockoutRepo LockoutRepository,
+	events security.EventPublisher,
 	logger *zap.Logger,
-	accessSecret string,
+	accessKeys utils.KeyRing,
 	accessTTL time.Duration,
-	refreshSecret string,
+	refreshKeys utils.KeyRing,
 	refreshTTL time.Duration,
+	requireVerifiedEmail bool,
+	lockoutPolicy LockoutPolicy,
 ) AuthenticationService {
 	return &authenticationService{
 		personService:   personService,
+		clientService:   clientService,
+		mfaService:      mfaService,
+		passkeyService:  passkeyService,
+		rbacService:     rbacService,
+		memberships:     memberships,
+		groups:          groups,
 		recordRepo:      recordRepo,
+		denylistRepo:    denylistRepo,
+		lockoutRepo:     lockoutRepo,
+		events:          events,
 		logger:          logger,
-		accessSecret:    accessSecret,
-		refreshSecret:   refreshSecret,
+		accessKeys:      accessKeys,
+		refreshKeys:     refreshKeys,
 		accessTokenTTL:  accessTTL,
 		refreshTokenTTL: refreshTTL,
+
+		requireVerifiedEmail: requireVerifiedEmail,
+		lockoutPolicy:        lockoutPolicy,
+	}
+}
+
+// Login checks the password and issues tokens, unless the person has a
+// second factor: then only an MFA token is returned, to be exchanged for
+// the tokens with CompleteMFALogin or CompletePasskeyMFA.
+func (a *authenticationService) Login(ctx context.Context, email, password string) (*LoginResult, error) {
+	user, err := a.Authenticate(ctx, email, password)
+	if err != nil {
+		return nil, err
+	}
+	return a.LoginPerson(ctx, user)
+}
+
+// LoginPerson continues a login whose first factor was checked elsewhere,
+// such as by a magic link, exactly like Login does after the password.
+func (a *authenticationService) LoginPerson(ctx context.Context, user *person.Person) (*LoginResult, error) {
+	if err := a.checkVerified(user); err != nil {
+		return nil, err
+	}
+	// refuse an unattainable scope before the second factor is spent
+	scope, err := a.loginScope(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	methods, err := a.secondFactors(ctx, user.ID)
+	if err != nil {
+		a.logger.Error("failed to check mfa enrollment", zap.Error(err))
+		return nil, ErrLoginFailed
+	}
+	if len(methods) > 0 {
+		// the scope is kept with the challenge, the second step cannot change it
+		mfaToken, err := a.mfaService.StartChallenge(ctx, user.ID, scope)
+		if err != nil {
+			return nil, ErrLoginFailed
+		}
+		return &LoginResult{MFAToken: mfaToken, MFAMethods: methods}, nil
+	}
+
+	access, refresh, err := a.IssueTokens(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	a.clearFailures(ctx, user.ID)
+	return &LoginResult{AccessToken: access, RefreshToken: refresh}, nil
+}
+
+// secondFactors lists the second factor methods the person can use.
+// Recovery codes only count next to another factor.
+func (a *authenticationService) secondFactors(ctx context.Context, personID uint) ([]string, error) {
+	var methods []string
+	factors, err := a.mfaService.Factors(ctx, personID)
+	if err != nil {
+		return nil, err
+	}
+	if factors.TOTP {
+		methods = append(methods, MethodTOTP)
+	}
+	if factors.Email {
+		methods = append(methods, MethodEmail)
+	}
+	hasPasskeys, err := a.passkeyService.HasCredentials(ctx, personID)
+	if err != nil {
+		return nil, err
+	}
+	if hasPasskeys {
+		methods = append(methods, MethodPasskey)
+	}
+	if len(methods) > 0 && factors.RecoveryCodes > 0 {
+		methods = append(methods, MethodRecoveryCode)
+	}
+	return methods, nil
+}
+
+// CompleteMFALogin finishes a login started by Login with a code from the
+// person's authenticator app or email, or a recovery code.
+func (a *authenticationService) CompleteMFALogin(ctx context.Context, mfaToken, code string) (string, string, error) {
+	challenge, err := a.mfaService.ResolveChallenge(ctx, mfaToken, func(personID uint) error {
+		return a.attemptSecondFactor(ctx, personID, func() error {
+			return a.mfaService.VerifyCode(ctx, personID, code)
+		})
+	})
+	return a.finishMFALogin(ctx, challenge, err)
+}
+
+// SendMFAEmailCode emails a code for a login started by Login.
+func (a *authenticationService) SendMFAEmailCode(ctx context.Context, mfaToken string) error {
+	personID, err := a.mfaService.ChallengePerson(ctx, mfaToken)
+	if errors.Is(err, mfa.ErrChallengeNotFound) {
+		return ErrInvalidMFAToken
+	}
+	if err != nil {
+		return err
+	}
+	user, err := a.personService.ReadPersonByID(ctx, personID)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return ErrInvalidMFAToken
+	}
+	if err != nil {
+		return err
+	}
+	err = a.mfaService.SendEmailCode(ctx, user)
+	if errors.Is(err, mfa.ErrNotEnrolled) {
+		return ErrNoEmailFactor
 	}
+	return err
 }
 
-func (a *authenticationService) Login(ctx context.Context, email, password string) (string, string, error) {
-	// 1) Validate credentials
+// BeginPasskeyMFA starts a passkey assertion for a login started by Login.
+func (a *authenticationService) BeginPasskeyMFA(ctx context.Context, mfaToken string) (*passkey.RequestOptions, error) {
+	personID, err := a.mfaService.ChallengePerson(ctx, mfaToken)
+	if errors.Is(err, mfa.ErrChallengeNotFound) {
+		return nil, ErrInvalidMFAToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	options, err := a.passkeyService.BeginLogin(ctx, &personID)
+	if errors.Is(err, passkey.ErrNoCredentials) {
+		return nil, ErrNoPasskey
+	}
+	return options, err
+}
+
+// CompletePasskeyMFA finishes a login started by Login with the answer to
+// BeginPasskeyMFA.
+func (a *authenticationService) CompletePasskeyMFA(
+	ctx context.Context,
+	mfaToken string,
+	resp *passkey.AssertionResponse,
+) (string, string, error) {
+	challenge, err := a.mfaService.ResolveChallenge(ctx, mfaToken, func(personID uint) error {
+		return a.attemptSecondFactor(ctx, personID, func() error {
+			_, err := a.passkeyService.FinishLogin(ctx, resp, &personID)
+			return err
+		})
+	})
+	return a.finishMFALogin(ctx, challenge, err)
+}
+
+// attemptSecondFactor runs verify unless the person's account is locked,
+// and counts a rejected second factor towards the lockout like a wrong
+// password, so codes cannot be guessed one challenge after another.
+func (a *authenticationService) attemptSecondFactor(ctx context.Context, personID uint, verify func() error) error {
+	if _, err := a.checkLockout(ctx, personID); err != nil {
+		return err
+	}
+	err := verify()
+	if errors.Is(err, mfa.ErrInvalidCode) || errors.Is(err, passkey.ErrInvalidCredential) {
+		a.recordFailure(ctx, personID)
+	}
+	return err
+}
+
+// finishMFALogin issues tokens, with the scope granted at the first step,
+// once the MFA challenge of a login resolved, or translates why it did not.
+func (a *authenticationService) finishMFALogin(ctx context.Context, challenge *mfa.Challenge, err error) (string, string, error) {
+	var locked *AccountLockedError
+	switch {
+	case err == nil:
+	case errors.As(err, &locked), errors.Is(err, ErrLoginFailed):
+		return "", "", err
+	case errors.Is(err, mfa.ErrInvalidCode):
+		return "", "", ErrInvalidMFACode
+	case errors.Is(err, passkey.ErrInvalidCredential):
+		return "", "", ErrInvalidPasskey
+	case errors.Is(err, mfa.ErrChallengeNotFound),
+		errors.Is(err, mfa.ErrTooManyAttempts),
+		errors.Is(err, mfa.ErrNotEnrolled):
+		return "", "", ErrInvalidMFAToken
+	default:
+		a.logger.Error("failed to complete mfa challenge", zap.Error(err))
+		return "", "", ErrLoginFailed
+	}
+
+	user, err := a.personService.ReadPersonByID(ctx, challenge.PersonID)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return "", "", ErrInvalidMFAToken
+	}
+	if err != nil {
+		return "", "", ErrLoginFailed
+	}
+	access, refresh, err := a.IssueTokens(WithScope(ctx, challenge.Scope), user)
+	if err != nil {
+		return "", "", err
+	}
+	a.clearFailures(ctx, challenge.PersonID)
+	return access, refresh, nil
+}
+
+// BeginPasskeyLogin starts a passwordless login; the browser lets the
+// person pick any of their passkeys for this site.
+func (a *authenticationService) BeginPasskeyLogin(ctx context.Context) (*passkey.RequestOptions, error) {
+	return a.passkeyService.BeginLogin(ctx, nil)
+}
+
+// CompletePasskeyLogin issues tokens to the person whose passkey answered
+// BeginPasskeyLogin. The passkey stands in for both password and second
+// factor, as the authenticator verified the person itself.
+func (a *authenticationService) CompletePasskeyLogin(ctx context.Context, resp *passkey.AssertionResponse) (string, string, error) {
+	personID, err := a.passkeyService.FinishLogin(ctx, resp, nil)
+	if errors.Is(err, passkey.ErrInvalidCredential) {
+		return "", "", ErrInvalidPasskey
+	}
+	if err != nil {
+		a.logger.Error("failed to verify passkey login", zap.Error(err))
+		return "", "", ErrLoginFailed
+	}
+
+	user, err := a.personService.ReadPersonByID(ctx, personID)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return "", "", ErrInvalidPasskey
+	}
+	if err != nil {
+		return "", "", ErrLoginFailed
+	}
+	if err := a.checkVerified(user); err != nil {
+		return "", "", err
+	}
+	return a.IssueTokens(ctx, user)
+}
+
+// Authenticate checks a person's password without issuing any tokens.
+// Failed attempts count towards the lockout policy; while the account is
+// locked the password is not checked at all. A correct password does not
+// forget earlier failures, as the second factor may still fail; they are
+// cleared once the whole login succeeds.
+func (a *authenticationService) Authenticate(ctx context.Context, email, password string) (*person.Person, error) {
 	user, err := a.personService.ReadPersonByEmail(ctx, email)
 	if err != nil {
 		if errors.Is(err, person.ErrPersonNotFound) {
-			return "", "", ErrInvalidCredentials
+			return nil, ErrInvalidCredentials
 		}
-		return "", "", ErrLoginFailed
+		return nil, ErrLoginFailed
+	}
+	if _, err := a.checkLockout(ctx, user.ID); err != nil {
+		return nil, err
 	}
 	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
-		return "", "", ErrInvalidCredentials
+		a.recordFailure(ctx, user.ID)
+		return nil, ErrInvalidCredentials
+	}
+	if err := a.checkVerified(user); err != nil {
+		return nil, err
 	}
+	return user, nil
+}
 
-	// 2) Issue Access Token
-	accessJWT, err := utils.IssueAccessToken(
-		strconv.Itoa(int(user.ID)),
-		user.Role,
-		a.accessSecret,
-		a.accessTokenTTL,
-	)
+// lockoutEnabled reports whether failed logins are counted at all.
+func (a *authenticationService) lockoutEnabled() bool {
+	return a.lockoutPolicy.DelayAfter > 0 || a.lockoutPolicy.Threshold > 0
+}
+
+// checkLockout refuses the attempt while the account is locked or the
+// delay after the last failure has not passed, and returns the person's
+// failed login record if there is one.
+func (a *authenticationService) checkLockout(ctx context.Context, personID uint) (*Lockout, error) {
+	if !a.lockoutEnabled() {
+		return nil, nil
+	}
+	lockout, err := a.lockoutRepo.ReadByPersonID(ctx, personID)
+	if errors.Is(err, ErrLockoutNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		a.logger.Error("failed to read failed logins", zap.Error(err))
+		return nil, ErrLoginFailed
+	}
+	if retryAt := a.retryAt(lockout); retryAt != nil && time.Now().Before(*retryAt) {
+		return nil, &AccountLockedError{RetryAt: *retryAt}
+	}
+	return lockout, nil
+}
+
+// retryAt is when the policy accepts the next attempt, nil if it never
+// held one back.
+func (a *authenticationService) retryAt(lockout *Lockout) *time.Time {
+	policy := a.lockoutPolicy
+	var retryAt *time.Time
+	if lockout.LockedUntil != nil {
+		retryAt = lockout.LockedUntil
+	}
+	if policy.DelayAfter > 0 && lockout.FailedAttempts >= policy.DelayAfter {
+		delay := policy.BaseDelay
+		for i := policy.DelayAfter; i < lockout.FailedAttempts && delay < policy.MaxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, policy.MaxDelay)
+		delayedUntil := lockout.LastFailedAt.Add(delay)
+		if retryAt == nil || delayedUntil.After(*retryAt) {
+			retryAt = &delayedUntil
+		}
+	}
+	return retryAt
+}
+
+// clearFailures forgets the failed logins of a person who just logged in.
+func (a *authenticationService) clearFailures(ctx context.Context, personID uint) {
+	if !a.lockoutEnabled() {
+		return
+	}
+	if err := a.lockoutRepo.DeleteByPersonID(ctx, personID); err != nil && !errors.Is(err, ErrLockoutNotFound) {
+		a.logger.Error("failed to reset failed logins", zap.Uint("personID", personID), zap.Error(err))
+	}
+}
+
+// recordFailure counts a wrong password or second factor and locks the account once the
+// threshold is reached.
+func (a *authenticationService) recordFailure(ctx context.Context, personID uint) {
+	if !a.lockoutEnabled() {
+		return
+	}
+	now := time.Now()
+	lockout, err := a.lockoutRepo.RecordFailure(ctx, personID, now)
+	if err != nil {
+		a.logger.Error("failed to record failed login", zap.Uint("personID", personID), zap.Error(err))
+		return
+	}
+	if a.lockoutPolicy.Threshold == 0 || lockout.FailedAttempts < a.lockoutPolicy.Threshold {
+		return
+	}
+	until := now.Add(a.lockoutPolicy.Duration)
+	if err := a.lockoutRepo.Lock(ctx, personID, until); err != nil {
+		a.logger.Error("failed to lock account", zap.Uint("personID", personID), zap.Error(err))
+		return
+	}
+	a.events.Publish(ctx, security.NewEvent(security.AccountLocked, personID, map[string]string{
+		"failedAttempts": strconv.Itoa(lockout.FailedAttempts),
+		"lockedUntil":    until.UTC().Format(time.RFC3339),
+	}))
+}
+
+// ReadLockout reports the person's failed logins and when the next
+// attempt is accepted.
+func (a *authenticationService) ReadLockout(ctx context.Context, personID uint) (*LockoutStatus, error) {
+	if _, err := a.personService.ReadPersonByID(ctx, personID); err != nil {
+		return nil, err
+	}
+	lockout, err := a.lockoutRepo.ReadByPersonID(ctx, personID)
+	if errors.Is(err, ErrLockoutNotFound) {
+		return &LockoutStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	status := &LockoutStatus{
+		FailedAttempts: lockout.FailedAttempts,
+		LastFailedAt:   &lockout.LastFailedAt,
+	}
+	if retryAt := a.retryAt(lockout); retryAt != nil && time.Now().Before(*retryAt) {
+		status.RetryAt = retryAt
+	}
+	return status, nil
+}
+
+// ClearLockout unlocks the account and forgets its failed logins.
+func (a *authenticationService) ClearLockout(ctx context.Context, personID uint) error {
+	if _, err := a.personService.ReadPersonByID(ctx, personID); err != nil {
+		return err
+	}
+	err := a.lockoutRepo.DeleteByPersonID(ctx, personID)
+	if err != nil && !errors.Is(err, ErrLockoutNotFound) {
+		return err
+	}
+	return nil
+}
+
+// checkVerified refuses people with an unconfirmed address, if required.
+func (a *authenticationService) checkVerified(user *person.Person) error {
+	if a.requireVerifiedEmail && !user.EmailVerified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// VerifySecondFactor checks code against the person's second factor, for
+// login forms that ask for password and code at once. People without a
+// second factor pass with any code; people whose only second factor is a
+// passkey cannot pass, as a form cannot run a WebAuthn ceremony. When the
+// code is missing and email is the person's way to get one, it is sent.
+func (a *authenticationService) VerifySecondFactor(ctx context.Context, user *person.Person, code string) error {
+	methods, err := a.secondFactors(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(methods) == 0:
+		a.clearFailures(ctx, user.ID)
+		return nil
+	case slices.Equal(methods, []string{MethodPasskey}):
+		return ErrPasskeyRequired
+	case code == "":
+		if slices.Contains(methods, MethodEmail) && !slices.Contains(methods, MethodTOTP) {
+			if err := a.mfaService.SendEmailCode(ctx, user); err != nil {
+				return err
+			}
+		}
+		return ErrMFARequired
+	}
+	err = a.attemptSecondFactor(ctx, user.ID, func() error {
+		return a.mfaService.VerifyCode(ctx, user.ID, code)
+	})
+	if errors.Is(err, mfa.ErrInvalidCode) {
+		return ErrInvalidMFACode
+	}
+	if err != nil {
+		return err
+	}
+	a.clearFailures(ctx, user.ID)
+	return nil
+}
+
+// IssueTokens starts a new session for an authenticated person: an access
+// token and the first refresh token of a new family. The session is limited
+// to the scope in ctx, see WithScope, and bound to the client in ctx, see
+// WithClientID.
+func (a *authenticationService) IssueTokens(ctx context.Context, user *person.Person) (string, string, error) {
+	scope, err := a.loginScope(ctx, user.ID)
+	if err != nil {
+		return "", "", err
+	}
+
+	// 1) Issue Access Token, bound to the token family this login starts
+	familyID := uuid.NewString()
+	accessJWT, err := a.issueAccessToken(ctx, user, 0, familyID, clientIDFrom(ctx), scope)
 	if err != nil {
 		return "", "", err
 	}
 
-	// 3) Generate & store Refresh Token with retry-on-duplicate
+	// 2) Generate & store Refresh Token with retry-on-duplicate
 	var refreshJWT string
+	client := clientInfoFrom(ctx)
 	for {
 		jti := uuid.NewString()
 		sum := sha256.Sum256([]byte(jti))
 		rec := &RefreshTokenRecord{
 			PersonID:     user.ID,
+			FamilyID:     familyID,
 			RefreshToken: hex.EncodeToString(sum[:]),
 			ExpiresAt:    time.Now().Add(a.refreshTokenTTL),
+			UserAgent:    client.UserAgent,
+			IPAddress:    client.IPAddress,
+			DeviceLabel:  deviceLabel(client.UserAgent),
+			LastUsedAt:   time.Now(),
+			Scope:        scope,
+			ClientID:     clientIDFrom(ctx),
 		}
 
 		if err := a.recordRepo.Create(ctx, rec); err != nil {
@@ -105,7 +629,7 @@ func (a *authenticationService) Login(ctx context.Context, email, password strin
 		refreshJWT, err = utils.IssueRefreshToken(
 			strconv.Itoa(int(user.ID)),
 			jti,
-			a.refreshSecret,
+			a.refreshKeys,
 			a.refreshTokenTTL,
 		)
 		if err != nil {
@@ -117,9 +641,17 @@ func (a *authenticationService) Login(ctx context.Context, email, password strin
 	return accessJWT, refreshJWT, nil
 }
 
+// IssueClientToken issues an access token to a client acting on its own
+// behalf. There is no refresh token; the client authenticates again instead.
+func (a *authenticationService) IssueClientToken(ctx context.Context, cl *client.Client, scope string) (string, error) {
+	return utils.IssueClientAccessToken(cl.ClientID, scope, a.accessKeys, a.accessTokenTTL)
+}
+
+// Refresh rotates a refresh token of a session of the client in ctx, see
+// WithClientID, and issues a new access token for the session.
 func (a *authenticationService) Refresh(ctx context.Context, refreshJWT string) (string, string, error) {
 	// 1) Parse & validate incoming refresh JWT
-	claims, err := utils.ParseRefreshToken(refreshJWT, a.refreshSecret)
+	claims, err := utils.ParseRefreshToken(refreshJWT, a.refreshKeys)
 	if err != nil {
 		return "", "", ErrInvalidRefreshToken
 	}
@@ -127,6 +659,10 @@ func (a *authenticationService) Refresh(ctx context.Context, refreshJWT string)
 	// 2) Look up JTI in DB
 	hash := sha256.Sum256([]byte(claims.ID))
 	rec, err := a.recordRepo.ReadByToken(ctx, hex.EncodeToString(hash[:]))
+	if errors.Is(err, ErrRecordNotFoundByGivenToken) {
+		a.detectReuse(ctx, hex.EncodeToString(hash[:]))
+		return "", "", ErrInvalidRefreshToken
+	}
 	if err != nil {
 		return "", "", ErrInvalidRefreshToken
 	}
@@ -136,6 +672,10 @@ func (a *authenticationService) Refresh(ctx context.Context, refreshJWT string)
 		_ = a.recordRepo.DeleteByToken(ctx, hex.EncodeToString(hash[:]))
 		return "", "", ErrInvalidRefreshToken
 	}
+	// only the client the session was started for may refresh it
+	if rec.ClientID != clientIDFrom(ctx) {
+		return "", "", ErrInvalidRefreshToken
+	}
 
 	// 4) Issue new Access Token
 	userID := rec.PersonID
@@ -143,12 +683,11 @@ func (a *authenticationService) Refresh(ctx context.Context, refreshJWT string)
 	if err != nil {
 		return "", "", ErrLoginFailed
 	}
-	accessJWT, err := utils.IssueAccessToken(
-		strconv.Itoa(int(user.ID)),
-		user.Role,
-		a.accessSecret,
-		a.accessTokenTTL,
-	)
+	organizationID, err := a.sessionOrganization(ctx, rec)
+	if err != nil {
+		return "", "", err
+	}
+	accessJWT, err := a.issueAccessToken(ctx, user, organizationID, rec.FamilyID, rec.ClientID, rec.Scope)
 	if err != nil {
 		return "", "", err
 	}
@@ -158,7 +697,7 @@ func (a *authenticationService) Refresh(ctx context.Context, refreshJWT string)
 	newRefreshJWT, err := utils.IssueRefreshToken(
 		strconv.Itoa(int(user.ID)),
 		newJTI,
-		a.refreshSecret,
+		a.refreshKeys,
 		a.refreshTokenTTL,
 	)
 	if err != nil {
@@ -170,21 +709,611 @@ func (a *authenticationService) Refresh(ctx context.Context, refreshJWT string)
 		hex.EncodeToString(hash[:]),
 		hex.EncodeToString(newHash[:]),
 		time.Now().Add(a.refreshTokenTTL),
+		clientInfoFrom(ctx),
 	); err != nil {
+		if errors.Is(err, ErrRecordNotFoundByGivenToken) {
+			// a concurrent request rotated the same token first
+			a.detectReuse(ctx, hex.EncodeToString(hash[:]))
+			return "", "", ErrInvalidRefreshToken
+		}
 		return "", "", ErrLoginFailed
 	}
 
 	return accessJWT, newRefreshJWT, nil
 }
 
-func (a *authenticationService) Logout(ctx context.Context, refreshJWT string) error {
-	claims, err := utils.ParseRefreshToken(refreshJWT, a.refreshSecret)
+func (a *authenticationService) Logout(ctx context.Context, refreshJWT, accessJWT string) error {
+	claims, err := utils.ParseRefreshToken(refreshJWT, a.refreshKeys)
 	if err != nil {
 		return ErrInvalidRefreshToken
 	}
 	hash := sha256.Sum256([]byte(claims.ID))
-	if err := a.recordRepo.DeleteByToken(ctx, hex.EncodeToString(hash[:])); err != nil {
+	rec, err := a.recordRepo.ReadByToken(ctx, hex.EncodeToString(hash[:]))
+	if err != nil {
+		return ErrInvalidRefreshToken
+	}
+	// sessions of clients end at the client-only revocation endpoint
+	if rec.ClientID != "" {
 		return ErrInvalidRefreshToken
 	}
+	if rec.FamilyID == "" {
+		if err := a.recordRepo.DeleteByToken(ctx, rec.RefreshToken); err != nil {
+			return ErrInvalidRefreshToken
+		}
+		return nil
+	}
+	if err := a.recordRepo.DeleteByFamilyID(ctx, rec.FamilyID); err != nil {
+		return ErrInvalidRefreshToken
+	}
+
+	if accessJWT == "" {
+		return nil
+	}
+	access, err := utils.ParseAccessToken(accessJWT, a.accessKeys)
+	if err != nil || access.SessionID != rec.FamilyID {
+		return nil
+	}
+	_, err = a.revokeAccessToken(ctx, accessJWT)
+	return err
+}
+
+// RevokeSessions ends every session of a person by revoking all their
+// refresh tokens.
+func (a *authenticationService) RevokeSessions(ctx context.Context, personID uint) error {
+	return revokeSessions(ctx, a.recordRepo, personID)
+}
+
+func revokeSessions(ctx context.Context, recordRepo RecordRepository, personID uint) error {
+	err := recordRepo.DeleteByPersonID(ctx, personID)
+	if err != nil && !errors.Is(err, ErrRecordNotFoundByGivenPersonID) {
+		return err
+	}
 	return nil
 }
+
+type sessionStore struct {
+	recordRepo RecordRepository
+}
+
+// NewSessionStore revokes sessions straight on the record repository, for
+// services the authentication service itself depends on.
+func NewSessionStore(recordRepo RecordRepository) person.SessionRevoker {
+	return &sessionStore{recordRepo: recordRepo}
+}
+
+func (s *sessionStore) RevokeSessions(ctx context.Context, personID uint) error {
+	return revokeSessions(ctx, s.recordRepo, personID)
+}
+
+// ListSessions returns the person's unexpired sessions.
+func (a *authenticationService) ListSessions(ctx context.Context, personID uint, currentSessionID string) ([]Session, error) {
+	records, err := a.recordRepo.ListByPersonID(ctx, personID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(records))
+	for _, rec := range records {
+		sessions = append(sessions, Session{
+			ID:          rec.ID,
+			DeviceLabel: rec.DeviceLabel,
+			UserAgent:   rec.UserAgent,
+			IPAddress:   rec.IPAddress,
+			CreatedAt:   rec.CreatedAt,
+			LastUsedAt:  rec.LastUsedAt,
+			ExpiresAt:   rec.ExpiresAt,
+			Current:     currentSessionID != "" && rec.FamilyID == currentSessionID,
+
+			OrganizationID: rec.OrganizationID,
+			Scope:          rec.Scope,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of the person's sessions, with the access tokens
+// already issued to it.
+func (a *authenticationService) RevokeSession(ctx context.Context, personID, sessionID uint) error {
+	rec, err := a.recordRepo.ReadByID(ctx, sessionID)
+	if errors.Is(err, ErrRecordNotFoundByGivenID) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	// sessions of others are reported as missing
+	if rec.PersonID != personID {
+		return ErrSessionNotFound
+	}
+	if rec.FamilyID == "" {
+		err = a.recordRepo.Delete(ctx, sessionID)
+		if errors.Is(err, ErrRecordNotFoundByGivenID) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	// the session's access tokens stop working along with its family
+	err = a.recordRepo.DeleteByFamilyID(ctx, rec.FamilyID)
+	if errors.Is(err, ErrRecordNotFoundByGivenFamilyID) {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+// RevokeOtherSessions ends every session of the person but the current one.
+func (a *authenticationService) RevokeOtherSessions(ctx context.Context, personID uint, currentSessionID string) error {
+	if currentSessionID == "" {
+		return a.RevokeSessions(ctx, personID)
+	}
+	_, err := a.recordRepo.DeleteByPersonIDExcept(ctx, personID, currentSessionID)
+	return err
+}
+
+func (a *authenticationService) SwitchOrganization(ctx context.Context, user *person.Person, sessionID string, organizationID uint) (string, error) {
+	// tokens from before sessions existed name none to switch
+	if sessionID == "" {
+		return "", ErrSessionNotFound
+	}
+	if organizationID != 0 {
+		if err := a.CheckMembership(ctx, user.ID, organizationID); err != nil {
+			return "", err
+		}
+	}
+	rec, err := a.recordRepo.SetOrganization(ctx, user.ID, sessionID, organizationID)
+	if errors.Is(err, ErrRecordNotFoundByGivenFamilyID) {
+		return "", ErrSessionNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return a.issueAccessToken(ctx, user, organizationID, sessionID, rec.ClientID, rec.Scope)
+}
+
+// issueAccessToken issues an access token for a session of user started for
+// clientID, acting in organizationID unless it is 0, with the person's
+// current roles and groups.
+// Its scope is what remains of requested, the session's scope, that the
+// person may still grant.
+func (a *authenticationService) issueAccessToken(
+	ctx context.Context,
+	user *person.Person,
+	organizationID uint,
+	sessionID string,
+	clientID string,
+	requested string,
+) (string, error) {
+	roles, err := a.rbacService.RoleNames(ctx, user.ID, organizationID)
+	if err != nil {
+		return "", err
+	}
+	groups, err := a.groups.GroupNames(ctx, user.ID)
+	if err != nil {
+		a.logger.Error("failed to read groups", zap.Uint("personID", user.ID), zap.Error(err))
+		return "", err
+	}
+	scope, _, err := a.grantScope(ctx, user.ID, organizationID, requested)
+	if err != nil {
+		return "", err
+	}
+	return utils.IssueAccessToken(
+		strconv.Itoa(int(user.ID)),
+		roles,
+		groups,
+		scope,
+		organizationID,
+		sessionID,
+		clientID,
+		user.TokenVersion,
+		a.accessKeys,
+		a.accessTokenTTL,
+	)
+}
+
+// CheckScope returns ErrInvalidScope unless the person may grant all of the
+// space separated scope.
+func (a *authenticationService) CheckScope(ctx context.Context, personID uint, scope string) error {
+	_, err := a.loginScope(WithScope(ctx, scope), personID)
+	return err
+}
+
+// loginScope returns the scope in ctx, failing with ErrInvalidScope if the
+// person may not grant all of it.
+func (a *authenticationService) loginScope(ctx context.Context, personID uint) (string, error) {
+	requested := strings.Join(strings.Fields(requestedScope(ctx)), " ")
+	_, complete, err := a.grantScope(ctx, personID, 0, requested)
+	if err != nil {
+		return "", err
+	}
+	if !complete {
+		return "", ErrInvalidScope
+	}
+	return requested, nil
+}
+
+// grantScope returns the part of the requested scope the person may grant
+// acting in organizationID: the identity scopes and their permissions, or
+// all of their permissions if requested is empty. complete reports whether
+// that is all of requested.
+func (a *authenticationService) grantScope(
+	ctx context.Context,
+	personID, organizationID uint,
+	requested string,
+) (scope string, complete bool, err error) {
+	permissions, err := a.rbacService.PermissionNames(ctx, personID, organizationID)
+	if err != nil {
+		a.logger.Error("failed to read permissions", zap.Uint("personID", personID), zap.Error(err))
+		return "", false, err
+	}
+	if requested == "" {
+		return strings.Join(permissions, " "), true, nil
+	}
+	permitted := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		permitted[permission] = true
+	}
+	var granted []string
+	complete = true
+	for _, s := range strings.Fields(requested) {
+		if identityScopes[s] || permitted[s] {
+			granted = append(granted, s)
+		} else {
+			complete = false
+		}
+	}
+	return strings.Join(granted, " "), complete, nil
+}
+
+func (a *authenticationService) CheckMembership(ctx context.Context, personID, organizationID uint) error {
+	member, err := a.memberships.IsMember(ctx, organizationID, personID)
+	if err != nil {
+		a.logger.Error("failed to check membership",
+			zap.Uint("personID", personID), zap.Uint("organizationID", organizationID), zap.Error(err))
+		return err
+	}
+	if !member {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// sessionOrganization returns the organization a session acts in. A session
+// whose person has left the organization acts in none.
+func (a *authenticationService) sessionOrganization(ctx context.Context, rec *RefreshTokenRecord) (uint, error) {
+	if rec.OrganizationID == 0 {
+		return 0, nil
+	}
+	err := a.CheckMembership(ctx, rec.PersonID, rec.OrganizationID)
+	if errors.Is(err, ErrNotMember) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return rec.OrganizationID, nil
+}
+
+// detectReuse revokes the whole token family when hash belongs to a refresh
+// token that has already been rotated away: either the legitimate client or
+// an attacker holds a stolen copy, and we cannot tell which.
+func (a *authenticationService) detectReuse(ctx context.Context, hash string) {
+	superseded, err := a.recordRepo.ReadSupersededByToken(ctx, hash)
+	if err != nil {
+		if !errors.Is(err, ErrRecordNotFoundByGivenToken) {
+			a.logger.Error("failed to check refresh token reuse", zap.Error(err))
+		}
+		return
+	}
+
+	if err := a.recordRepo.DeleteByFamilyID(ctx, superseded.FamilyID); err != nil &&
+		!errors.Is(err, ErrRecordNotFoundByGivenFamilyID) {
+		a.logger.Error("failed to revoke refresh token family",
+			zap.String("familyID", superseded.FamilyID), zap.Error(err))
+	}
+	a.events.Publish(ctx, security.NewEvent(security.RefreshTokenReuse, superseded.PersonID, map[string]string{
+		"familyID": superseded.FamilyID,
+	}))
+}
+
+// Introspect reports whether token is an access or refresh token this
+// service issued and that is still valid, to whichever client; callers
+// check the reported client ID themselves. The hint only decides which
+// kind is tried first. Inactive tokens are not an error.
+func (a *authenticationService) Introspect(ctx context.Context, token string, hint TokenTypeHint) (*TokenIntrospection, error) {
+	checks := []func(context.Context, string) (*TokenIntrospection, error){
+		a.introspectAccessToken,
+		a.introspectRefreshToken,
+	}
+	if hint == RefreshTokenHint {
+		checks[0], checks[1] = checks[1], checks[0]
+	}
+
+	for _, check := range checks {
+		result, err := check(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		if result.Active {
+			return result, nil
+		}
+	}
+	return &TokenIntrospection{Active: false}, nil
+}
+
+func (a *authenticationService) introspectAccessToken(ctx context.Context, token string) (*TokenIntrospection, error) {
+	claims, err := a.ValidateAccessToken(ctx, token)
+	if errors.Is(err, ErrInvalidAccessToken) || errors.Is(err, ErrAccessTokenRevoked) {
+		return &TokenIntrospection{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if claims.IsClient() {
+		return a.introspectClientToken(ctx, claims)
+	}
+	user, err := a.activePerson(ctx, claims.Subject)
+	if err != nil || user == nil {
+		return &TokenIntrospection{Active: false}, err
+	}
+	if claims.TokenVersion != user.TokenVersion {
+		return &TokenIntrospection{Active: false}, nil
+	}
+	if claims.OrganizationID != 0 {
+		err := a.CheckMembership(ctx, user.ID, claims.OrganizationID)
+		if errors.Is(err, ErrNotMember) {
+			return &TokenIntrospection{Active: false}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	roles, err := a.rbacService.RoleNames(ctx, user.ID, claims.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := a.groups.GroupNames(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenIntrospection{
+		Active:         true,
+		TokenType:      AccessTokenHint,
+		Subject:        claims.Subject,
+		ClientID:       claims.ClientID,
+		OrganizationID: claims.OrganizationID,
+		Roles:          roles,
+		Groups:         groups,
+		Scope:          claims.Scope,
+		IssuedAt:       claims.IssuedAt.Time,
+		ExpiresAt:      claims.ExpiresAt.Time,
+	}, nil
+}
+
+// introspectClientToken reports a client token as active while the client
+// is still registered.
+func (a *authenticationService) introspectClientToken(ctx context.Context, claims *utils.AccessClaims) (*TokenIntrospection, error) {
+	_, err := a.clientService.ReadClientByClientID(ctx, claims.Subject)
+	if errors.Is(err, client.ErrClientNotFound) {
+		return &TokenIntrospection{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &TokenIntrospection{
+		Active:    true,
+		TokenType: AccessTokenHint,
+		Subject:   claims.Subject,
+		ClientID:  claims.Subject,
+		Scope:     claims.Scope,
+		IssuedAt:  claims.IssuedAt.Time,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+func (a *authenticationService) introspectRefreshToken(ctx context.Context, token string) (*TokenIntrospection, error) {
+	claims, err := utils.ParseRefreshToken(token, a.refreshKeys)
+	if err != nil {
+		return &TokenIntrospection{Active: false}, nil
+	}
+	hash := sha256.Sum256([]byte(claims.ID))
+	rec, err := a.recordRepo.ReadByToken(ctx, hex.EncodeToString(hash[:]))
+	if errors.Is(err, ErrRecordNotFoundByGivenToken) {
+		return &TokenIntrospection{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(rec.ExpiresAt) {
+		return &TokenIntrospection{Active: false}, nil
+	}
+	user, err := a.activePerson(ctx, claims.Subject)
+	if err != nil || user == nil {
+		return &TokenIntrospection{Active: false}, err
+	}
+	organizationID, err := a.sessionOrganization(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := a.rbacService.RoleNames(ctx, user.ID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := a.groups.GroupNames(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	scope, _, err := a.grantScope(ctx, user.ID, organizationID, rec.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenIntrospection{
+		Active:         true,
+		TokenType:      RefreshTokenHint,
+		Subject:        claims.Subject,
+		ClientID:       rec.ClientID,
+		OrganizationID: organizationID,
+		Roles:          roles,
+		Groups:         groups,
+		Scope:          scope,
+		IssuedAt:       claims.IssuedAt.Time,
+		ExpiresAt:      claims.ExpiresAt.Time,
+	}, nil
+}
+
+// activePerson loads the person a token was issued to. A nil person with a
+// nil error means the person no longer exists or has been deleted.
+func (a *authenticationService) activePerson(ctx context.Context, subject string) (*person.Person, error) {
+	userID, err := strconv.ParseUint(subject, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	user, err := a.personService.ReadPersonByID(ctx, uint(userID))
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ValidateAccessToken checks the signature and expiry of an access token,
+// that its jti has not been denylisted and that the session it was issued
+// for, if any, has not ended.
+func (a *authenticationService) ValidateAccessToken(ctx context.Context, accessJWT string) (*utils.AccessClaims, error) {
+	claims, err := utils.ParseAccessToken(accessJWT, a.accessKeys)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	if claims.ID == "" {
+		return nil, ErrInvalidAccessToken
+	}
+
+	revoked, err := a.denylistRepo.Exists(ctx, claims.ID, time.Now())
+	if err != nil {
+		a.logger.Error("failed to check access token denylist", zap.Error(err))
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrAccessTokenRevoked
+	}
+
+	if claims.SessionID != "" {
+		live, err := a.recordRepo.FamilyExists(ctx, claims.SessionID)
+		if err != nil {
+			a.logger.Error("failed to check session", zap.String("sessionID", claims.SessionID), zap.Error(err))
+			return nil, err
+		}
+		if !live {
+			return nil, ErrAccessTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+// Revoke invalidates an access token until its expiry, or a refresh token
+// together with its whole family. Only tokens issued to the client in ctx,
+// see WithClientID, are revoked; following RFC 7009, tokens of other
+// clients are skipped like those that are invalid, expired or already
+// revoked, without an error.
+func (a *authenticationService) Revoke(ctx context.Context, token string, hint TokenTypeHint) error {
+	checks := []func(context.Context, string) (bool, error){
+		a.revokeAccessToken,
+		a.revokeRefreshToken,
+	}
+	if hint == RefreshTokenHint {
+		checks[0], checks[1] = checks[1], checks[0]
+	}
+
+	for _, check := range checks {
+		revoked, err := check(ctx, token)
+		if err != nil || revoked {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *authenticationService) revokeAccessToken(ctx context.Context, token string) (bool, error) {
+	claims, err := utils.ParseAccessToken(token, a.accessKeys)
+	if err != nil || claims.ID == "" {
+		return false, nil
+	}
+	owner := claims.ClientID
+	if claims.IsClient() {
+		owner = claims.Subject
+	}
+	if owner != clientIDFrom(ctx) {
+		return true, nil
+	}
+
+	entry := &RevokedAccessToken{
+		JTI:       claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if !claims.IsClient() {
+		if userID, err := strconv.ParseUint(claims.Subject, 10, 64); err == nil {
+			entry.PersonID = uint(userID)
+		}
+	}
+	if err := a.denylistRepo.Create(ctx, entry); err != nil {
+		a.logger.Error("failed to denylist access token", zap.Error(err))
+		return false, err
+	}
+	return true, nil
+}
+
+func (a *authenticationService) revokeRefreshToken(ctx context.Context, token string) (bool, error) {
+	claims, err := utils.ParseRefreshToken(token, a.refreshKeys)
+	if err != nil {
+		return false, nil
+	}
+	hash := sha256.Sum256([]byte(claims.ID))
+	rec, err := a.recordRepo.ReadByToken(ctx, hex.EncodeToString(hash[:]))
+	if errors.Is(err, ErrRecordNotFoundByGivenToken) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if rec.ClientID != clientIDFrom(ctx) {
+		return true, nil
+	}
+
+	if rec.FamilyID == "" {
+		err = a.recordRepo.DeleteByToken(ctx, rec.RefreshToken)
+	} else {
+		err = a.recordRepo.DeleteByFamilyID(ctx, rec.FamilyID)
+	}
+	if err != nil && !errors.Is(err, ErrRecordNotFoundByGivenToken) &&
+		!errors.Is(err, ErrRecordNotFoundByGivenFamilyID) {
+		a.logger.Error("failed to revoke refresh token", zap.Error(err))
+		return false, err
+	}
+	return true, nil
+}
+
+func (a *authenticationService) AccessTokenTTL() time.Duration {
+	return a.accessTokenTTL
+}
+
+// Run purges denylist entries and superseded refresh tokens once the
+// tokens they refer to have expired, until ctx is cancelled.
+func (a *authenticationService) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			if _, err := a.denylistRepo.DeleteExpired(ctx, now); err != nil {
+				a.logger.Error("failed to purge access token denylist", zap.Error(err))
+			}
+			if _, err := a.recordRepo.DeleteExpiredSuperseded(ctx, now); err != nil {
+				a.logger.Error("failed to purge superseded refresh tokens", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package authentication
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/keys"
+)
+
+// WellKnownHandler serves the public documents resource servers need to
+// verify tokens issued by this service.
+type WellKnownHandler struct {
+	router     *gin.RouterGroup
+	keyService keys.KeyService
+	logger     *zap.Logger
+}
+
+// NewWellKnownHandler registers the /.well-known endpoints on the given router group.
+func NewWellKnownHandler(router *gin.RouterGroup, keyService keys.KeyService, logger *zap.Logger) *WellKnownHandler {
+	h := &WellKnownHandler{router: router, keyService: keyService, logger: logger}
+	h.router.GET("/.well-known/jwks.json", h.JWKS)
+	return h
+}
+
+// JWKS godoc
+// @Summary      JSON Web Key Set
+// @Description  Public keys used to verify access token signatures, selected by kid
+// @Tags         well-known
+// @Produce      json
+// @Success      200      {object}  utils.JWKSet
+// @Failure      500      {object}  map[string]string
+// @Router       /.well-known/jwks.json [get]
+func (h *WellKnownHandler) JWKS(c *gin.Context) {
+	set, err := h.keyService.PublicKeys()
+	if err != nil {
+		h.logger.Error("failed to export signing keys", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load signing keys"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, set)
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/rbac"
+)
+
+// ContextClientKey is the key under which a client authenticated by an
+// access token of its own is stored in Gin context.
+const ContextClientKey = "client"
+
+// CreateClientRequest represents the payload for registering a client.
+// @Description payload to register a new OAuth client
+// @Property name          body string   true  "human readable client name"
+// @Property redirect_uris body []string false "allowed redirect URIs for the authorization code flow"
+// @Property public        body boolean  false "public clients (SPAs, native apps) get no secret"
+// @Property grant_types   body []string false "grant types the client may use, authorization_code and refresh_token if omitted"
+// @Property scopes        body []string false "scopes the client may request for itself with client_credentials"
+type CreateClientRequest struct {
+	Name         string   `json:"name" binding:"required,max=255"`
+	RedirectURIs []string `json:"redirect_uris" binding:"dive,url"`
+	Public       bool     `json:"public"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes" binding:"dive,required"`
+}
+
+// CreateClientResponse returns the credentials of a new client.
+// @Description the client secret is only ever returned here
+// @Property client_id     body string true "public client identifier"
+// @Property client_secret body string false "client secret, store it safely; absent for public clients"
+type CreateClientResponse struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// ClientIDRequest represents a URI client_id parameter.
+type ClientIDRequest struct {
+	ClientID string `uri:"client_id" binding:"required"`
+}
+
+// ClientHandler handles HTTP requests for client administration.
+type ClientHandler struct {
+	router  *gin.RouterGroup
+	service ClientService
+	logger  *zap.Logger
+}
+
+// NewClientHandler registers client endpoints on the given router group;
+// authorize guards each of them with a permission.
+func NewClientHandler(
+	router *gin.RouterGroup,
+	service ClientService,
+	logger *zap.Logger,
+	authorize func(permission string) gin.HandlerFunc,
+) *ClientHandler {
+	h := &ClientHandler{router: router, service: service, logger: logger}
+	h.router.POST("/clients", authorize(rbac.ClientsManage), h.CreateClient)
+	h.router.GET("/clients", authorize(rbac.ClientsRead), h.ListClients)
+	h.router.GET("/clients/:client_id", authorize(rbac.ClientsRead), h.ReadClient)
+	h.router.DELETE("/clients/:client_id", authorize(rbac.ClientsManage), h.DeleteClient)
+	return h
+}
+
+func (h *ClientHandler) bindClientID(c *gin.Context) (string, bool) {
+	var uri ClientIDRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing client_id"})
+		return "", false
+	}
+	return uri.ClientID, true
+}
+
+// CreateClient godoc
+// @Summary      Create Client
+// @Description  Register a new OAuth client and return its secret once
+// @Tags         clients
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      CreateClientRequest  true  "Client payload"
+// @Success      201      {object}  CreateClientResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /clients [post]
+func (h *ClientHandler) CreateClient(c *gin.Context) {
+	var req CreateClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid create client payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name required and redirect_uris must be URLs"})
+		return
+	}
+	client, secret, err := h.service.CreateClient(c.Request.Context(), Registration{
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Public:       req.Public,
+		GrantTypes:   req.GrantTypes,
+		Scopes:       req.Scopes,
+	})
+	if errors.Is(err, ErrInvalidGrantType) || errors.Is(err, ErrInvalidScope) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("service.CreateClient failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create client"})
+		return
+	}
+	c.JSON(http.StatusCreated, CreateClientResponse{ClientID: client.ClientID, ClientSecret: secret})
+}
+
+// ListClients godoc
+// @Summary      List Clients
+// @Description  List all registered OAuth clients
+// @Tags         clients
+// @Produce      json
+// @Success      200      {array}   Client
+// @Failure      500      {object}  map[string]string
+// @Router       /clients [get]
+func (h *ClientHandler) ListClients(c *gin.Context) {
+	clients, err := h.service.ListClients(c.Request.Context())
+	if err != nil {
+		h.logger.Error("service.ListClients failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list clients"})
+		return
+	}
+	c.JSON(http.StatusOK, clients)
+}
+
+// ReadClient godoc
+// @Summary      Get Client
+// @Description  Fetch a client by its client_id
+// @Tags         clients
+// @Produce      json
+// @Param        client_id  path      string  true  "Client ID"
+// @Success      200        {object}  Client
+// @Failure      400        {object}  map[string]string
+// @Failure      404        {object}  map[string]string
+// @Failure      500        {object}  map[string]string
+// @Router       /clients/{client_id} [get]
+func (h *ClientHandler) ReadClient(c *gin.Context) {
+	clientID, ok := h.bindClientID(c)
+	if !ok {
+		return
+	}
+	client, err := h.service.ReadClientByClientID(c.Request.Context(), clientID)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, client)
+	case errors.Is(err, ErrClientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "client not found"})
+	default:
+		h.logger.Error("service.ReadClientByClientID failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch client"})
+	}
+}
+
+// DeleteClient godoc
+// @Summary      Delete Client
+// @Description  Remove a client by its client_id
+// @Tags         clients
+// @Param        client_id  path      string  true  "Client ID"
+// @Success      204
+// @Failure      400        {object}  map[string]string
+// @Failure      404        {object}  map[string]string
+// @Failure      500        {object}  map[string]string
+// @Router       /clients/{client_id} [delete]
+func (h *ClientHandler) DeleteClient(c *gin.Context) {
+	clientID, ok := h.bindClientID(c)
+	if !ok {
+		return
+	}
+	err := h.service.DeleteClient(c.Request.Context(), clientID)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrClientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "client not found"})
+	default:
+		h.logger.Error("service.DeleteClient failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete client"})
+	}
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrClientAlreadyExists  = errors.New("client already exists")
+	ErrClientNotFound       = errors.New("client not found")
+	ErrClientNotCreated     = errors.New("client not created")
+	ErrClientNotDeleted     = errors.New("client not deleted")
+	ErrUnresponsiveDatabase = errors.New("error occurred during writing to clients table")
+)
+
+type ClientRepository interface {
+	Create(ctx context.Context, client *Client) error
+	ReadByClientID(ctx context.Context, clientID string) (*Client, error)
+	ReadAll(ctx context.Context) ([]Client, error)
+	DeleteByClientID(ctx context.Context, clientID string) error
+}
+
+type clientRepository struct {
+	db *gorm.DB
+}
+
+func NewClientRepository(db *gorm.DB) ClientRepository {
+	return &clientRepository{db: db}
+}
+
+func (r *clientRepository) Create(ctx context.Context, client *Client) error {
+	err := r.db.WithContext(ctx).Create(client).Error
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" &&
+			strings.Contains(pgErr.ConstraintName, "client_id") {
+			return ErrClientAlreadyExists
+		}
+		return ErrClientNotCreated
+	}
+	return nil
+}
+
+func (r *clientRepository) ReadByClientID(ctx context.Context, clientID string) (*Client, error) {
+	var client Client
+	err := r.db.WithContext(ctx).
+		Where("client_id = ?", clientID).
+		First(&client).
+		Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &client, nil
+}
+
+func (r *clientRepository) ReadAll(ctx context.Context) ([]Client, error) {
+	var clients []Client
+	if err := r.db.WithContext(ctx).
+		Order("id ASC").
+		Find(&clients).
+		Error; err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return clients, nil
+}
+
+func (r *clientRepository) DeleteByClientID(ctx context.Context, clientID string) error {
+	res := r.db.WithContext(ctx).
+		Where("client_id = ?", clientID).
+		Delete(&Client{})
+	if res.Error != nil {
+		return ErrClientNotDeleted
+	}
+	if res.RowsAffected == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const secretBytes = 32
+
+var (
+	ErrHashingSecretFailed    = errors.New("hashing client secret failed")
+	ErrInvalidClientSecret    = errors.New("invalid client id or secret")
+	ErrGeneratingSecretFailed = errors.New("generating client secret failed")
+	ErrInvalidGrantType       = errors.New("unsupported grant type, or client_credentials for a public client")
+	ErrInvalidScope           = errors.New("scopes must not contain spaces")
+)
+
+type ClientService interface {
+	CreateClient(ctx context.Context, reg Registration) (client *Client, secret string, err error)
+	Authenticate(ctx context.Context, clientID, secret string) (*Client, error)
+	ReadClientByClientID(ctx context.Context, clientID string) (*Client, error)
+	ListClients(ctx context.Context) ([]Client, error)
+	DeleteClient(ctx context.Context, clientID string) error
+}
+
+type clientService struct {
+	repo   ClientRepository
+	logger *zap.Logger
+}
+
+func NewClientService(repo ClientRepository, logger *zap.Logger) ClientService {
+	return &clientService{
+		repo:   repo,
+		logger: logger,
+	}
+}
+
+/** CREATE */
+
+// CreateClient registers a client and returns its secret in plain text;
+// only the hash is stored, so this is the one chance to hand it out.
+// Public clients get no secret.
+func (s *clientService) CreateClient(ctx context.Context, reg Registration) (*Client, string, error) {
+	for _, grantType := range reg.GrantTypes {
+		if !slices.Contains(GrantTypes, grantType) ||
+			(reg.Public && grantType == GrantTypeClientCredentials) {
+			return nil, "", ErrInvalidGrantType
+		}
+	}
+	for _, scope := range reg.Scopes {
+		if len(strings.Fields(scope)) != 1 {
+			return nil, "", ErrInvalidScope
+		}
+	}
+
+	var secret, hashed string
+	if !reg.Public {
+		raw := make([]byte, secretBytes)
+		if _, err := rand.Read(raw); err != nil {
+			s.logger.Error("failed to generate client secret", zap.Error(err))
+			return nil, "", ErrGeneratingSecretFailed
+		}
+		secret = base64.RawURLEncoding.EncodeToString(raw)
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			s.logger.Error("failed to hash client secret", zap.Error(err))
+			return nil, "", ErrHashingSecretFailed
+		}
+		hashed = string(hash)
+	}
+
+	client := NewClient(uuid.NewString(), hashed, reg)
+	if err := s.repo.Create(ctx, client); err != nil {
+		s.logger.Error("failed to create client in repository", zap.Error(err))
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+/** READ */
+
+// Authenticate checks client credentials. Unknown clients, public clients
+// and wrong secrets are indistinguishable to the caller.
+func (s *clientService) Authenticate(ctx context.Context, clientID, secret string) (*Client, error) {
+	client, err := s.repo.ReadByClientID(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, ErrClientNotFound) {
+			return nil, ErrInvalidClientSecret
+		}
+		s.logger.Error("failed to get client by client id", zap.String("clientID", clientID), zap.Error(err))
+		return nil, err
+	}
+	if client.Public || bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)) != nil {
+		return nil, ErrInvalidClientSecret
+	}
+	return client, nil
+}
+
+func (s *clientService) ReadClientByClientID(ctx context.Context, clientID string) (*Client, error) {
+	client, err := s.repo.ReadByClientID(ctx, clientID)
+	if err != nil {
+		s.logger.Error("failed to get client by client id", zap.String("clientID", clientID), zap.Error(err))
+		return nil, err
+	}
+	return client, nil
+}
+
+func (s *clientService) ListClients(ctx context.Context) ([]Client, error) {
+	clients, err := s.repo.ReadAll(ctx)
+	if err != nil {
+		s.logger.Error("failed to list clients", zap.Error(err))
+		return nil, err
+	}
+	return clients, nil
+}
+
+/** DELETE */
+func (s *clientService) DeleteClient(ctx context.Context, clientID string) error {
+	if err := s.repo.DeleteByClientID(ctx, clientID); err != nil {
+		s.logger.Error("failed to delete client", zap.String("clientID", clientID), zap.Error(err))
+		return err
+	}
+	return nil
+}
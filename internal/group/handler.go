@@ -0,0 +1,403 @@
+package group
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/rbac"
+)
+
+// GroupRequest represents the payload for creating or changing a group.
+// @Description payload to create a group or replace its name and description
+// @Property name        body string true  "unique group name"
+// @Property description body string false "what the group is for"
+type GroupRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description" binding:"max=255"`
+}
+
+// GroupRolesRequest represents the payload for granting roles to a group.
+// @Description payload to replace the roles granted to a group
+// @Property roles body []string true "names of the roles granted to its members"
+type GroupRolesRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// AddMemberRequest represents the payload for adding a member.
+// @Description payload to add a person to a group
+// @Property person_id body integer true "person ID"
+type AddMemberRequest struct {
+	PersonID uint `json:"person_id" binding:"required,min=1"`
+}
+
+// IDRequest represents a URI id parameter.
+type IDRequest struct {
+	ID uint `uri:"id" binding:"required,min=1"`
+}
+
+// MemberRequest represents the URI parameters naming a member.
+type MemberRequest struct {
+	ID       uint `uri:"id" binding:"required,min=1"`
+	PersonID uint `uri:"person_id" binding:"required,min=1"`
+}
+
+// GroupHandler handles HTTP requests for groups.
+type GroupHandler struct {
+	router  *gin.RouterGroup
+	service GroupService
+	logger  *zap.Logger
+}
+
+// NewGroupHandler registers group endpoints on the given router group;
+// authorize guards each of them with a permission. Granting roles to a
+// group takes the permission to assign roles.
+func NewGroupHandler(
+	router *gin.RouterGroup,
+	service GroupService,
+	logger *zap.Logger,
+	authorize func(permission string) gin.HandlerFunc,
+) *GroupHandler {
+	h := &GroupHandler{router: router, service: service, logger: logger}
+	h.router.POST("/groups", authorize(rbac.GroupsManage), h.CreateGroup)
+	h.router.GET("/groups", authorize(rbac.GroupsRead), h.ListGroups)
+	h.router.GET("/groups/:id", authorize(rbac.GroupsRead), h.ReadGroup)
+	h.router.PUT("/groups/:id", authorize(rbac.GroupsManage), h.UpdateGroup)
+	h.router.DELETE("/groups/:id", authorize(rbac.GroupsManage), h.DeleteGroup)
+	h.router.PUT("/groups/:id/roles", authorize(rbac.RolesManage), h.SetGroupRoles)
+	h.router.GET("/groups/:id/members", authorize(rbac.GroupsRead), h.ListMembers)
+	h.router.POST("/groups/:id/members", authorize(rbac.GroupsManage), h.AddMember)
+	h.router.DELETE("/groups/:id/members/:person_id", authorize(rbac.GroupsManage), h.RemoveMember)
+	h.router.GET("/persons/:id/groups", authorize(rbac.GroupsRead), h.ReadPersonGroups)
+	return h
+}
+
+func (h *GroupHandler) bindID(c *gin.Context) (uint, bool) {
+	var uri IDRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id"})
+		return 0, false
+	}
+	return uri.ID, true
+}
+
+func (h *GroupHandler) bindMember(c *gin.Context) (MemberRequest, bool) {
+	var uri MemberRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id or person_id"})
+		return uri, false
+	}
+	return uri, true
+}
+
+// CreateGroup godoc
+// @Summary      Create Group
+// @Description  Create a group without members or roles
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      GroupRequest  true  "Group payload"
+// @Success      201      {object}  Group
+// @Failure      400      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /groups [post]
+func (h *GroupHandler) CreateGroup(c *gin.Context) {
+	var req GroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid create group payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name required"})
+		return
+	}
+	group, err := h.service.CreateGroup(c.Request.Context(), req.Name, req.Description)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, group)
+	case errors.Is(err, ErrInvalidGroupName):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrGroupAlreadyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "group already exists"})
+	default:
+		h.logger.Error("service.CreateGroup failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create group"})
+	}
+}
+
+// ListGroups godoc
+// @Summary      List Groups
+// @Description  List all groups with the roles granted to them
+// @Tags         groups
+// @Produce      json
+// @Success      200      {array}   Group
+// @Failure      500      {object}  map[string]string
+// @Router       /groups [get]
+func (h *GroupHandler) ListGroups(c *gin.Context) {
+	groups, err := h.service.ListGroups(c.Request.Context())
+	if err != nil {
+		h.logger.Error("service.ListGroups failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list groups"})
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// ReadGroup godoc
+// @Summary      Get Group
+// @Description  Fetch a group by ID
+// @Tags         groups
+// @Produce      json
+// @Param        id       path      int  true  "Group ID"
+// @Success      200      {object}  Group
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /groups/{id} [get]
+func (h *GroupHandler) ReadGroup(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	group, err := h.service.ReadGroup(c.Request.Context(), id)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, group)
+	case errors.Is(err, ErrGroupNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+	default:
+		h.logger.Error("service.ReadGroup failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch group"})
+	}
+}
+
+// UpdateGroup godoc
+// @Summary      Update Group
+// @Description  Replace the name and description of a group
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Param        id       path      int           true  "Group ID"
+// @Param        payload  body      GroupRequest  true  "Group payload"
+// @Success      200      {object}  Group
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /groups/{id} [put]
+func (h *GroupHandler) UpdateGroup(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	var req GroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid update group payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name required"})
+		return
+	}
+	group, err := h.service.UpdateGroup(c.Request.Context(), id, req.Name, req.Description)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, group)
+	case errors.Is(err, ErrInvalidGroupName):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrGroupNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+	case errors.Is(err, ErrGroupAlreadyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "group already exists"})
+	default:
+		h.logger.Error("service.UpdateGroup failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not update group"})
+	}
+}
+
+// DeleteGroup godoc
+// @Summary      Delete Group
+// @Description  Delete a group; its members lose the roles it granted
+// @Tags         groups
+// @Param        id       path      int  true  "Group ID"
+// @Success      204      "No Content"
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /groups/{id} [delete]
+func (h *GroupHandler) DeleteGroup(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	err := h.service.DeleteGroup(c.Request.Context(), id)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrGroupNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+	default:
+		h.logger.Error("service.DeleteGroup failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete group"})
+	}
+}
+
+// SetGroupRoles godoc
+// @Summary      Set Group Roles
+// @Description  Replace the roles granted to the members of a group. Members losing a role are logged out.
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Param        id       path      int                true  "Group ID"
+// @Param        payload  body      GroupRolesRequest  true  "Roles payload"
+// @Success      200      {object}  Group
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /groups/{id}/roles [put]
+func (h *GroupHandler) SetGroupRoles(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	var req GroupRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid group roles payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "roles required"})
+		return
+	}
+	group, err := h.service.SetGroupRoles(c.Request.Context(), id, req.Roles)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, group)
+	case errors.Is(err, rbac.ErrUnknownRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrGroupNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+	default:
+		h.logger.Error("service.SetGroupRoles failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not assign roles"})
+	}
+}
+
+// ListMembers godoc
+// @Summary      List Group Members
+// @Description  List the members of a group
+// @Tags         groups
+// @Produce      json
+// @Param        id       path      int  true  "Group ID"
+// @Success      200      {array}   Member
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /groups/{id}/members [get]
+func (h *GroupHandler) ListMembers(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	members, err := h.service.ListMembers(c.Request.Context(), id)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, members)
+	case errors.Is(err, ErrGroupNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+	default:
+		h.logger.Error("service.ListMembers failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list members"})
+	}
+}
+
+// AddMember godoc
+// @Summary      Add Group Member
+// @Description  Make a person a member of a group, granting them its roles
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Param        id       path      int               true  "Group ID"
+// @Param        payload  body      AddMemberRequest  true  "Member payload"
+// @Success      201      {object}  Member
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /groups/{id}/members [post]
+func (h *GroupHandler) AddMember(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	var req AddMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid add group member payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "person_id required"})
+		return
+	}
+	member, err := h.service.AddMember(c.Request.Context(), id, req.PersonID)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, member)
+	case errors.Is(err, ErrGroupNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+	case errors.Is(err, ErrPersonNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrMemberAlreadyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("service.AddMember failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not add member"})
+	}
+}
+
+// RemoveMember godoc
+// @Summary      Remove Group Member
+// @Description  End a person's membership of a group. If the group grants roles, the person is logged out.
+// @Tags         groups
+// @Param        id         path      int  true  "Group ID"
+// @Param        person_id  path      int  true  "Person ID"
+// @Success      204        "No Content"
+// @Failure      400        {object}  map[string]string
+// @Failure      404        {object}  map[string]string
+// @Failure      500        {object}  map[string]string
+// @Router       /groups/{id}/members/{person_id} [delete]
+func (h *GroupHandler) RemoveMember(c *gin.Context) {
+	uri, ok := h.bindMember(c)
+	if !ok {
+		return
+	}
+	err := h.service.RemoveMember(c.Request.Context(), uri.ID, uri.PersonID)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrMemberNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+	default:
+		h.logger.Error("service.RemoveMember failed", zap.Uint("id", uri.ID), zap.Uint("personID", uri.PersonID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not remove member"})
+	}
+}
+
+// ReadPersonGroups godoc
+// @Summary      List Person Groups
+// @Description  List the groups a person is a member of
+// @Tags         groups
+// @Produce      json
+// @Param        id       path      int  true  "Person ID"
+// @Success      200      {array}   Group
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /persons/{id}/groups [get]
+func (h *GroupHandler) ReadPersonGroups(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	groups, err := h.service.PersonGroups(c.Request.Context(), id)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, groups)
+	case errors.Is(err, ErrPersonNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "person not found"})
+	default:
+		h.logger.Error("service.PersonGroups failed", zap.Uint("personID", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list groups"})
+	}
+}
@@ -0,0 +1,41 @@
+package group
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Group is a named set of persons. Roles granted to a group are held by
+// each of its members.
+// swagger:model GroupResponse
+// @Description group of persons sharing roles
+// @Property ID          body integer  true "unique identifier"
+// @Property name        body string   true "unique group name"
+// @Property description body string   true "what the group is for"
+// @Property roles       body []string true "names of the roles granted to its members"
+type Group struct {
+	gorm.Model
+	Name        string `json:"name" gorm:"uniqueIndex;not null"`
+	Description string `json:"description" gorm:"not null;default:''"`
+	// Roles are filled in from the role grants of the group
+	Roles []string `json:"roles" gorm:"-"`
+}
+
+// Member makes a person a member of a group.
+// swagger:model GroupMemberResponse
+// @Description membership of a person in a group
+// @Property group_id  body integer true "group ID"
+// @Property person_id body integer true "person ID"
+// @Property email     body string  true "email address of the person"
+type Member struct {
+	GroupID   uint      `json:"group_id" gorm:"primaryKey"`
+	PersonID  uint      `json:"person_id" gorm:"primaryKey;index"`
+	CreatedAt time.Time `json:"created_at"`
+	// Email is read from persons when members are listed
+	Email string `json:"email" gorm:"->;-:migration"`
+}
+
+func (Member) TableName() string {
+	return "group_members"
+}
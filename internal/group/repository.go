@@ -0,0 +1,213 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/tenant"
+)
+
+var (
+	ErrGroupNotFound        = errors.New("group not found")
+	ErrGroupAlreadyExists   = errors.New("group already exists")
+	ErrGroupNotCreated      = errors.New("group not created")
+	ErrMemberNotFound       = errors.New("member not found")
+	ErrMemberAlreadyExists  = errors.New("person is already a member")
+	ErrUnresponsiveDatabase = errors.New("error occurred during writing to groups table")
+)
+
+// GroupRepository reads and writes groups and their members. Groups span
+// organizations; members listed in the context of an organization are
+// limited to its members.
+type GroupRepository interface {
+	Create(ctx context.Context, group *Group) error
+	ReadByID(ctx context.Context, id uint) (*Group, error)
+	ReadAll(ctx context.Context) ([]Group, error)
+	// ReadByPersonID returns the groups a person is a member of
+	ReadByPersonID(ctx context.Context, personID uint) ([]Group, error)
+	// Update saves the name and description of a group
+	Update(ctx context.Context, group *Group) error
+	// Delete removes a group and its memberships
+	Delete(ctx context.Context, id uint) error
+	AddMember(ctx context.Context, member *Member) error
+	ReadMember(ctx context.Context, groupID, personID uint) (*Member, error)
+	ReadMembers(ctx context.Context, groupID uint) ([]Member, error)
+	// ReadMemberIDs returns the IDs of every member of a group, whatever
+	// the organization in ctx
+	ReadMemberIDs(ctx context.Context, groupID uint) ([]uint, error)
+	RemoveMember(ctx context.Context, groupID, personID uint) error
+}
+
+type groupRepository struct {
+	db *gorm.DB
+}
+
+func NewGroupRepository(db *gorm.DB) GroupRepository {
+	return &groupRepository{db: db}
+}
+
+func (r *groupRepository) Create(ctx context.Context, group *Group) error {
+	if err := r.db.WithContext(ctx).Create(group).Error; err != nil {
+		if isNameConflict(err) {
+			return ErrGroupAlreadyExists
+		}
+		return ErrGroupNotCreated
+	}
+	return nil
+}
+
+func (r *groupRepository) ReadByID(ctx context.Context, id uint) (*Group, error) {
+	var group Group
+	err := r.db.WithContext(ctx).First(&group, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) ReadAll(ctx context.Context) ([]Group, error) {
+	var groups []Group
+	if err := r.db.WithContext(ctx).Order("name").Find(&groups).Error; err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return groups, nil
+}
+
+func (r *groupRepository) ReadByPersonID(ctx context.Context, personID uint) ([]Group, error) {
+	var groups []Group
+	err := r.db.WithContext(ctx).
+		Joins("JOIN group_members ON group_members.group_id = groups.id").
+		Where("group_members.person_id = ?", personID).
+		Order("groups.name").
+		Find(&groups).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return groups, nil
+}
+
+func (r *groupRepository) Update(ctx context.Context, group *Group) error {
+	res := r.db.WithContext(ctx).
+		Model(group).
+		Select("name", "description").
+		Updates(group)
+	if res.Error != nil {
+		if isNameConflict(res.Error) {
+			return ErrGroupAlreadyExists
+		}
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrGroupNotFound
+	}
+	return nil
+}
+
+func (r *groupRepository) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Unscoped().Delete(&Group{}, id)
+		if res.Error != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if res.RowsAffected == 0 {
+			return ErrGroupNotFound
+		}
+		if err := tx.Where("group_id = ?", id).Delete(&Member{}).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		return nil
+	})
+}
+
+func (r *groupRepository) AddMember(ctx context.Context, member *Member) error {
+	res := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(member)
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrMemberAlreadyExists
+	}
+	return nil
+}
+
+func (r *groupRepository) ReadMember(ctx context.Context, groupID, personID uint) (*Member, error) {
+	var member Member
+	err := r.members(ctx).
+		Where("group_members.group_id = ?", groupID).
+		Where("group_members.person_id = ?", personID).
+		First(&member).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMemberNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &member, nil
+}
+
+func (r *groupRepository) ReadMembers(ctx context.Context, groupID uint) ([]Member, error) {
+	var members []Member
+	err := r.members(ctx).
+		Where("group_members.group_id = ?", groupID).
+		Order("persons.email").
+		Find(&members).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return members, nil
+}
+
+func (r *groupRepository) ReadMemberIDs(ctx context.Context, groupID uint) ([]uint, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).
+		Model(&Member{}).
+		Where("group_id = ?", groupID).
+		Pluck("person_id", &ids).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return ids, nil
+}
+
+func (r *groupRepository) RemoveMember(ctx context.Context, groupID, personID uint) error {
+	res := r.db.WithContext(ctx).
+		Scopes(tenant.Members(ctx, "person_id")).
+		Where("group_id = ? AND person_id = ?", groupID, personID).
+		Delete(&Member{})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+// members selects memberships of live persons, with their email address.
+func (r *groupRepository) members(ctx context.Context) *gorm.DB {
+	return r.db.WithContext(ctx).
+		Model(&Member{}).
+		Select("group_members.*, persons.email").
+		Joins("JOIN persons ON persons.id = group_members.person_id AND persons.deleted_at IS NULL").
+		Scopes(tenant.Members(ctx, "group_members.person_id"))
+}
+
+func isNameConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" &&
+		strings.Contains(pgErr.ConstraintName, "name")
+}
@@ -0,0 +1,220 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+	"github.com/mehmetcc/definitive-authentication-service/internal/rbac"
+)
+
+var (
+	ErrInvalidGroupName = errors.New("group names are 1 to 64 lowercase letters, digits, '-' or '_'")
+	ErrPersonNotFound   = errors.New("person not found")
+)
+
+var groupNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+type GroupService interface {
+	CreateGroup(ctx context.Context, name, description string) (*Group, error)
+	ListGroups(ctx context.Context) ([]Group, error)
+	ReadGroup(ctx context.Context, id uint) (*Group, error)
+	// UpdateGroup replaces the name and description of a group
+	UpdateGroup(ctx context.Context, id uint, name, description string) (*Group, error)
+	// DeleteGroup removes a group, its memberships and its roles
+	DeleteGroup(ctx context.Context, id uint) error
+	// SetGroupRoles replaces the roles granted to the members of a group
+	SetGroupRoles(ctx context.Context, id uint, roles []string) (*Group, error)
+	ListMembers(ctx context.Context, groupID uint) ([]Member, error)
+	AddMember(ctx context.Context, groupID, personID uint) (*Member, error)
+	// RemoveMember ends a membership and the roles that came with it
+	RemoveMember(ctx context.Context, groupID, personID uint) error
+	PersonGroups(ctx context.Context, personID uint) ([]Group, error)
+	// GroupNames returns the names of the groups a person is a member of
+	GroupNames(ctx context.Context, personID uint) ([]string, error)
+}
+
+type groupService struct {
+	repo          GroupRepository
+	personService person.PersonService
+	rbacService   rbac.RBACService
+	logger        *zap.Logger
+}
+
+func NewGroupService(
+	repo GroupRepository,
+	personService person.PersonService,
+	rbacService rbac.RBACService,
+	logger *zap.Logger,
+) GroupService {
+	return &groupService{
+		repo:          repo,
+		personService: personService,
+		rbacService:   rbacService,
+		logger:        logger,
+	}
+}
+
+func (s *groupService) CreateGroup(ctx context.Context, name, description string) (*Group, error) {
+	if !groupNamePattern.MatchString(name) {
+		return nil, ErrInvalidGroupName
+	}
+	group := &Group{Name: name, Description: description, Roles: []string{}}
+	if err := s.repo.Create(ctx, group); err != nil {
+		s.logger.Error("failed to create group", zap.String("group", name), zap.Error(err))
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *groupService) ListGroups(ctx context.Context) ([]Group, error) {
+	groups, err := s.repo.ReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		if err := s.loadRoles(ctx, &groups[i]); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+func (s *groupService) ReadGroup(ctx context.Context, id uint) (*Group, error) {
+	group, err := s.repo.ReadByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadRoles(ctx, group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *groupService) UpdateGroup(ctx context.Context, id uint, name, description string) (*Group, error) {
+	if !groupNamePattern.MatchString(name) {
+		return nil, ErrInvalidGroupName
+	}
+	group, err := s.repo.ReadByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	group.Name = name
+	group.Description = description
+	if err := s.repo.Update(ctx, group); err != nil {
+		s.logger.Error("failed to update group", zap.Uint("id", id), zap.Error(err))
+		return nil, err
+	}
+	if err := s.loadRoles(ctx, group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *groupService) DeleteGroup(ctx context.Context, id uint) error {
+	memberIDs, err := s.repo.ReadMemberIDs(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, id); err != nil {
+		s.logger.Error("failed to delete group", zap.Uint("id", id), zap.Error(err))
+		return err
+	}
+	return s.rbacService.RemoveGroupRoles(ctx, id, memberIDs)
+}
+
+func (s *groupService) SetGroupRoles(ctx context.Context, id uint, roles []string) (*Group, error) {
+	group, err := s.repo.ReadByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.rbacService.SetGroupRoles(ctx, id, roles); err != nil {
+		return nil, err
+	}
+	if err := s.loadRoles(ctx, group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *groupService) ListMembers(ctx context.Context, groupID uint) ([]Member, error) {
+	if _, err := s.repo.ReadByID(ctx, groupID); err != nil {
+		return nil, err
+	}
+	return s.repo.ReadMembers(ctx, groupID)
+}
+
+func (s *groupService) AddMember(ctx context.Context, groupID, personID uint) (*Member, error) {
+	if _, err := s.repo.ReadByID(ctx, groupID); err != nil {
+		return nil, err
+	}
+	_, err := s.personService.ReadPersonByID(ctx, personID)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return nil, ErrPersonNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	member := &Member{GroupID: groupID, PersonID: personID}
+	if err := s.repo.AddMember(ctx, member); err != nil {
+		s.logger.Error("failed to add group member",
+			zap.Uint("groupID", groupID), zap.Uint("personID", personID), zap.Error(err))
+		return nil, err
+	}
+	return s.repo.ReadMember(ctx, groupID, personID)
+}
+
+func (s *groupService) RemoveMember(ctx context.Context, groupID, personID uint) error {
+	if err := s.repo.RemoveMember(ctx, groupID, personID); err != nil {
+		return err
+	}
+	return s.rbacService.LeaveGroup(ctx, groupID, personID)
+}
+
+func (s *groupService) PersonGroups(ctx context.Context, personID uint) ([]Group, error) {
+	_, err := s.personService.ReadPersonByID(ctx, personID)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return nil, ErrPersonNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.repo.ReadByPersonID(ctx, personID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		if err := s.loadRoles(ctx, &groups[i]); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+func (s *groupService) GroupNames(ctx context.Context, personID uint) ([]string, error) {
+	groups, err := s.repo.ReadByPersonID(ctx, personID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(groups))
+	for _, group := range groups {
+		names = append(names, group.Name)
+	}
+	return names, nil
+}
+
+func (s *groupService) loadRoles(ctx context.Context, group *Group) error {
+	roles, err := s.rbacService.GroupRoles(ctx, group.ID)
+	if err != nil {
+		return err
+	}
+	group.Roles = make([]string, 0, len(roles))
+	for _, role := range roles {
+		group.Roles = append(group.Roles, role.Name)
+	}
+	return nil
+}
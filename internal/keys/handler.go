@@ -0,0 +1,87 @@
+package keys
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/rbac"
+)
+
+// RotateKeyRequest represents the payload for rotating a key.
+// @Description payload to rotate the active key of a ring
+// @Property use body string true "key use: access, refresh or action"
+type RotateKeyRequest struct {
+	Use Use `json:"use" binding:"required,oneof=access refresh action"`
+}
+
+// KeyHandler handles HTTP requests for signing key administration.
+type KeyHandler struct {
+	router  *gin.RouterGroup
+	service KeyService
+	logger  *zap.Logger
+}
+
+// NewKeyHandler registers key endpoints on the given router group;
+// authorize guards each of them with a permission.
+func NewKeyHandler(
+	router *gin.RouterGroup,
+	service KeyService,
+	logger *zap.Logger,
+	authorize func(permission string) gin.HandlerFunc,
+) *KeyHandler {
+	h := &KeyHandler{router: router, service: service, logger: logger}
+	h.router.GET("/keys", authorize(rbac.KeysRead), h.ListKeys)
+	h.router.POST("/keys/rotate", authorize(rbac.KeysRotate), h.RotateKey)
+	return h
+}
+
+// ListKeys godoc
+// @Summary      List Signing Keys
+// @Description  List the active and retiring keys of both rings
+// @Tags         keys
+// @Produce      json
+// @Success      200      {array}   SigningKeyRecord
+// @Failure      500      {object}  map[string]string
+// @Router       /keys [get]
+func (h *KeyHandler) ListKeys(c *gin.Context) {
+	records, err := h.service.ListKeys(c.Request.Context())
+	if err != nil {
+		h.logger.Error("service.ListKeys failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list keys"})
+		return
+	}
+	c.JSON(http.StatusOK, records)
+}
+
+// RotateKey godoc
+// @Summary      Rotate Signing Key
+// @Description  Replace the active key of a ring; the old key keeps verifying tokens until it retires
+// @Tags         keys
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      RotateKeyRequest  true  "Rotation payload"
+// @Success      201      {object}  SigningKeyRecord
+// @Failure      400      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /keys/rotate [post]
+func (h *KeyHandler) RotateKey(c *gin.Context) {
+	var req RotateKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid rotate key payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "use must be access, refresh or action"})
+		return
+	}
+	record, err := h.service.Rotate(c.Request.Context(), req.Use)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, record)
+	case errors.Is(err, ErrInvalidKeyUse):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "use must be access, refresh or action"})
+	default:
+		h.logger.Error("service.Rotate failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not rotate key"})
+	}
+}
@@ -0,0 +1,58 @@
+package keys
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Use tells which kind of token a key signs.
+// @Description key use: "access", "refresh" or "action"
+type Use string
+
+const (
+	// UseAccess keys sign access tokens and are published in the JWKS
+	UseAccess Use = "access"
+	// UseRefresh keys sign refresh tokens and never leave the service
+	UseRefresh Use = "refresh"
+	// UseAction keys sign the single purpose tokens mailed to people, such
+	// as email verification and magic links, and never leave the service
+	UseAction Use = "action"
+)
+
+// uses lists every key use; each has its own ring.
+var uses = []Use{UseAccess, UseRefresh, UseAction}
+
+// Status is the lifecycle state of a key.
+// @Description key status: "active" or "retiring"
+type Status string
+
+const (
+	// Active keys sign new tokens; there is one per use
+	Active Status = "active"
+	// Retiring keys only verify tokens until RetiresAt
+	Retiring Status = "retiring"
+)
+
+// SigningKeyRecord is a persisted member of the key ring.
+// @Description signing key metadata
+// @Property kid        body string true  "key identifier written to the kid header"
+// @Property use        body string true  "key use"
+// @Property alg        body string true  "JWS algorithm"
+// @Property status     body string true  "key status"
+// @Property retires_at body string false "end of the verification window of a retiring key"
+type SigningKeyRecord struct {
+	gorm.Model
+	// KID is written to the kid header of every token the key signs
+	KID string `json:"kid" gorm:"uniqueIndex;not null"`
+	// Use of the key
+	Use Use `json:"use" gorm:"type:text;not null;uniqueIndex:idx_signing_key_records_active_use,where:status = 'active'"`
+	// Algorithm is the JWS alg of the key
+	Algorithm string `json:"alg" gorm:"not null"`
+	// Material is the marshalled private key (hidden from JSON)
+	Material []byte `json:"-" gorm:"not null"`
+	// Status of the key
+	Status Status `json:"status" gorm:"type:text;index;not null"`
+	// RetiresAt is when a retiring key stops verifying tokens
+	RetiresAt *time.Time `json:"retires_at,omitempty"`
+}
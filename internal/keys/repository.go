@@ -0,0 +1,125 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrActiveKeyNotFound    = errors.New("no active key found for given use")
+	ErrKeyNotDue            = errors.New("active key is not due for rotation")
+	ErrKeyNotCreated        = errors.New("key not created")
+	ErrUnresponsiveDatabase = errors.New("error occurred during writing to signing keys table")
+)
+
+type KeyRepository interface {
+	Create(ctx context.Context, record *SigningKeyRecord) error
+	ReadActive(ctx context.Context, use Use) (*SigningKeyRecord, error)
+	ReadValid(ctx context.Context, now time.Time) ([]SigningKeyRecord, error)
+	Rotate(ctx context.Context, next *SigningKeyRecord, retiresAt, activatedBefore time.Time) error
+	DeleteRetired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type keyRepository struct {
+	db *gorm.DB
+}
+
+func NewKeyRepository(db *gorm.DB) KeyRepository {
+	return &keyRepository{db: db}
+}
+
+func (r *keyRepository) Create(ctx context.Context, record *SigningKeyRecord) error {
+	if err := r.db.WithContext(ctx).Create(record).Error; err != nil {
+		return ErrKeyNotCreated
+	}
+	return nil
+}
+
+func (r *keyRepository) ReadActive(ctx context.Context, use Use) (*SigningKeyRecord, error) {
+	var record SigningKeyRecord
+	err := r.db.WithContext(ctx).
+		Where("use = ? AND status = ?", use, Active).
+		Order("created_at DESC").
+		First(&record).
+		Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrActiveKeyNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &record, nil
+}
+
+// ReadValid returns every key that can still verify tokens, across all uses.
+func (r *keyRepository) ReadValid(ctx context.Context, now time.Time) ([]SigningKeyRecord, error) {
+	var records []SigningKeyRecord
+	err := r.db.WithContext(ctx).
+		Where("status = ? OR (status = ? AND retires_at > ?)", Active, Retiring, now).
+		Order("created_at ASC").
+		Find(&records).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return records, nil
+}
+
+// Rotate retires the active key of next.Use and stores next as the new
+// active key. The active key row is locked and the rotation is skipped with
+// ErrKeyNotDue when it was created after activatedBefore, so instances
+// racing on a scheduled rotation only rotate once.
+func (r *keyRepository) Rotate(
+	ctx context.Context,
+	next *SigningKeyRecord,
+	retiresAt, activatedBefore time.Time,
+) error {
+	return r.db.
+		WithContext(ctx).
+		Transaction(func(tx *gorm.DB) error {
+			var current SigningKeyRecord
+			err := tx.
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("use = ? AND status = ?", next.Use, Active).
+				Order("created_at DESC").
+				First(&current).
+				Error
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				// nothing to retire
+			case err != nil:
+				return ErrUnresponsiveDatabase
+			case !current.CreatedAt.Before(activatedBefore):
+				return ErrKeyNotDue
+			default:
+				if err := tx.Model(&SigningKeyRecord{}).
+					Where("use = ? AND status = ?", next.Use, Active).
+					Updates(map[string]any{"status": Retiring, "retires_at": retiresAt}).
+					Error; err != nil {
+					return ErrUnresponsiveDatabase
+				}
+			}
+
+			if err := tx.Create(next).Error; err != nil {
+				return ErrKeyNotCreated
+			}
+			return nil
+		})
+}
+
+// DeleteRetired permanently removes keys whose verification window has closed.
+func (r *keyRepository) DeleteRetired(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("status = ? AND retires_at <= ?", Retiring, now).
+		Delete(&SigningKeyRecord{})
+	if res.Error != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	return res.RowsAffected, nil
+}
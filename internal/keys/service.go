@@ -0,0 +1,372 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/utils"
+)
+
+const (
+	// how often the ring is reloaded and the rotation schedule checked
+	tickInterval = time.Minute
+	// minimum gap between reloads triggered by an unknown kid
+	reloadThrottle = 5 * time.Second
+)
+
+var (
+	ErrInvalidKeyUse = errors.New("invalid key use")
+)
+
+// RotationPolicy controls how often keys are replaced and how long a
+// replaced key keeps verifying the tokens it already signed.
+type RotationPolicy struct {
+	// AccessAlgorithm is the algorithm of generated access keys
+	AccessAlgorithm string
+	// Interval between scheduled rotations, 0 disables them
+	Interval time.Duration
+	// AccessOverlap, RefreshOverlap and ActionOverlap must be at least the
+	// lifetime of the tokens signed by the key, otherwise rotation logs
+	// users out and invalidates the links mailed to them
+	AccessOverlap  time.Duration
+	RefreshOverlap time.Duration
+	ActionOverlap  time.Duration
+	// SeedAccessKey and SeedRefreshKey are stored as the first key of their
+	// ring when the database has none; a key is generated otherwise, and
+	// always for the action ring
+	SeedAccessKey  *utils.SigningKey
+	SeedRefreshKey *utils.SigningKey
+}
+
+type KeyService interface {
+	Load(ctx context.Context) error
+	AccessKeys() utils.KeyRing
+	RefreshKeys() utils.KeyRing
+	ActionKeys() utils.KeyRing
+	PublicKeys() (*utils.JWKSet, error)
+	ListKeys(ctx context.Context) ([]SigningKeyRecord, error)
+	Rotate(ctx context.Context, use Use) (*SigningKeyRecord, error)
+	Run(ctx context.Context)
+}
+
+type cachedKey struct {
+	use       Use
+	key       *utils.SigningKey
+	retiresAt *time.Time
+}
+
+type keyService struct {
+	repo   KeyRepository
+	logger *zap.Logger
+	policy RotationPolicy
+
+	mu         sync.RWMutex
+	active     map[Use]*SigningKeyRecord
+	keys       map[string]*cachedKey
+	lastReload time.Time
+}
+
+func NewKeyService(repo KeyRepository, logger *zap.Logger, policy RotationPolicy) KeyService {
+	return &keyService{
+		repo:   repo,
+		logger: logger,
+		policy: policy,
+		active: make(map[Use]*SigningKeyRecord),
+		keys:   make(map[string]*cachedKey),
+	}
+}
+
+// Load makes sure every use has an active key and fills the in-memory ring.
+func (s *keyService) Load(ctx context.Context) error {
+	for _, use := range uses {
+		_, err := s.repo.ReadActive(ctx, use)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrActiveKeyNotFound) {
+			return err
+		}
+
+		var seed *utils.SigningKey
+		switch use {
+		case UseAccess:
+			seed = s.policy.SeedAccessKey
+		case UseRefresh:
+			seed = s.policy.SeedRefreshKey
+		}
+		record, err := s.newRecord(use, seed)
+		if err != nil {
+			return err
+		}
+		// another instance may have won the race; the reload below picks it up
+		if err := s.repo.Rotate(ctx, record, time.Now(), time.Time{}); err != nil &&
+			!errors.Is(err, ErrKeyNotDue) && !errors.Is(err, ErrKeyNotCreated) {
+			return err
+		}
+		s.logger.Info("initialized signing key", zap.String("use", string(use)), zap.String("kid", record.KID))
+	}
+
+	if err := s.reload(ctx); err != nil {
+		return err
+	}
+	for _, use := range uses {
+		if s.activeKey(use) == nil {
+			return ErrActiveKeyNotFound
+		}
+	}
+	return nil
+}
+
+func (s *keyService) AccessKeys() utils.KeyRing {
+	return &keyRing{service: s, use: UseAccess}
+}
+
+func (s *keyService) RefreshKeys() utils.KeyRing {
+	return &keyRing{service: s, use: UseRefresh}
+}
+
+func (s *keyService) ActionKeys() utils.KeyRing {
+	return &keyRing{service: s, use: UseAction}
+}
+
+// PublicKeys exports every access key that can still verify tokens.
+func (s *keyService) PublicKeys() (*utils.JWKSet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := &utils.JWKSet{Keys: []utils.JWK{}}
+	now := time.Now()
+	for _, cached := range s.keys {
+		if cached.use != UseAccess || expired(cached, now) {
+			continue
+		}
+		jwk, err := utils.NewJWK(cached.key)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+func (s *keyService) ListKeys(ctx context.Context) ([]SigningKeyRecord, error) {
+	records, err := s.repo.ReadValid(ctx, time.Now())
+	if err != nil {
+		s.logger.Error("failed to list signing keys", zap.Error(err))
+		return nil, err
+	}
+	return records, nil
+}
+
+// Rotate replaces the active key of use right away.
+func (s *keyService) Rotate(ctx context.Context, use Use) (*SigningKeyRecord, error) {
+	return s.rotate(ctx, use, time.Now().Add(time.Second))
+}
+
+func (s *keyService) rotate(ctx context.Context, use Use, activatedBefore time.Time) (*SigningKeyRecord, error) {
+	if !slices.Contains(uses, use) {
+		return nil, ErrInvalidKeyUse
+	}
+
+	record, err := s.newRecord(use, nil)
+	if err != nil {
+		s.logger.Error("failed to generate signing key", zap.String("use", string(use)), zap.Error(err))
+		return nil, err
+	}
+	if err := s.repo.Rotate(ctx, record, time.Now().Add(s.overlap(use)), activatedBefore); err != nil {
+		if !errors.Is(err, ErrKeyNotDue) {
+			s.logger.Error("failed to rotate signing key", zap.String("use", string(use)), zap.Error(err))
+		}
+		return nil, err
+	}
+	s.logger.Info("rotated signing key", zap.String("use", string(use)), zap.String("kid", record.KID))
+
+	if err := s.reload(ctx); err != nil {
+		s.logger.Error("failed to reload signing keys", zap.Error(err))
+		return nil, err
+	}
+	return record, nil
+}
+
+// Run reloads the ring, purges retired keys and performs scheduled
+// rotations until ctx is cancelled.
+func (s *keyService) Run(ctx context.Context) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.tick(ctx)
+		}
+	}
+}
+
+func (s *keyService) tick(ctx context.Context) {
+	if n, err := s.repo.DeleteRetired(ctx, time.Now()); err != nil {
+		s.logger.Error("failed to purge retired signing keys", zap.Error(err))
+	} else if n > 0 {
+		s.logger.Info("purged retired signing keys", zap.Int64("count", n))
+	}
+
+	if s.policy.Interval > 0 {
+		due := time.Now().Add(-s.policy.Interval)
+		for _, use := range uses {
+			current := s.activeKey(use)
+			if current == nil || current.CreatedAt.After(due) {
+				continue
+			}
+			if _, err := s.rotate(ctx, use, due); err != nil && !errors.Is(err, ErrKeyNotDue) {
+				s.logger.Error("scheduled rotation failed", zap.String("use", string(use)), zap.Error(err))
+			}
+		}
+	}
+
+	if err := s.reload(ctx); err != nil {
+		s.logger.Error("failed to reload signing keys", zap.Error(err))
+	}
+}
+
+func (s *keyService) reload(ctx context.Context) error {
+	records, err := s.repo.ReadValid(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	active := make(map[Use]*SigningKeyRecord)
+	keys := make(map[string]*cachedKey, len(records))
+	for i := range records {
+		record := &records[i]
+		key, err := utils.UnmarshalSigningKey(record.KID, record.Algorithm, record.Material)
+		if err != nil {
+			s.logger.Error("skipping unreadable signing key", zap.String("kid", record.KID), zap.Error(err))
+			continue
+		}
+		keys[record.KID] = &cachedKey{use: record.Use, key: key, retiresAt: record.RetiresAt}
+		if record.Status == Active {
+			active[record.Use] = record
+		}
+	}
+
+	s.mu.Lock()
+	s.active = active
+	s.keys = keys
+	s.lastReload = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *keyService) activeKey(use Use) *SigningKeyRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active[use]
+}
+
+func (s *keyService) signingKey(use Use) (*utils.SigningKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, ok := s.active[use]
+	if !ok {
+		return nil, ErrActiveKeyNotFound
+	}
+	return s.keys[record.KID].key, nil
+}
+
+// verificationKey looks kid up in the ring, reloading once when it is
+// unknown so keys rotated by another instance are picked up.
+func (s *keyService) verificationKey(use Use, kid string) (*utils.SigningKey, error) {
+	if key, ok := s.lookup(use, kid); ok {
+		return key, nil
+	}
+
+	s.mu.RLock()
+	throttled := time.Since(s.lastReload) < reloadThrottle
+	s.mu.RUnlock()
+	if throttled {
+		return nil, utils.ErrUnknownSigningKey
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.reload(ctx); err != nil {
+		s.logger.Error("failed to reload signing keys", zap.Error(err))
+		return nil, utils.ErrUnknownSigningKey
+	}
+	if key, ok := s.lookup(use, kid); ok {
+		return key, nil
+	}
+	return nil, utils.ErrUnknownSigningKey
+}
+
+func (s *keyService) lookup(use Use, kid string) (*utils.SigningKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cached, ok := s.keys[kid]
+	if !ok || cached.use != use || expired(cached, time.Now()) {
+		return nil, false
+	}
+	return cached.key, true
+}
+
+func (s *keyService) newRecord(use Use, key *utils.SigningKey) (*SigningKeyRecord, error) {
+	// only access tokens are verified outside the service
+	algorithm := s.policy.AccessAlgorithm
+	if use != UseAccess {
+		algorithm = utils.AlgorithmHS256
+	}
+
+	if key == nil {
+		generated, err := utils.GenerateSigningKey(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		key = generated
+	}
+	material, err := utils.MarshalSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKeyRecord{
+		KID:       uuid.NewString(),
+		Use:       use,
+		Algorithm: key.Method.Alg(),
+		Material:  material,
+		Status:    Active,
+	}, nil
+}
+
+func (s *keyService) overlap(use Use) time.Duration {
+	switch use {
+	case UseRefresh:
+		return s.policy.RefreshOverlap
+	case UseAction:
+		return s.policy.ActionOverlap
+	}
+	return s.policy.AccessOverlap
+}
+
+func expired(cached *cachedKey, now time.Time) bool {
+	return cached.retiresAt != nil && !now.Before(*cached.retiresAt)
+}
+
+// keyRing exposes one use of the key service as a utils.KeyRing.
+type keyRing struct {
+	service *keyService
+	use     Use
+}
+
+func (r *keyRing) SigningKey() (*utils.SigningKey, error) {
+	return r.service.signingKey(r.use)
+}
+
+func (r *keyRing) VerificationKey(kid string) (*utils.SigningKey, error) {
+	return r.service.verificationKey(r.use, kid)
+}
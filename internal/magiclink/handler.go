@@ -0,0 +1,141 @@
+package magiclink
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	tollbooth "github.com/didip/tollbooth/v7"
+	limiter "github.com/didip/tollbooth/v7/limiter"
+	tollbooth_gin "github.com/didip/tollbooth_gin"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/authentication"
+)
+
+// bindingCookie carries the browser binding between request and exchange
+const bindingCookie = "magic_link_binding"
+
+// MagicLinkRequest is the payload for asking for a login link.
+// @Description address to send the login link to
+// @Property email body string true "email address"
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ExchangeRequest carries the token from a login link.
+// @Description token from the link in the login email
+// @Property token body string true "magic link token"
+type ExchangeRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// MagicLinkHandler handles passwordless login by email link.
+type MagicLinkHandler struct {
+	router       *gin.RouterGroup
+	service      MagicLinkService
+	logger       *zap.Logger
+	secureCookie bool
+}
+
+// NewMagicLinkHandler registers the public, rate limited magic link
+// endpoints on the given router group. secureCookie restricts the browser
+// binding cookie to HTTPS.
+func NewMagicLinkHandler(
+	router *gin.RouterGroup,
+	service MagicLinkService,
+	logger *zap.Logger,
+	secureCookie bool,
+) *MagicLinkHandler {
+	h := &MagicLinkHandler{router: router, service: service, logger: logger, secureCookie: secureCookie}
+
+	// 5 requests per minute limiter
+	requestLimiter := tollbooth.NewLimiter(5, &limiter.ExpirableOptions{
+		DefaultExpirationTTL: time.Minute,
+	})
+	// 10 requests per minute limiter
+	exchangeLimiter := tollbooth.NewLimiter(10, &limiter.ExpirableOptions{
+		DefaultExpirationTTL: time.Minute,
+	})
+
+	h.router.POST("/auth/magic-link", tollbooth_gin.LimitHandler(requestLimiter), h.RequestLink)
+	// GET serves the links in login emails
+	h.router.GET("/auth/magic-link/exchange", tollbooth_gin.LimitHandler(exchangeLimiter), h.Exchange)
+	h.router.POST("/auth/magic-link/exchange", tollbooth_gin.LimitHandler(exchangeLimiter), h.Exchange)
+	return h
+}
+
+// RequestLink godoc
+// @Summary      Request Magic Link
+// @Description  Email a single use login link; the response is the same whether or not the address is registered. The link must be opened in the same browser if browser binding is enabled.
+// @Tags         auth
+// @Accept       json
+// @Param        payload  body      MagicLinkRequest  true  "Account email"
+// @Success      202
+// @Failure      400      {object}  map[string]string
+// @Router       /auth/magic-link [post]
+func (h *MagicLinkHandler) RequestLink(c *gin.Context) {
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email format"})
+		return
+	}
+	binding, err := h.service.Request(c.Request.Context(), req.Email)
+	if err != nil {
+		// only logged, so that failures do not tell registered addresses apart
+		h.logger.Error("Request service failed", zap.Error(err))
+	}
+	if binding != "" {
+		// scoped to this path, which the exchange endpoint lies under
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(bindingCookie, binding, int(h.service.TTL().Seconds()), c.Request.URL.Path, "", h.secureCookie, true)
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// Exchange godoc
+// @Summary      Exchange Magic Link
+// @Description  Log in with the token from a magic link. Returns tokens, or an MFA token if the person has a second factor.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        token    query     string           false  "magic link token, for links"
+// @Param        payload  body      ExchangeRequest  false  "magic link token"
+// @Success      200      {object}  authentication.TokenResponse
+// @Success      202      {object}  authentication.MFAChallengeResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      403      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /auth/magic-link/exchange [post]
+func (h *MagicLinkHandler) Exchange(c *gin.Context) {
+	var req ExchangeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token required"})
+		return
+	}
+	binding, _ := c.Cookie(bindingCookie)
+	result, err := h.service.Exchange(c.Request.Context(), req.Token, binding)
+	switch {
+	case err == nil && result.MFARequired():
+		c.JSON(http.StatusAccepted, authentication.MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    result.MFAToken,
+			Methods:     result.MFAMethods,
+		})
+	case err == nil:
+		c.JSON(http.StatusOK, authentication.TokenResponse{
+			AccessToken:  result.AccessToken,
+			RefreshToken: result.RefreshToken,
+		})
+	case errors.Is(err, ErrInvalidLink):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired magic link"})
+	case errors.Is(err, ErrWrongBrowser):
+		c.JSON(http.StatusForbidden, gin.H{"error": "open the link in the browser you requested it from"})
+	case errors.Is(err, authentication.ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{"error": "email address not verified"})
+	default:
+		h.logger.Error("Exchange service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not login"})
+	}
+}
@@ -0,0 +1,19 @@
+package magiclink
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MagicLink is an outstanding login link sent to a person's address.
+type MagicLink struct {
+	gorm.Model
+	// Token is the SHA-256 hash of the link token's jti
+	Token    string `gorm:"uniqueIndex;not null"`
+	PersonID uint   `gorm:"index;not null"`
+	// Binding is the SHA-256 hash of the nonce given to the browser that
+	// asked for the link, empty if links are not bound to a browser
+	Binding   string
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
@@ -0,0 +1,83 @@
+package magiclink
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrLinkNotFound         = errors.New("magic link not found")
+	ErrLinkNotCreated       = errors.New("magic link not created")
+	ErrBindingMismatch      = errors.New("magic link opened in another browser")
+	ErrUnresponsiveDatabase = errors.New("error occurred during writing to magic links table")
+)
+
+type MagicLinkRepository interface {
+	Create(ctx context.Context, link *MagicLink) error
+	// Consume reads and deletes a link in one transaction, so it can only
+	// be used once. A link bound to a browser is left in place unless
+	// binding matches, so that opening it elsewhere does not use it up.
+	Consume(ctx context.Context, token, binding string) (*MagicLink, error)
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type magicLinkRepository struct {
+	db *gorm.DB
+}
+
+func NewMagicLinkRepository(db *gorm.DB) MagicLinkRepository {
+	return &magicLinkRepository{db: db}
+}
+
+func (r *magicLinkRepository) Create(ctx context.Context, link *MagicLink) error {
+	if err := r.db.WithContext(ctx).Create(link).Error; err != nil {
+		return ErrLinkNotCreated
+	}
+	return nil
+}
+
+func (r *magicLinkRepository) Consume(ctx context.Context, token, binding string) (*MagicLink, error) {
+	var link MagicLink
+	err := r.db.
+		WithContext(ctx).
+		Transaction(func(tx *gorm.DB) error {
+			err := tx.
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("token = ?", token).
+				First(&link).
+				Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrLinkNotFound
+			}
+			if err != nil {
+				return ErrUnresponsiveDatabase
+			}
+			if link.Binding != "" && link.Binding != binding {
+				return ErrBindingMismatch
+			}
+
+			if err := tx.Unscoped().Delete(&link).Error; err != nil {
+				return ErrUnresponsiveDatabase
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (r *magicLinkRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("expires_at <= ?", now).
+		Delete(&MagicLink{})
+	if res.Error != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	return res.RowsAffected, nil
+}
@@ -0,0 +1,206 @@
+package magiclink
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/authentication"
+	"github.com/mehmetcc/definitive-authentication-service/internal/mail"
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+	"github.com/mehmetcc/definitive-authentication-service/internal/utils"
+)
+
+const (
+	purposeMagicLink = "magic_link"
+	bindingBytes     = 32
+	// how often expired links are purged
+	purgeInterval = time.Hour
+)
+
+var (
+	ErrInvalidLink             = errors.New("invalid or expired magic link")
+	ErrWrongBrowser            = errors.New("magic link must be opened in the browser that asked for it")
+	ErrGeneratingBindingFailed = errors.New("generating browser binding failed")
+)
+
+// Settings control the login links.
+type Settings struct {
+	TTL time.Duration
+	// LinkURL is where links point; the token is added as a query parameter
+	LinkURL string
+	// SameBrowser requires links to be opened in the browser that asked
+	// for them
+	SameBrowser bool
+}
+
+type MagicLinkService interface {
+	// Request mails a login link if email belongs to a person; unknown
+	// addresses are not an error. The returned binding is to be kept by
+	// the browser and handed back to Exchange.
+	Request(ctx context.Context, email string) (binding string, err error)
+	// Exchange redeems a link token like a password, returning tokens or
+	// an MFA challenge as Login does
+	Exchange(ctx context.Context, token, binding string) (*authentication.LoginResult, error)
+	TTL() time.Duration
+	Run(ctx context.Context)
+}
+
+type magicLinkService struct {
+	repo          MagicLinkRepository
+	personService person.PersonService
+	authService   authentication.AuthenticationService
+	mailer        mail.Mailer
+	logger        *zap.Logger
+	keys          utils.KeyRing
+	settings      Settings
+}
+
+func NewMagicLinkService(
+	repo MagicLinkRepository,
+	personService person.PersonService,
+	authService authentication.AuthenticationService,
+	mailer mail.Mailer,
+	logger *zap.Logger,
+	keys utils.KeyRing,
+	settings Settings,
+) MagicLinkService {
+	return &magicLinkService{
+		repo:          repo,
+		personService: personService,
+		authService:   authService,
+		mailer:        mailer,
+		logger:        logger,
+		keys:          keys,
+		settings:      settings,
+	}
+}
+
+func (s *magicLinkService) Request(ctx context.Context, email string) (string, error) {
+	// a binding is handed out for unknown addresses too, so that responses
+	// do not tell registered ones apart
+	var binding string
+	if s.settings.SameBrowser {
+		raw := make([]byte, bindingBytes)
+		if _, err := rand.Read(raw); err != nil {
+			s.logger.Error("failed to generate browser binding", zap.Error(err))
+			return "", ErrGeneratingBindingFailed
+		}
+		binding = base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	user, err := s.personService.ReadPersonByEmail(ctx, email)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return binding, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	jti := uuid.NewString()
+	token, err := utils.IssueActionToken(
+		purposeMagicLink,
+		strconv.Itoa(int(user.ID)),
+		user.Email,
+		jti,
+		s.keys,
+		s.settings.TTL,
+	)
+	if err != nil {
+		s.logger.Error("failed to sign magic link token", zap.Error(err))
+		return "", err
+	}
+	link := &MagicLink{
+		Token:     hashToken(jti),
+		PersonID:  user.ID,
+		ExpiresAt: time.Now().Add(s.settings.TTL),
+	}
+	if binding != "" {
+		link.Binding = hashToken(binding)
+	}
+	if err := s.repo.Create(ctx, link); err != nil {
+		s.logger.Error("failed to store magic link", zap.Error(err))
+		return "", err
+	}
+
+	err = s.mailer.Send(ctx, mail.Message{
+		To:       user.Email,
+		Template: "magic_link",
+		Data: map[string]any{
+			"Link":    s.settings.LinkURL + "?token=" + url.QueryEscape(token),
+			"Minutes": int(s.settings.TTL.Minutes()),
+		},
+	})
+	if err != nil {
+		s.logger.Error("failed to send magic link email", zap.Error(err))
+		return "", err
+	}
+	return binding, nil
+}
+
+func (s *magicLinkService) Exchange(ctx context.Context, token, binding string) (*authentication.LoginResult, error) {
+	claims, err := utils.ParseActionToken(token, purposeMagicLink, s.keys)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	link, err := s.repo.Consume(ctx, hashToken(claims.ID), hashToken(binding))
+	switch {
+	case errors.Is(err, ErrLinkNotFound):
+		return nil, ErrInvalidLink
+	case errors.Is(err, ErrBindingMismatch):
+		return nil, ErrWrongBrowser
+	case err != nil:
+		return nil, err
+	}
+	if time.Now().After(link.ExpiresAt) || strconv.Itoa(int(link.PersonID)) != claims.Subject {
+		return nil, ErrInvalidLink
+	}
+
+	user, err := s.personService.ReadPersonByID(ctx, link.PersonID)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return nil, ErrInvalidLink
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the link proves control of the address it was sent to only
+	if user.Email != claims.Email {
+		return nil, ErrInvalidLink
+	}
+	return s.authService.LoginPerson(ctx, user)
+}
+
+func (s *magicLinkService) TTL() time.Duration {
+	return s.settings.TTL
+}
+
+// Run purges expired links until ctx is cancelled.
+func (s *magicLinkService) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.repo.DeleteExpired(ctx, time.Now()); err != nil {
+				s.logger.Error("failed to purge magic links", zap.Error(err))
+			}
+		}
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type fileMailer struct {
+	dir    string
+	from   string
+	logger *zap.Logger
+}
+
+// NewFileMailer stores messages in a maildir at dir instead of delivering
+// them, so that development setups and tests can read what would have been
+// sent with any mail client. The directory is created if needed.
+func NewFileMailer(dir, from string, logger *zap.Logger) (Mailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	return &fileMailer{dir: dir, from: from, logger: logger}, nil
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg Message) error {
+	body, err := encode(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	name := fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), hex.EncodeToString(suffix), hostname)
+
+	// maildir readers only look at new/, so the file shows up complete
+	tmpPath := filepath.Join(m.dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, body, 0o600); err != nil {
+		return err
+	}
+	newPath := filepath.Join(m.dir, "new", name)
+	if err := os.Rename(tmpPath, newPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	m.logger.Debug("email stored", zap.String("to", msg.To), zap.String("path", newPath))
+	return nil
+}
@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNoContent      = errors.New("message has neither a body nor a template")
+	ErrInvalidAddress = errors.New("invalid email address")
+)
+
+// Message is an email to a single recipient. It either carries its content
+// in Subject, Text and HTML, or names a Template that fills them in, see
+// NewTemplateMailer.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string // optional alternative to Text
+
+	Template string // name of the template rendering the content
+	Locale   string // preferred template language, such as "de" or "pt-BR"
+	Data     any    // values the template is executed with
+}
+
+// Mailer delivers email.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type logMailer struct {
+	logger *zap.Logger
+}
+
+// NewLogMailer writes messages to the structured log instead of delivering
+// them. It is meant for development, as message bodies carry codes.
+func NewLogMailer(logger *zap.Logger) Mailer {
+	return &logMailer{logger: logger}
+}
+
+func (m *logMailer) Send(ctx context.Context, msg Message) error {
+	m.logger.Info("email",
+		zap.String("to", msg.To),
+		zap.String("subject", msg.Subject),
+		zap.String("text", msg.Text),
+	)
+	return nil
+}
@@ -0,0 +1,134 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrQueueFull = errors.New("mail queue is full")
+)
+
+// QueueSettings control asynchronous delivery.
+type QueueSettings struct {
+	Size        int           // messages waiting for delivery before Send fails
+	Workers     int           // messages delivered concurrently
+	MaxAttempts int           // delivery attempts per message
+	Backoff     time.Duration // wait before the first retry, doubled after each one
+}
+
+// Queue is a Mailer that returns as soon as a message is queued and
+// delivers it in the background, retrying failed attempts.
+type Queue interface {
+	Mailer
+	// Run delivers queued messages until ctx is cancelled
+	Run(ctx context.Context)
+}
+
+type queue struct {
+	next     Mailer
+	messages chan Message
+	logger   *zap.Logger
+	settings QueueSettings
+}
+
+func NewQueue(next Mailer, logger *zap.Logger, settings QueueSettings) Queue {
+	if settings.Workers < 1 {
+		settings.Workers = 1
+	}
+	if settings.MaxAttempts < 1 {
+		settings.MaxAttempts = 1
+	}
+	return &queue{
+		next:     next,
+		messages: make(chan Message, settings.Size),
+		logger:   logger,
+		settings: settings,
+	}
+}
+
+func (q *queue) Send(ctx context.Context, msg Message) error {
+	if msg.Text == "" && msg.HTML == "" {
+		return ErrNoContent
+	}
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+		q.logger.Error("mail queue is full, dropping message", zap.String("to", msg.To))
+		return ErrQueueFull
+	}
+}
+
+func (q *queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range q.settings.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg := <-q.messages:
+					q.deliver(ctx, msg)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if pending := len(q.messages); pending > 0 {
+		q.logger.Warn("mail queue stopped with undelivered messages", zap.Int("pending", pending))
+	}
+}
+
+func (q *queue) deliver(ctx context.Context, msg Message) {
+	backoff := q.settings.Backoff
+	for attempt := 1; ; attempt++ {
+		err := q.next.Send(ctx, msg)
+		if err == nil {
+			return
+		}
+		if attempt >= q.settings.MaxAttempts || isPermanent(err) {
+			q.logger.Error("failed to deliver email",
+				zap.String("to", msg.To),
+				zap.String("subject", msg.Subject),
+				zap.Int("attempts", attempt),
+				zap.Error(err),
+			)
+			return
+		}
+		q.logger.Warn("email delivery failed, retrying",
+			zap.String("to", msg.To),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			q.logger.Error("gave up delivering email on shutdown", zap.String("to", msg.To))
+			return
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// isPermanent reports whether retrying cannot help, because the message
+// itself is unacceptable or the relay rejected it for good.
+func isPermanent(err error) bool {
+	if errors.Is(err, ErrNoContent) || errors.Is(err, ErrInvalidAddress) {
+		return true
+	}
+	var smtpErr *textproto.Error
+	return errors.As(err, &smtpErr) && smtpErr.Code >= 500
+}
@@ -0,0 +1,124 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// TLS modes for SMTP connections.
+const (
+	// TLSStartTLS upgrades a plain connection, usually on port 587
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects over TLS from the start, usually on port 465
+	TLSImplicit = "tls"
+	// TLSNone never encrypts; only for relays on a trusted network
+	TLSNone = "none"
+)
+
+const defaultSMTPTimeout = 30 * time.Second
+
+var (
+	ErrUnknownTLSMode = errors.New("unknown SMTP TLS mode")
+)
+
+// SMTPSettings describe the relay messages are submitted to.
+type SMTPSettings struct {
+	Host     string
+	Port     int
+	Username string // optional, PLAIN authentication is used when set
+	Password string
+	From     string // sender address, optionally with a display name
+	TLS      string // TLSStartTLS, TLSImplicit or TLSNone
+}
+
+type smtpMailer struct {
+	settings SMTPSettings
+}
+
+// NewSMTPMailer submits messages to an SMTP relay, opening a connection per
+// message.
+func NewSMTPMailer(settings SMTPSettings) (Mailer, error) {
+	switch settings.TLS {
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTLSMode, settings.TLS)
+	}
+	if _, err := mail.ParseAddress(settings.From); err != nil {
+		return nil, fmt.Errorf("%w: sender: %v", ErrInvalidAddress, err)
+	}
+	return &smtpMailer{settings: settings}, nil
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	body, err := encode(m.settings.From, msg)
+	if err != nil {
+		return err
+	}
+	sender, _ := mail.ParseAddress(m.settings.From)
+	recipient, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("%w: recipient: %v", ErrInvalidAddress, err)
+	}
+
+	addr := net.JoinHostPort(m.settings.Host, strconv.Itoa(m.settings.Port))
+	dialer := &net.Dialer{Timeout: defaultSMTPTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultSMTPTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	tlsConfig := &tls.Config{ServerName: m.settings.Host}
+	if m.settings.TLS == TLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, m.settings.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.settings.TLS == TLSStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if m.settings.Username != "" {
+		auth := smtp.PlainAuth("", m.settings.Username, m.settings.Password, m.settings.Host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(sender.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(recipient.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,160 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+var (
+	ErrTemplateNotFound = errors.New("email template not found")
+)
+
+//go:embed templates
+var embedded embed.FS
+
+// DefaultTemplates holds the templates shipped with the service.
+func DefaultTemplates() fs.FS {
+	sub, _ := fs.Sub(embedded, "templates")
+	return sub
+}
+
+// Templates render message content per locale. A template named "name" in
+// locale "en" is read from en/name.txt, which also defines the "subject"
+// template, and from the optional en/name.html.
+type Templates struct {
+	text          map[string]*texttemplate.Template
+	html          map[string]*htmltemplate.Template
+	defaultLocale string
+}
+
+// LoadTemplates parses every locale directory of fsys. Messages in a locale
+// without the requested template fall back to its base language and then
+// to defaultLocale.
+func LoadTemplates(fsys fs.FS, defaultLocale string) (*Templates, error) {
+	t := &Templates{
+		text:          map[string]*texttemplate.Template{},
+		html:          map[string]*htmltemplate.Template{},
+		defaultLocale: normalizeLocale(defaultLocale),
+	}
+	locales, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	for _, locale := range locales {
+		if !locale.IsDir() {
+			continue
+		}
+		files, err := fs.ReadDir(fsys, locale.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			name := path.Join(locale.Name(), file.Name())
+			key := normalizeLocale(locale.Name()) + "/" + strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
+			switch path.Ext(file.Name()) {
+			case ".txt":
+				tmpl, err := texttemplate.ParseFS(fsys, name)
+				if err != nil {
+					return nil, err
+				}
+				if tmpl.Lookup("subject") == nil {
+					return nil, fmt.Errorf("%s does not define a subject", name)
+				}
+				t.text[key] = tmpl
+			case ".html":
+				tmpl, err := htmltemplate.ParseFS(fsys, name)
+				if err != nil {
+					return nil, err
+				}
+				t.html[key] = tmpl
+			}
+		}
+	}
+	for key := range t.html {
+		if _, ok := t.text[key]; !ok {
+			return nil, fmt.Errorf("%s.html has no text variant", key)
+		}
+	}
+	return t, nil
+}
+
+// Render fills in the subject and bodies of msg from its template.
+func (t *Templates) Render(msg Message) (Message, error) {
+	key, ok := t.resolve(msg.Template, msg.Locale)
+	if !ok {
+		return msg, fmt.Errorf("%w: %s", ErrTemplateNotFound, msg.Template)
+	}
+
+	var subject, text bytes.Buffer
+	tmpl := t.text[key]
+	if err := tmpl.ExecuteTemplate(&subject, "subject", msg.Data); err != nil {
+		return msg, err
+	}
+	if err := tmpl.Execute(&text, msg.Data); err != nil {
+		return msg, err
+	}
+	msg.Subject = strings.TrimSpace(subject.String())
+	msg.Text = strings.TrimSpace(text.String()) + "\n"
+
+	if html, ok := t.html[key]; ok {
+		var body bytes.Buffer
+		if err := html.Execute(&body, msg.Data); err != nil {
+			return msg, err
+		}
+		msg.HTML = body.String()
+	}
+	return msg, nil
+}
+
+// resolve finds the most specific locale that has the template.
+func (t *Templates) resolve(name, locale string) (string, bool) {
+	candidates := []string{}
+	if locale = normalizeLocale(locale); locale != "" {
+		candidates = append(candidates, locale)
+		if base, _, found := strings.Cut(locale, "-"); found {
+			candidates = append(candidates, base)
+		}
+	}
+	candidates = append(candidates, t.defaultLocale)
+	for _, candidate := range candidates {
+		if _, ok := t.text[candidate+"/"+name]; ok {
+			return candidate + "/" + name, true
+		}
+	}
+	return "", false
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+type templateMailer struct {
+	next      Mailer
+	templates *Templates
+}
+
+// NewTemplateMailer renders messages that name a template before handing
+// them to next. Rendering happens right away, so template errors reach the
+// caller even when next delivers asynchronously.
+func NewTemplateMailer(next Mailer, templates *Templates) Mailer {
+	return &templateMailer{next: next, templates: templates}
+}
+
+func (m *templateMailer) Send(ctx context.Context, msg Message) error {
+	if msg.Template != "" {
+		rendered, err := m.templates.Render(msg)
+		if err != nil {
+			return err
+		}
+		msg = rendered
+	}
+	return m.next.Send(ctx, msg)
+}
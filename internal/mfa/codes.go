@@ -0,0 +1,70 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	emailCodeDigits = 6
+	// recovery codes are shown in two halves and leave out characters that
+	// are easily confused
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+	recoveryAlphabet   = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// generateEmailCode returns a random numeric code to send by email.
+func generateEmailCode() (string, error) {
+	limit := big.NewInt(1_000_000)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", emailCodeDigits, n.Int64()), nil
+}
+
+// generateRecoveryCodes returns a new set of recovery codes as shown to
+// the person.
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	alphabetSize := big.NewInt(int64(len(recoveryAlphabet)))
+	for range recoveryCodeCount {
+		var b strings.Builder
+		for i := range recoveryCodeLength {
+			if i == recoveryCodeLength/2 {
+				b.WriteByte('-')
+			}
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteByte(recoveryAlphabet[n.Int64()])
+		}
+		codes = append(codes, b.String())
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts a recovery code typed with any case,
+// separators or surrounding space.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// isNumericCode reports whether code looks like a TOTP or email code
+// rather than a recovery code.
+func isNumericCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,324 @@
+package mfa
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+)
+
+// CodeRequest carries a code from the person's authenticator app.
+// @Description a six digit TOTP code
+// @Property code body string true "current code from the authenticator app"
+type CodeRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+// EnrollmentResponse returns a new TOTP secret.
+// @Description secret and otpauth:// URI to add to an authenticator app, e.g. as a QR code
+// @Property secret body string true "base32 encoded secret for manual entry"
+// @Property uri    body string true "otpauth:// provisioning URI"
+type EnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodesResponse returns a new set of recovery codes.
+// @Description single use codes that stand in for the second factor; they are only shown once
+// @Property codes body []string true "recovery codes"
+type RecoveryCodesResponse struct {
+	Codes []string `json:"codes"`
+}
+
+// MFAHandler handles second factor enrollment for the authenticated person.
+type MFAHandler struct {
+	router  *gin.RouterGroup
+	service MFAService
+	logger  *zap.Logger
+}
+
+// NewMFAHandler registers MFA endpoints on the given router group, which
+// must require an authenticated person.
+func NewMFAHandler(router *gin.RouterGroup, service MFAService, logger *zap.Logger) *MFAHandler {
+	h := &MFAHandler{router: router, service: service, logger: logger}
+	h.router.POST("/mfa/totp", h.EnrollTOTP)
+	h.router.POST("/mfa/totp/confirm", h.ConfirmTOTP)
+	h.router.DELETE("/mfa/totp", h.DisableTOTP)
+	h.router.POST("/mfa/email", h.EnrollEmail)
+	h.router.POST("/mfa/email/confirm", h.ConfirmEmail)
+	h.router.POST("/mfa/email/code", h.SendEmailCode)
+	h.router.DELETE("/mfa/email", h.DisableEmail)
+	h.router.POST("/mfa/recovery-codes", h.RegenerateRecoveryCodes)
+	return h
+}
+
+func (h *MFAHandler) currentPerson(c *gin.Context) (*person.Person, bool) {
+	raw, exists := c.Get(person.ContextUserKey)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+	return raw.(*person.Person), true
+}
+
+func (h *MFAHandler) bindCode(c *gin.Context) (string, bool) {
+	var req CodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a six digit code is required"})
+		return "", false
+	}
+	return req.Code, true
+}
+
+// EnrollTOTP godoc
+// @Summary      Enroll TOTP
+// @Description  Generate a TOTP secret for the current person; it is active after confirmation
+// @Tags         mfa
+// @Produce      json
+// @Success      201      {object}  EnrollmentResponse
+// @Failure      401      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /mfa/totp [post]
+func (h *MFAHandler) EnrollTOTP(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	enrollment, err := h.service.EnrollTOTP(c.Request.Context(), user)
+	switch {
+	case err == nil:
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusCreated, EnrollmentResponse{Secret: enrollment.Secret, URI: enrollment.URI})
+	case errors.Is(err, ErrAlreadyEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": "totp already enrolled"})
+	default:
+		h.logger.Error("EnrollTOTP service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not enroll totp"})
+	}
+}
+
+// ConfirmTOTP godoc
+// @Summary      Confirm TOTP
+// @Description  Activate a TOTP enrollment with a first code from the authenticator app
+// @Tags         mfa
+// @Accept       json
+// @Param        payload  body      CodeRequest  true  "Code payload"
+// @Success      204
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /mfa/totp/confirm [post]
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	code, ok := h.bindCode(c)
+	if !ok {
+		return
+	}
+	err := h.service.ConfirmTOTP(c.Request.Context(), user, code)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrInvalidCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+	case errors.Is(err, ErrNotEnrolled):
+		c.JSON(http.StatusNotFound, gin.H{"error": "no totp enrollment to confirm"})
+	case errors.Is(err, ErrAlreadyEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": "totp already confirmed"})
+	default:
+		h.logger.Error("ConfirmTOTP service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not confirm totp"})
+	}
+}
+
+// DisableTOTP godoc
+// @Summary      Disable TOTP
+// @Description  Remove the TOTP factor of the current person, proven with a current code
+// @Tags         mfa
+// @Accept       json
+// @Param        payload  body      CodeRequest  true  "Code payload"
+// @Success      204
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /mfa/totp [delete]
+func (h *MFAHandler) DisableTOTP(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	code, ok := h.bindCode(c)
+	if !ok {
+		return
+	}
+	err := h.service.DisableTOTP(c.Request.Context(), user, code)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrInvalidCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+	case errors.Is(err, ErrNotEnrolled):
+		c.JSON(http.StatusNotFound, gin.H{"error": "totp not enrolled"})
+	default:
+		h.logger.Error("DisableTOTP service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not disable totp"})
+	}
+}
+
+// EnrollEmail godoc
+// @Summary      Enroll Email Codes
+// @Description  Send a code to the current person's email address; email codes are active after confirmation
+// @Tags         mfa
+// @Success      202
+// @Failure      401      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /mfa/email [post]
+func (h *MFAHandler) EnrollEmail(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	err := h.service.EnrollEmail(c.Request.Context(), user)
+	switch {
+	case err == nil:
+		c.Status(http.StatusAccepted)
+	case errors.Is(err, ErrAlreadyEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": "email codes already enrolled"})
+	default:
+		h.logger.Error("EnrollEmail service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not enroll email codes"})
+	}
+}
+
+// ConfirmEmail godoc
+// @Summary      Confirm Email Codes
+// @Description  Activate email codes with the code sent on enrollment
+// @Tags         mfa
+// @Accept       json
+// @Param        payload  body      CodeRequest  true  "Code payload"
+// @Success      204
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /mfa/email/confirm [post]
+func (h *MFAHandler) ConfirmEmail(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	code, ok := h.bindCode(c)
+	if !ok {
+		return
+	}
+	err := h.service.ConfirmEmail(c.Request.Context(), user, code)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrInvalidCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired code"})
+	case errors.Is(err, ErrNotEnrolled):
+		c.JSON(http.StatusNotFound, gin.H{"error": "no email enrollment to confirm"})
+	case errors.Is(err, ErrAlreadyEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": "email codes already confirmed"})
+	default:
+		h.logger.Error("ConfirmEmail service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not confirm email codes"})
+	}
+}
+
+// SendEmailCode godoc
+// @Summary      Send Email Code
+// @Description  Send a new code to the current person's email address, e.g. to disable email codes
+// @Tags         mfa
+// @Success      202
+// @Failure      401      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /mfa/email/code [post]
+func (h *MFAHandler) SendEmailCode(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	err := h.service.SendEmailCode(c.Request.Context(), user)
+	switch {
+	case err == nil:
+		c.Status(http.StatusAccepted)
+	case errors.Is(err, ErrNotEnrolled):
+		c.JSON(http.StatusNotFound, gin.H{"error": "email codes not enrolled"})
+	default:
+		h.logger.Error("SendEmailCode service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not send code"})
+	}
+}
+
+// DisableEmail godoc
+// @Summary      Disable Email Codes
+// @Description  Stop email codes for the current person, proven with a code from /mfa/email/code
+// @Tags         mfa
+// @Accept       json
+// @Param        payload  body      CodeRequest  true  "Code payload"
+// @Success      204
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /mfa/email [delete]
+func (h *MFAHandler) DisableEmail(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	code, ok := h.bindCode(c)
+	if !ok {
+		return
+	}
+	err := h.service.DisableEmail(c.Request.Context(), user, code)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrInvalidCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired code"})
+	case errors.Is(err, ErrNotEnrolled):
+		c.JSON(http.StatusNotFound, gin.H{"error": "email codes not enrolled"})
+	default:
+		h.logger.Error("DisableEmail service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not disable email codes"})
+	}
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary      Regenerate Recovery Codes
+// @Description  Replace the current person's recovery codes with a new set
+// @Tags         mfa
+// @Produce      json
+// @Success      201      {object}  RecoveryCodesResponse
+// @Failure      401      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /mfa/recovery-codes [post]
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	codes, err := h.service.RegenerateRecoveryCodes(c.Request.Context(), user.ID)
+	if err != nil {
+		h.logger.Error("RegenerateRecoveryCodes service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not generate recovery codes"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, RecoveryCodesResponse{Codes: codes})
+}
@@ -0,0 +1,62 @@
+package mfa
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TOTPFactor is a person's authenticator app enrollment. It only counts as
+// a second factor once confirmed with a first code.
+type TOTPFactor struct {
+	gorm.Model
+	PersonID uint `gorm:"uniqueIndex;not null"`
+	// Secret is the shared TOTP key (hidden from JSON)
+	Secret    []byte `json:"-" gorm:"not null"`
+	Confirmed bool   `gorm:"not null;default:false"`
+	// LastUsedStep is the time step of the last accepted code, so a code
+	// cannot be replayed within its validity window
+	LastUsedStep int64 `gorm:"not null;default:0"`
+}
+
+// EmailFactor is a person's opt-in to receive login codes by email. It
+// only counts as a second factor once confirmed with a first code. At most
+// one code is outstanding at a time.
+type EmailFactor struct {
+	gorm.Model
+	PersonID  uint `gorm:"uniqueIndex;not null"`
+	Confirmed bool `gorm:"not null;default:false"`
+	// Code is the SHA-256 hash of the last code sent, empty once used
+	Code          string `json:"-" gorm:"not null;default:''"`
+	CodeExpiresAt *time.Time
+	CodeAttempts  int `gorm:"not null;default:0"`
+}
+
+// RecoveryCode is a single use code that stands in for a person's second
+// factor when it is unavailable.
+type RecoveryCode struct {
+	gorm.Model
+	PersonID uint `gorm:"index;not null"`
+	// Code is the SHA-256 hash of the normalized recovery code
+	Code string `json:"-" gorm:"uniqueIndex;not null"`
+}
+
+// Challenge is the pending second step of a login whose password step
+// succeeded.
+type Challenge struct {
+	gorm.Model
+	// Token is the SHA-256 hash of the challenge token given to the client
+	Token     string    `gorm:"uniqueIndex;not null"`
+	PersonID  uint      `gorm:"index;not null"`
+	Attempts  int       `gorm:"not null;default:0"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	// Scope is the scope granted at the first step of the login, for the
+	// session the challenge completes
+	Scope string `gorm:"not null;default:''"`
+}
+
+// Enrollment is what a person needs to add the factor to their app.
+type Enrollment struct {
+	Secret string
+	URI    string
+}
@@ -0,0 +1,346 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrFactorNotFound       = errors.New("mfa factor not found")
+	ErrFactorNotSaved       = errors.New("mfa factor not saved")
+	ErrStepAlreadyUsed      = errors.New("totp code already used")
+	ErrChallengeNotFound    = errors.New("mfa challenge not found")
+	ErrChallengeNotCreated  = errors.New("mfa challenge not created")
+	ErrTooManyAttempts      = errors.New("too many mfa attempts")
+	ErrCodeNotFound         = errors.New("mfa code not found")
+	ErrUnresponsiveDatabase = errors.New("error occurred during writing to mfa tables")
+)
+
+type FactorRepository interface {
+	// Upsert stores a new unconfirmed factor, replacing any previous one
+	Upsert(ctx context.Context, factor *TOTPFactor) error
+	ReadByPersonID(ctx context.Context, personID uint) (*TOTPFactor, error)
+	// UseStep records step as used, failing with ErrStepAlreadyUsed unless it
+	// is newer than the last used one; it also confirms the factor
+	UseStep(ctx context.Context, personID uint, step int64) error
+	DeleteByPersonID(ctx context.Context, personID uint) error
+}
+
+type factorRepository struct {
+	db *gorm.DB
+}
+
+func NewFactorRepository(db *gorm.DB) FactorRepository {
+	return &factorRepository{db: db}
+}
+
+func (r *factorRepository) Upsert(ctx context.Context, factor *TOTPFactor) error {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "person_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"secret":         factor.Secret,
+				"confirmed":      false,
+				"last_used_step": 0,
+				"updated_at":     time.Now(),
+				"deleted_at":     nil,
+			}),
+		}).
+		Create(factor).
+		Error
+	if err != nil {
+		return ErrFactorNotSaved
+	}
+	return nil
+}
+
+func (r *factorRepository) ReadByPersonID(ctx context.Context, personID uint) (*TOTPFactor, error) {
+	var factor TOTPFactor
+	err := r.db.WithContext(ctx).Where("person_id = ?", personID).First(&factor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFactorNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &factor, nil
+}
+
+func (r *factorRepository) UseStep(ctx context.Context, personID uint, step int64) error {
+	res := r.db.WithContext(ctx).
+		Model(&TOTPFactor{}).
+		Where("person_id = ? AND last_used_step < ?", personID, step).
+		Updates(map[string]interface{}{"last_used_step": step, "confirmed": true})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrStepAlreadyUsed
+	}
+	return nil
+}
+
+func (r *factorRepository) DeleteByPersonID(ctx context.Context, personID uint) error {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("person_id = ?", personID).
+		Delete(&TOTPFactor{})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrFactorNotFound
+	}
+	return nil
+}
+
+type EmailFactorRepository interface {
+	// Upsert stores a new unconfirmed factor with its first code, replacing
+	// any previous one
+	Upsert(ctx context.Context, factor *EmailFactor) error
+	ReadByPersonID(ctx context.Context, personID uint) (*EmailFactor, error)
+	// SetCode replaces the outstanding code and resets its attempts
+	SetCode(ctx context.Context, personID uint, code string, expiresAt time.Time) error
+	// AttemptCode counts one attempt at the outstanding code, failing with
+	// ErrTooManyAttempts once max attempts have been made
+	AttemptCode(ctx context.Context, personID uint, max int) error
+	// UseCode clears the outstanding code if it is still code, failing with
+	// ErrCodeNotFound otherwise; it also confirms the factor
+	UseCode(ctx context.Context, personID uint, code string) error
+	DeleteByPersonID(ctx context.Context, personID uint) error
+}
+
+type emailFactorRepository struct {
+	db *gorm.DB
+}
+
+func NewEmailFactorRepository(db *gorm.DB) EmailFactorRepository {
+	return &emailFactorRepository{db: db}
+}
+
+func (r *emailFactorRepository) Upsert(ctx context.Context, factor *EmailFactor) error {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "person_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"confirmed":       false,
+				"code":            factor.Code,
+				"code_expires_at": factor.CodeExpiresAt,
+				"code_attempts":   0,
+				"updated_at":      time.Now(),
+				"deleted_at":      nil,
+			}),
+		}).
+		Create(factor).
+		Error
+	if err != nil {
+		return ErrFactorNotSaved
+	}
+	return nil
+}
+
+func (r *emailFactorRepository) ReadByPersonID(ctx context.Context, personID uint) (*EmailFactor, error) {
+	var factor EmailFactor
+	err := r.db.WithContext(ctx).Where("person_id = ?", personID).First(&factor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFactorNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &factor, nil
+}
+
+func (r *emailFactorRepository) SetCode(ctx context.Context, personID uint, code string, expiresAt time.Time) error {
+	res := r.db.WithContext(ctx).
+		Model(&EmailFactor{}).
+		Where("person_id = ?", personID).
+		Updates(map[string]interface{}{"code": code, "code_expires_at": expiresAt, "code_attempts": 0})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrFactorNotFound
+	}
+	return nil
+}
+
+func (r *emailFactorRepository) AttemptCode(ctx context.Context, personID uint, max int) error {
+	res := r.db.WithContext(ctx).
+		Model(&EmailFactor{}).
+		Where("person_id = ? AND code_attempts < ?", personID, max).
+		Update("code_attempts", gorm.Expr("code_attempts + 1"))
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrTooManyAttempts
+	}
+	return nil
+}
+
+func (r *emailFactorRepository) UseCode(ctx context.Context, personID uint, code string) error {
+	res := r.db.WithContext(ctx).
+		Model(&EmailFactor{}).
+		Where("person_id = ? AND code = ? AND code <> ''", personID, code).
+		Updates(map[string]interface{}{"code": "", "code_expires_at": nil, "confirmed": true})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrCodeNotFound
+	}
+	return nil
+}
+
+func (r *emailFactorRepository) DeleteByPersonID(ctx context.Context, personID uint) error {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("person_id = ?", personID).
+		Delete(&EmailFactor{})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrFactorNotFound
+	}
+	return nil
+}
+
+type RecoveryCodeRepository interface {
+	// Replace swaps all of a person's recovery codes for new ones
+	Replace(ctx context.Context, personID uint, codes []RecoveryCode) error
+	// Use deletes the matching code, failing with ErrCodeNotFound if the
+	// person has no such code left
+	Use(ctx context.Context, personID uint, code string) error
+	CountByPersonID(ctx context.Context, personID uint) (int64, error)
+}
+
+type recoveryCodeRepository struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeRepository(db *gorm.DB) RecoveryCodeRepository {
+	return &recoveryCodeRepository{db: db}
+}
+
+func (r *recoveryCodeRepository) Replace(ctx context.Context, personID uint, codes []RecoveryCode) error {
+	err := r.db.
+		WithContext(ctx).
+		Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Where("person_id = ?", personID).Delete(&RecoveryCode{}).Error; err != nil {
+				return err
+			}
+			return tx.Create(&codes).Error
+		})
+	if err != nil {
+		return ErrUnresponsiveDatabase
+	}
+	return nil
+}
+
+func (r *recoveryCodeRepository) Use(ctx context.Context, personID uint, code string) error {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("person_id = ? AND code = ?", personID, code).
+		Delete(&RecoveryCode{})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrCodeNotFound
+	}
+	return nil
+}
+
+func (r *recoveryCodeRepository) CountByPersonID(ctx context.Context, personID uint) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&RecoveryCode{}).
+		Where("person_id = ?", personID).
+		Count(&count).
+		Error
+	if err != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	return count, nil
+}
+
+type ChallengeRepository interface {
+	Create(ctx context.Context, challenge *Challenge) error
+	ReadByToken(ctx context.Context, token string) (*Challenge, error)
+	// Attempt counts one verification attempt, failing with
+	// ErrTooManyAttempts once max attempts have been made
+	Attempt(ctx context.Context, id uint, max int) error
+	Consume(ctx context.Context, id uint) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type challengeRepository struct {
+	db *gorm.DB
+}
+
+func NewChallengeRepository(db *gorm.DB) ChallengeRepository {
+	return &challengeRepository{db: db}
+}
+
+func (r *challengeRepository) Create(ctx context.Context, challenge *Challenge) error {
+	if err := r.db.WithContext(ctx).Create(challenge).Error; err != nil {
+		return ErrChallengeNotCreated
+	}
+	return nil
+}
+
+func (r *challengeRepository) ReadByToken(ctx context.Context, token string) (*Challenge, error) {
+	var challenge Challenge
+	err := r.db.WithContext(ctx).Where("token = ?", token).First(&challenge).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrChallengeNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &challenge, nil
+}
+
+func (r *challengeRepository) Attempt(ctx context.Context, id uint, max int) error {
+	res := r.db.WithContext(ctx).
+		Model(&Challenge{}).
+		Where("id = ? AND attempts < ?", id, max).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrTooManyAttempts
+	}
+	return nil
+}
+
+// Consume deletes a challenge; only one of several concurrent completions
+// succeeds.
+func (r *challengeRepository) Consume(ctx context.Context, id uint) error {
+	res := r.db.WithContext(ctx).Unscoped().Delete(&Challenge{}, id)
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrChallengeNotFound
+	}
+	return nil
+}
+
+func (r *challengeRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("expires_at <= ?", now).
+		Delete(&Challenge{})
+	if res.Error != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	return res.RowsAffected, nil
+}
@@ -0,0 +1,486 @@
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/mail"
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+)
+
+const (
+	challengeTTL         = 5 * time.Minute
+	challengeTokenBytes  = 32
+	maxChallengeAttempts = 5
+	emailCodeTTL         = 10 * time.Minute
+	maxEmailCodeAttempts = 5
+	// how often expired challenges are purged
+	purgeInterval = time.Hour
+)
+
+var (
+	ErrAlreadyEnrolled           = errors.New("totp already enrolled")
+	ErrNotEnrolled               = errors.New("second factor not enrolled")
+	ErrInvalidCode               = errors.New("invalid or already used code")
+	ErrGeneratingSecretFailed    = errors.New("generating totp secret failed")
+	ErrGeneratingChallengeFailed = errors.New("generating mfa challenge failed")
+	ErrGeneratingCodeFailed      = errors.New("generating mfa code failed")
+	ErrSendingCodeFailed         = errors.New("sending mfa code failed")
+)
+
+// Factors summarizes the second factors a person has set up.
+type Factors struct {
+	TOTP          bool
+	Email         bool
+	RecoveryCodes int64
+}
+
+type MFAService interface {
+	EnrollTOTP(ctx context.Context, user *person.Person) (*Enrollment, error)
+	ConfirmTOTP(ctx context.Context, user *person.Person, code string) error
+	DisableTOTP(ctx context.Context, user *person.Person, code string) error
+	EnrollEmail(ctx context.Context, user *person.Person) error
+	ConfirmEmail(ctx context.Context, user *person.Person, code string) error
+	SendEmailCode(ctx context.Context, user *person.Person) error
+	DisableEmail(ctx context.Context, user *person.Person, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, personID uint) ([]string, error)
+	Factors(ctx context.Context, personID uint) (*Factors, error)
+	Verify(ctx context.Context, personID uint, code string) error
+	VerifyCode(ctx context.Context, personID uint, code string) error
+	StartChallenge(ctx context.Context, personID uint, scope string) (token string, err error)
+	CompleteChallenge(ctx context.Context, token, code string) (personID uint, err error)
+	ChallengePerson(ctx context.Context, token string) (personID uint, err error)
+	ResolveChallenge(ctx context.Context, token string, verify func(personID uint) error) (*Challenge, error)
+	ChallengeTTL() time.Duration
+	Run(ctx context.Context)
+}
+
+type mfaService struct {
+	factorRepo    FactorRepository
+	emailRepo     EmailFactorRepository
+	recoveryRepo  RecoveryCodeRepository
+	challengeRepo ChallengeRepository
+	mailer        mail.Mailer
+	logger        *zap.Logger
+	// issuer names this service in authenticator apps
+	issuer string
+}
+
+func NewMFAService(
+	factorRepo FactorRepository,
+	emailRepo EmailFactorRepository,
+	recoveryRepo RecoveryCodeRepository,
+	challengeRepo ChallengeRepository,
+	mailer mail.Mailer,
+	logger *zap.Logger,
+	issuer string,
+) MFAService {
+	return &mfaService{
+		factorRepo:    factorRepo,
+		emailRepo:     emailRepo,
+		recoveryRepo:  recoveryRepo,
+		challengeRepo: challengeRepo,
+		mailer:        mailer,
+		logger:        logger,
+		issuer:        issuer,
+	}
+}
+
+// EnrollTOTP generates a new secret for the person. It replaces an
+// unconfirmed enrollment, but a confirmed one has to be disabled first.
+func (s *mfaService) EnrollTOTP(ctx context.Context, user *person.Person) (*Enrollment, error) {
+	existing, err := s.factorRepo.ReadByPersonID(ctx, user.ID)
+	if err != nil && !errors.Is(err, ErrFactorNotFound) {
+		return nil, err
+	}
+	if existing != nil && existing.Confirmed {
+		return nil, ErrAlreadyEnrolled
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		s.logger.Error("failed to generate totp secret", zap.Error(err))
+		return nil, ErrGeneratingSecretFailed
+	}
+	if err := s.factorRepo.Upsert(ctx, &TOTPFactor{PersonID: user.ID, Secret: secret}); err != nil {
+		s.logger.Error("failed to store totp factor", zap.Error(err))
+		return nil, err
+	}
+	return &Enrollment{
+		Secret: encodeSecret(secret),
+		URI:    provisioningURI(s.issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP activates an enrollment once the person proves their app
+// produces valid codes.
+func (s *mfaService) ConfirmTOTP(ctx context.Context, user *person.Person, code string) error {
+	factor, err := s.factorRepo.ReadByPersonID(ctx, user.ID)
+	if errors.Is(err, ErrFactorNotFound) {
+		return ErrNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if factor.Confirmed {
+		return ErrAlreadyEnrolled
+	}
+	return s.useCode(ctx, factor, code)
+}
+
+// DisableTOTP removes the factor; a current code is required so a stolen
+// access token alone cannot turn MFA off.
+func (s *mfaService) DisableTOTP(ctx context.Context, user *person.Person, code string) error {
+	if err := s.Verify(ctx, user.ID, code); err != nil {
+		return err
+	}
+	if err := s.factorRepo.DeleteByPersonID(ctx, user.ID); err != nil && !errors.Is(err, ErrFactorNotFound) {
+		return err
+	}
+	return nil
+}
+
+// EnrollEmail sends a first code to the person's address. It replaces an
+// unconfirmed enrollment, but a confirmed one has to be disabled first.
+func (s *mfaService) EnrollEmail(ctx context.Context, user *person.Person) error {
+	existing, err := s.emailRepo.ReadByPersonID(ctx, user.ID)
+	if err != nil && !errors.Is(err, ErrFactorNotFound) {
+		return err
+	}
+	if existing != nil && existing.Confirmed {
+		return ErrAlreadyEnrolled
+	}
+
+	code, expiresAt, err := s.newEmailCode()
+	if err != nil {
+		return err
+	}
+	factor := &EmailFactor{PersonID: user.ID, Code: hashToken(code), CodeExpiresAt: &expiresAt}
+	if err := s.emailRepo.Upsert(ctx, factor); err != nil {
+		s.logger.Error("failed to store email factor", zap.Error(err))
+		return err
+	}
+	return s.sendEmailCode(ctx, user, code)
+}
+
+// ConfirmEmail activates an email enrollment with the code it sent.
+func (s *mfaService) ConfirmEmail(ctx context.Context, user *person.Person, code string) error {
+	factor, err := s.emailRepo.ReadByPersonID(ctx, user.ID)
+	if errors.Is(err, ErrFactorNotFound) {
+		return ErrNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if factor.Confirmed {
+		return ErrAlreadyEnrolled
+	}
+	return s.useEmailCode(ctx, factor, code)
+}
+
+// SendEmailCode sends a new code to a person with a confirmed email
+// factor, replacing any outstanding one.
+func (s *mfaService) SendEmailCode(ctx context.Context, user *person.Person) error {
+	factor, err := s.emailRepo.ReadByPersonID(ctx, user.ID)
+	if errors.Is(err, ErrFactorNotFound) {
+		return ErrNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if !factor.Confirmed {
+		return ErrNotEnrolled
+	}
+
+	code, expiresAt, err := s.newEmailCode()
+	if err != nil {
+		return err
+	}
+	if err := s.emailRepo.SetCode(ctx, user.ID, hashToken(code), expiresAt); err != nil {
+		s.logger.Error("failed to store email code", zap.Error(err))
+		return err
+	}
+	return s.sendEmailCode(ctx, user, code)
+}
+
+// DisableEmail removes the email factor; like DisableTOTP it needs a
+// current code, obtained with SendEmailCode.
+func (s *mfaService) DisableEmail(ctx context.Context, user *person.Person, code string) error {
+	factor, err := s.emailRepo.ReadByPersonID(ctx, user.ID)
+	if errors.Is(err, ErrFactorNotFound) {
+		return ErrNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if !factor.Confirmed {
+		return ErrNotEnrolled
+	}
+	if err := s.useEmailCode(ctx, factor, code); err != nil {
+		return err
+	}
+	if err := s.emailRepo.DeleteByPersonID(ctx, user.ID); err != nil && !errors.Is(err, ErrFactorNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (s *mfaService) newEmailCode() (string, time.Time, error) {
+	code, err := generateEmailCode()
+	if err != nil {
+		s.logger.Error("failed to generate email code", zap.Error(err))
+		return "", time.Time{}, ErrGeneratingCodeFailed
+	}
+	return code, time.Now().Add(emailCodeTTL), nil
+}
+
+func (s *mfaService) sendEmailCode(ctx context.Context, user *person.Person, code string) error {
+	err := s.mailer.Send(ctx, mail.Message{
+		To:       user.Email,
+		Template: "login_code",
+		Data: map[string]any{
+			"Issuer":  s.issuer,
+			"Code":    code,
+			"Minutes": int(emailCodeTTL.Minutes()),
+		},
+	})
+	if err != nil {
+		s.logger.Error("failed to send email code", zap.Error(err))
+		return ErrSendingCodeFailed
+	}
+	return nil
+}
+
+// useEmailCode accepts the outstanding email code once, within its expiry
+// and a few attempts.
+func (s *mfaService) useEmailCode(ctx context.Context, factor *EmailFactor, code string) error {
+	if factor.Code == "" || factor.CodeExpiresAt == nil || time.Now().After(*factor.CodeExpiresAt) {
+		return ErrInvalidCode
+	}
+	err := s.emailRepo.AttemptCode(ctx, factor.PersonID, maxEmailCodeAttempts)
+	if errors.Is(err, ErrTooManyAttempts) {
+		return ErrInvalidCode
+	}
+	if err != nil {
+		return err
+	}
+
+	hash := hashToken(code)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(factor.Code)) != 1 {
+		return ErrInvalidCode
+	}
+	err = s.emailRepo.UseCode(ctx, factor.PersonID, hash)
+	if errors.Is(err, ErrCodeNotFound) {
+		return ErrInvalidCode
+	}
+	return err
+}
+
+// RegenerateRecoveryCodes replaces the person's recovery codes and returns
+// the new ones; they cannot be shown again.
+func (s *mfaService) RegenerateRecoveryCodes(ctx context.Context, personID uint) ([]string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		s.logger.Error("failed to generate recovery codes", zap.Error(err))
+		return nil, ErrGeneratingCodeFailed
+	}
+	records := make([]RecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		records = append(records, RecoveryCode{PersonID: personID, Code: hashToken(normalizeRecoveryCode(code))})
+	}
+	if err := s.recoveryRepo.Replace(ctx, personID, records); err != nil {
+		s.logger.Error("failed to store recovery codes", zap.Error(err))
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Factors reports which confirmed second factors the person has, and how
+// many recovery codes are left.
+func (s *mfaService) Factors(ctx context.Context, personID uint) (*Factors, error) {
+	var factors Factors
+	totp, err := s.factorRepo.ReadByPersonID(ctx, personID)
+	if err != nil && !errors.Is(err, ErrFactorNotFound) {
+		return nil, err
+	}
+	factors.TOTP = totp != nil && totp.Confirmed
+
+	email, err := s.emailRepo.ReadByPersonID(ctx, personID)
+	if err != nil && !errors.Is(err, ErrFactorNotFound) {
+		return nil, err
+	}
+	factors.Email = email != nil && email.Confirmed
+
+	factors.RecoveryCodes, err = s.recoveryRepo.CountByPersonID(ctx, personID)
+	if err != nil {
+		return nil, err
+	}
+	return &factors, nil
+}
+
+// Verify checks a code against the person's confirmed TOTP factor. Each
+// code is accepted once.
+func (s *mfaService) Verify(ctx context.Context, personID uint, code string) error {
+	factor, err := s.factorRepo.ReadByPersonID(ctx, personID)
+	if errors.Is(err, ErrFactorNotFound) {
+		return ErrNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if !factor.Confirmed {
+		return ErrNotEnrolled
+	}
+	return s.useCode(ctx, factor, code)
+}
+
+// VerifyCode checks code against any of the person's code based factors:
+// their authenticator app, the outstanding email code, or a recovery code.
+func (s *mfaService) VerifyCode(ctx context.Context, personID uint, code string) error {
+	if !isNumericCode(code) {
+		err := s.recoveryRepo.Use(ctx, personID, hashToken(normalizeRecoveryCode(code)))
+		if errors.Is(err, ErrCodeNotFound) {
+			return ErrInvalidCode
+		}
+		return err
+	}
+
+	err := s.Verify(ctx, personID, code)
+	if !errors.Is(err, ErrInvalidCode) && !errors.Is(err, ErrNotEnrolled) {
+		return err
+	}
+	factor, emailErr := s.emailRepo.ReadByPersonID(ctx, personID)
+	if errors.Is(emailErr, ErrFactorNotFound) || (emailErr == nil && !factor.Confirmed) {
+		return err
+	}
+	if emailErr != nil {
+		return emailErr
+	}
+	return s.useEmailCode(ctx, factor, code)
+}
+
+func (s *mfaService) useCode(ctx context.Context, factor *TOTPFactor, code string) error {
+	step, ok := matchStep(factor.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidCode
+	}
+	err := s.factorRepo.UseStep(ctx, factor.PersonID, step)
+	if errors.Is(err, ErrStepAlreadyUsed) {
+		return ErrInvalidCode
+	}
+	return err
+}
+
+// StartChallenge opens the second step of a login and returns the token
+// the client presents with the code.
+func (s *mfaService) StartChallenge(ctx context.Context, personID uint, scope string) (string, error) {
+	raw := make([]byte, challengeTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		s.logger.Error("failed to generate mfa challenge", zap.Error(err))
+		return "", ErrGeneratingChallengeFailed
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	err := s.challengeRepo.Create(ctx, &Challenge{
+		Token:     hashToken(token),
+		PersonID:  personID,
+		ExpiresAt: time.Now().Add(challengeTTL),
+		Scope:     scope,
+	})
+	if err != nil {
+		s.logger.Error("failed to store mfa challenge", zap.Error(err))
+		return "", err
+	}
+	return token, nil
+}
+
+// CompleteChallenge verifies a code for a challenge, see VerifyCode, and
+// returns the person it belongs to.
+func (s *mfaService) CompleteChallenge(ctx context.Context, token, code string) (uint, error) {
+	challenge, err := s.ResolveChallenge(ctx, token, func(personID uint) error {
+		return s.VerifyCode(ctx, personID, code)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return challenge.PersonID, nil
+}
+
+// ChallengePerson returns the person an open challenge belongs to, for
+// second factors that need a round trip before they can be verified.
+func (s *mfaService) ChallengePerson(ctx context.Context, token string) (uint, error) {
+	challenge, err := s.openChallenge(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+	return challenge.PersonID, nil
+}
+
+// ResolveChallenge completes a challenge once verify accepts the second
+// factor of its person, and returns it. A challenge allows a few attempts
+// and is single use.
+func (s *mfaService) ResolveChallenge(ctx context.Context, token string, verify func(personID uint) error) (*Challenge, error) {
+	challenge, err := s.openChallenge(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.challengeRepo.Attempt(ctx, challenge.ID, maxChallengeAttempts); err != nil {
+		if errors.Is(err, ErrTooManyAttempts) {
+			_ = s.challengeRepo.Consume(ctx, challenge.ID)
+		}
+		return nil, err
+	}
+
+	if err := verify(challenge.PersonID); err != nil {
+		return nil, err
+	}
+	if err := s.challengeRepo.Consume(ctx, challenge.ID); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func (s *mfaService) openChallenge(ctx context.Context, token string) (*Challenge, error) {
+	challenge, err := s.challengeRepo.ReadByToken(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrChallengeNotFound
+	}
+	return challenge, nil
+}
+
+func (s *mfaService) ChallengeTTL() time.Duration {
+	return challengeTTL
+}
+
+// Run purges expired challenges until ctx is cancelled.
+func (s *mfaService) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.challengeRepo.DeleteExpired(ctx, time.Now()); err != nil {
+				s.logger.Error("failed to purge mfa challenges", zap.Error(err))
+			}
+		}
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,79 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Discovery is the OpenID Connect provider metadata document.
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// NewDiscovery describes a provider whose OAuth endpoints are mounted
+// under apiPath of issuer and whose ID tokens are signed with algorithm.
+func NewDiscovery(issuer, apiPath, algorithm string) *Discovery {
+	api := issuer + apiPath
+	return &Discovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             api + "/oauth/authorize",
+		TokenEndpoint:                     api + "/oauth/token",
+		UserInfoEndpoint:                  api + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             api + "/oauth/introspect",
+		RevocationEndpoint:                api + "/oauth/revoke",
+		DeviceAuthorizationEndpoint:       api + "/oauth/device_authorization",
+		ScopesSupported:                   []string{ScopeOpenID, "email"},
+		ResponseTypesSupported:            []string{responseTypeCode},
+		GrantTypesSupported:               []string{grantTypeAuthorizationCode, grantTypeRefreshToken, grantTypeClientCredentials, grantTypeDeviceCode},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{algorithm},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{codeChallengeMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified"},
+	}
+}
+
+// DiscoveryHandler serves the OpenID Connect discovery document.
+type DiscoveryHandler struct {
+	router    *gin.RouterGroup
+	discovery *Discovery
+	logger    *zap.Logger
+}
+
+// NewDiscoveryHandler registers the discovery endpoint on the given router
+// group, which must be mounted at the issuer's root.
+func NewDiscoveryHandler(router *gin.RouterGroup, discovery *Discovery, logger *zap.Logger) *DiscoveryHandler {
+	h := &DiscoveryHandler{router: router, discovery: discovery, logger: logger}
+	h.router.GET("/.well-known/openid-configuration", h.OpenIDConfiguration)
+	return h
+}
+
+// OpenIDConfiguration godoc
+// @Summary      OpenID Connect Discovery
+// @Description  Provider metadata for OpenID Connect relying parties
+// @Tags         oauth
+// @Produce      json
+// @Success      200      {object}  Discovery
+// @Router       /.well-known/openid-configuration [get]
+func (h *DiscoveryHandler) OpenIDConfiguration(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, h.discovery)
+}
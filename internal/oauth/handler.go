@@ -0,0 +1,740 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	tollbooth "github.com/didip/tollbooth/v7"
+	limiter "github.com/didip/tollbooth/v7/limiter"
+	tollbooth_gin "github.com/didip/tollbooth_gin"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/authentication"
+	"github.com/mehmetcc/definitive-authentication-service/internal/client"
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+)
+
+const (
+	grantTypeAuthorizationCode = client.GrantTypeAuthorizationCode
+	grantTypeRefreshToken      = client.GrantTypeRefreshToken
+	grantTypeClientCredentials = client.GrantTypeClientCredentials
+	grantTypeDeviceCode        = client.GrantTypeDeviceCode
+)
+
+// AuthorizeRequest holds the RFC 6749 authorization request parameters,
+// read from the query string and echoed back by the hosted login form.
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	Nonce               string `form:"nonce"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+}
+
+// TokenRequest is the form posted to the token endpoint.
+type TokenRequest struct {
+	GrantType    string `form:"grant_type" binding:"required"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	DeviceCode   string `form:"device_code"`
+	Scope        string `form:"scope"`
+}
+
+// DeviceAuthorizationRequest is the form posted to the device authorization endpoint.
+type DeviceAuthorizationRequest struct {
+	Scope string `form:"scope"`
+}
+
+// DeviceAuthorizationResponse is the RFC 8628 device authorization response.
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// TokenResponse is the RFC 6749 access token response.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// UserInfoResponse is the OpenID Connect UserInfo response.
+type UserInfoResponse struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// IntrospectRequest is the form posted to the introspection endpoint.
+type IntrospectRequest struct {
+	Token         string                       `form:"token" binding:"required"`
+	TokenTypeHint authentication.TokenTypeHint `form:"token_type_hint"`
+}
+
+// RevokeRequest is the form posted to the revocation endpoint.
+type RevokeRequest struct {
+	Token         string                       `form:"token" binding:"required"`
+	TokenTypeHint authentication.TokenTypeHint `form:"token_type_hint"`
+}
+
+// IntrospectionResponse is the RFC 7662 introspection response. Only
+// active is set for inactive tokens.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	TokenType string   `json:"token_type,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Tenant    uint     `json:"tid,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+}
+
+// OAuthHandler handles the OAuth 2.0 protocol endpoints.
+type OAuthHandler struct {
+	router        *gin.RouterGroup
+	service       OAuthService
+	authService   authentication.AuthenticationService
+	clientService client.ClientService
+	logger        *zap.Logger
+}
+
+// NewOAuthHandler registers OAuth endpoints on the given router group,
+// with rate limiting applied to the hosted login forms.
+func NewOAuthHandler(
+	router *gin.RouterGroup,
+	service OAuthService,
+	authService authentication.AuthenticationService,
+	clientService client.ClientService,
+	logger *zap.Logger,
+) *OAuthHandler {
+	h := &OAuthHandler{
+		router:        router,
+		service:       service,
+		authService:   authService,
+		clientService: clientService,
+		logger:        logger,
+	}
+
+	// 5 requests per minute limiter
+	loginLimiter := tollbooth.NewLimiter(5, &limiter.ExpirableOptions{
+		DefaultExpirationTTL: time.Minute,
+	})
+
+	h.router.GET("/oauth/authorize", h.Authorize)
+	h.router.POST(
+		"/oauth/authorize",
+		tollbooth_gin.LimitHandler(loginLimiter),
+		h.Consent,
+	)
+	h.router.POST("/oauth/token", h.Token)
+	h.router.POST("/oauth/device_authorization", h.DeviceAuthorization)
+	h.router.GET("/oauth/device", h.DeviceVerification)
+	h.router.POST(
+		"/oauth/device",
+		tollbooth_gin.LimitHandler(loginLimiter),
+		h.DeviceDecision,
+	)
+	h.router.POST("/oauth/introspect", h.Introspect)
+	h.router.POST("/oauth/revoke", h.Revoke)
+	return h
+}
+
+// authenticateClient accepts client_secret_basic and client_secret_post
+// credentials and writes the RFC 6749 error response when they are invalid.
+func (h *OAuthHandler) authenticateClient(c *gin.Context) (*client.Client, bool) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	if clientID == "" || secret == "" {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		return nil, false
+	}
+
+	cl, err := h.clientService.Authenticate(c.Request.Context(), clientID, secret)
+	switch {
+	case err == nil:
+		return cl, true
+	case errors.Is(err, client.ErrInvalidClientSecret):
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+	default:
+		h.logger.Error("clientService.Authenticate failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	}
+	return nil, false
+}
+
+// identifyClient authenticates confidential clients like authenticateClient
+// and identifies public clients, which cannot authenticate, by client_id.
+func (h *OAuthHandler) identifyClient(c *gin.Context) (*client.Client, bool) {
+	if _, _, ok := c.Request.BasicAuth(); ok || c.PostForm("client_secret") != "" {
+		return h.authenticateClient(c)
+	}
+
+	cl, err := h.clientService.ReadClientByClientID(c.Request.Context(), c.PostForm("client_id"))
+	switch {
+	case err == nil && cl.Public:
+		return cl, true
+	case err == nil, errors.Is(err, client.ErrClientNotFound):
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+	default:
+		h.logger.Error("clientService.ReadClientByClientID failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	}
+	return nil, false
+}
+
+// authenticatePerson checks the password and, when the person has one,
+// the second factor code posted on a hosted login form.
+func (h *OAuthHandler) authenticatePerson(c *gin.Context, email string) (*person.Person, error) {
+	user, err := h.authService.Authenticate(c.Request.Context(), email, c.PostForm("password"))
+	if err != nil {
+		return nil, err
+	}
+	if err := h.authService.VerifySecondFactor(c.Request.Context(), user, c.PostForm("otp")); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// loginErrorMessage returns what a hosted login form tells the person
+// about a failed authenticatePerson, or false for unexpected errors.
+func loginErrorMessage(err error) (string, bool) {
+	switch {
+	case errors.Is(err, authentication.ErrInvalidCredentials):
+		return "Invalid email or password.", true
+	case errors.Is(err, authentication.ErrMFARequired):
+		return "Enter your authentication code. If you use email codes, one has been sent to you.", true
+	case errors.Is(err, authentication.ErrInvalidMFACode):
+		return "Invalid authentication code.", true
+	case errors.Is(err, authentication.ErrEmailNotVerified):
+		return "Confirm your email address before signing in.", true
+	case errors.Is(err, authentication.ErrPasskeyRequired):
+		return "This account needs a passkey to sign in, which this page does not support.", true
+	case errors.Is(err, authentication.ErrAccountLocked):
+		return "Too many failed attempts. Try again later.", true
+	}
+	return "", false
+}
+
+// validateAuthorization checks the request and answers it when it is
+// invalid: on the error page while the redirect URI cannot be trusted,
+// back at the client otherwise.
+func (h *OAuthHandler) validateAuthorization(c *gin.Context, req *AuthorizeRequest) (*client.Client, bool) {
+	cl, err := h.service.ValidateAuthorization(c.Request.Context(), req)
+	switch {
+	case err == nil:
+		return cl, true
+	case errors.Is(err, ErrUnknownClient):
+		h.renderPage(c, http.StatusBadRequest, "error.html", errorPage{Error: "The application is not registered."})
+	case errors.Is(err, ErrInvalidRedirectURI):
+		h.renderPage(c, http.StatusBadRequest, "error.html", errorPage{Error: "The redirect URI is not registered for this application."})
+	case errors.Is(err, ErrUnsupportedResponseType):
+		redirectWithError(c, req, "unsupported_response_type", "only response_type=code is supported")
+	case errors.Is(err, ErrInvalidCodeChallenge):
+		redirectWithError(c, req, "invalid_request", "code_challenge with code_challenge_method=S256 is required")
+	case errors.Is(err, ErrUnauthorizedClient):
+		redirectWithError(c, req, "unauthorized_client", "the client may not use the authorization code grant")
+	case errors.Is(err, ErrInvalidScope):
+		redirectWithError(c, req, "invalid_scope", "the requested scope is unknown")
+	default:
+		h.logger.Error("ValidateAuthorization service failed", zap.Error(err))
+		h.renderPage(c, http.StatusInternalServerError, "error.html", errorPage{Error: "Something went wrong, please try again."})
+	}
+	return nil, false
+}
+
+// Authorize godoc
+// @Summary      Authorization Endpoint
+// @Description  Show the hosted login and consent page for an authorization code request with PKCE
+// @Tags         oauth
+// @Produce      html
+// @Param        response_type          query  string  true   "must be code"
+// @Param        client_id              query  string  true   "Client ID"
+// @Param        redirect_uri           query  string  false  "registered redirect URI, optional if only one is registered"
+// @Param        scope                  query  string  false  "requested scope"
+// @Param        state                  query  string  false  "opaque value returned to the client"
+// @Param        nonce                  query  string  false  "OpenID Connect nonce copied into the ID token"
+// @Param        code_challenge         query  string  true   "PKCE code challenge"
+// @Param        code_challenge_method  query  string  true   "must be S256"
+// @Success      200
+// @Failure      302
+// @Failure      400
+// @Router       /oauth/authorize [get]
+func (h *OAuthHandler) Authorize(c *gin.Context) {
+	var req AuthorizeRequest
+	_ = c.ShouldBindQuery(&req)
+
+	cl, ok := h.validateAuthorization(c, &req)
+	if !ok {
+		return
+	}
+	h.renderPage(c, http.StatusOK, "authorize.html", authorizePage{
+		ClientName: cl.Name,
+		Scope:      req.Scope,
+		Request:    &req,
+	})
+}
+
+// Consent godoc
+// @Summary      Authorization Consent
+// @Description  Log in on the hosted page and allow or deny the client; redirects back with a code or an error
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      html
+// @Param        email     formData  string  true  "Email"
+// @Param        password  formData  string  true  "Password"
+// @Param        otp       formData  string  false "second factor code, if enabled"
+// @Param        decision  formData  string  true  "allow or deny"
+// @Success      302
+// @Failure      400
+// @Failure      401
+// @Failure      429
+// @Router       /oauth/authorize [post]
+func (h *OAuthHandler) Consent(c *gin.Context) {
+	var req AuthorizeRequest
+	_ = c.ShouldBind(&req)
+
+	cl, ok := h.validateAuthorization(c, &req)
+	if !ok {
+		return
+	}
+	if c.PostForm("decision") != "allow" {
+		redirectWithError(c, &req, "access_denied", "the user denied the request")
+		return
+	}
+
+	email := c.PostForm("email")
+	user, err := h.authenticatePerson(c, email)
+	if message, ok := loginErrorMessage(err); ok {
+		h.renderPage(c, http.StatusUnauthorized, "authorize.html", authorizePage{
+			ClientName: cl.Name,
+			Scope:      req.Scope,
+			Email:      email,
+			Error:      message,
+			Request:    &req,
+		})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Authenticate service failed", zap.Error(err))
+		redirectWithError(c, &req, "server_error", "")
+		return
+	}
+
+	code, err := h.service.Authorize(c.Request.Context(), &req, user)
+	if errors.Is(err, ErrInvalidScope) {
+		redirectWithError(c, &req, "invalid_scope", "the requested scope exceeds what the user may grant")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Authorize service failed", zap.Error(err))
+		redirectWithError(c, &req, "server_error", "")
+		return
+	}
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	redirectTo(c, req.RedirectURI, params)
+}
+
+// Token godoc
+// @Summary      Token Endpoint
+// @Description  Exchange an authorization code (with its PKCE verifier), a refresh token, client credentials or a device code for tokens
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        grant_type     formData  string  true   "authorization_code, refresh_token, client_credentials or urn:ietf:params:oauth:grant-type:device_code"
+// @Param        code           formData  string  false  "authorization code"
+// @Param        redirect_uri   formData  string  false  "redirect URI used in the authorization request"
+// @Param        code_verifier  formData  string  false  "PKCE code verifier"
+// @Param        refresh_token  formData  string  false  "refresh token"
+// @Param        device_code    formData  string  false  "device code, polled until the person decides"
+// @Param        scope          formData  string  false  "requested scope, for client_credentials"
+// @Param        client_id      formData  string  false  "Client ID, for public clients"
+// @Success      200      {object}  TokenResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Security     BasicAuth
+// @Router       /oauth/token [post]
+func (h *OAuthHandler) Token(c *gin.Context) {
+	cl, ok := h.identifyClient(c)
+	if !ok {
+		return
+	}
+	var req TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	if slices.Contains(client.GrantTypes, req.GrantType) && !cl.AllowsGrantType(req.GrantType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unauthorized_client"})
+		return
+	}
+
+	var (
+		grant *TokenGrant
+		err   error
+	)
+	switch req.GrantType {
+	case grantTypeAuthorizationCode:
+		if req.Code == "" || req.CodeVerifier == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+			return
+		}
+		grant, err = h.service.ExchangeCode(c.Request.Context(), cl, req.Code, req.RedirectURI, req.CodeVerifier)
+	case grantTypeRefreshToken:
+		if req.RefreshToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+			return
+		}
+		grant, err = h.service.Refresh(c.Request.Context(), cl, req.RefreshToken)
+	case grantTypeClientCredentials:
+		grant, err = h.service.ClientCredentials(c.Request.Context(), cl, req.Scope)
+	case grantTypeDeviceCode:
+		if req.DeviceCode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+			return
+		}
+		grant, err = h.service.PollDeviceAuthorization(c.Request.Context(), cl, req.DeviceCode)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, TokenResponse{
+			AccessToken:  grant.AccessToken,
+			TokenType:    "Bearer",
+			ExpiresIn:    int64(grant.ExpiresIn.Seconds()),
+			RefreshToken: grant.RefreshToken,
+			IDToken:      grant.IDToken,
+			Scope:        grant.Scope,
+		})
+	case errors.Is(err, ErrInvalidGrant):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+	case errors.Is(err, ErrInvalidScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+	case errors.Is(err, ErrUnauthorizedClient):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unauthorized_client"})
+	case errors.Is(err, ErrAuthorizationPending):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "authorization_pending"})
+	case errors.Is(err, ErrSlowDown):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "slow_down"})
+	case errors.Is(err, ErrAccessDenied):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "access_denied"})
+	case errors.Is(err, ErrExpiredToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expired_token"})
+	default:
+		h.logger.Error("token grant failed", zap.String("grant_type", req.GrantType), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	}
+}
+
+// DeviceAuthorization godoc
+// @Summary      Device Authorization
+// @Description  RFC 8628: start a device authorization and get the user code to show and the device code to poll with
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        client_id  formData  string  false  "Client ID, for public clients"
+// @Param        scope      formData  string  false  "requested scope"
+// @Success      200      {object}  DeviceAuthorizationResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Security     BasicAuth
+// @Router       /oauth/device_authorization [post]
+func (h *OAuthHandler) DeviceAuthorization(c *gin.Context) {
+	cl, ok := h.identifyClient(c)
+	if !ok {
+		return
+	}
+	if !cl.AllowsGrantType(grantTypeDeviceCode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unauthorized_client"})
+		return
+	}
+	var req DeviceAuthorizationRequest
+	_ = c.ShouldBind(&req)
+
+	grant, err := h.service.StartDeviceAuthorization(c.Request.Context(), cl, req.Scope)
+	if errors.Is(err, ErrInvalidScope) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("StartDeviceAuthorization service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, DeviceAuthorizationResponse{
+		DeviceCode:              grant.DeviceCode,
+		UserCode:                grant.UserCode,
+		VerificationURI:         grant.VerificationURI,
+		VerificationURIComplete: grant.VerificationURIComplete,
+		ExpiresIn:               int64(grant.ExpiresIn.Seconds()),
+		Interval:                grant.Interval,
+	})
+}
+
+// DeviceVerification godoc
+// @Summary      Device Verification Page
+// @Description  Show the page where a person enters the user code shown on a device
+// @Tags         oauth
+// @Produce      html
+// @Param        user_code  query  string  false  "user code, prefilled from verification_uri_complete"
+// @Success      200
+// @Router       /oauth/device [get]
+func (h *OAuthHandler) DeviceVerification(c *gin.Context) {
+	page := devicePage{UserCode: c.Query("user_code")}
+	if page.UserCode != "" {
+		_, cl, err := h.service.ReadDeviceAuthorization(c.Request.Context(), page.UserCode)
+		switch {
+		case err == nil:
+			page.ClientName = cl.Name
+		case errors.Is(err, ErrInvalidUserCode):
+			page.Error = "The code is invalid or has expired."
+		default:
+			h.logger.Error("ReadDeviceAuthorization service failed", zap.Error(err))
+			page.Error = "Something went wrong, please try again."
+		}
+	}
+	h.renderPage(c, http.StatusOK, "device.html", page)
+}
+
+// DeviceDecision godoc
+// @Summary      Device Verification
+// @Description  Log in on the device verification page and allow or deny the device
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      html
+// @Param        user_code  formData  string  true  "user code shown on the device"
+// @Param        email      formData  string  true  "Email"
+// @Param        password   formData  string  true  "Password"
+// @Param        otp        formData  string  false "second factor code, if enabled"
+// @Param        decision   formData  string  true  "allow or deny"
+// @Success      200
+// @Failure      400
+// @Failure      401
+// @Failure      403
+// @Failure      429
+// @Router       /oauth/device [post]
+func (h *OAuthHandler) DeviceDecision(c *gin.Context) {
+	page := devicePage{UserCode: c.PostForm("user_code"), Email: c.PostForm("email")}
+
+	device, cl, err := h.service.ReadDeviceAuthorization(c.Request.Context(), page.UserCode)
+	switch {
+	case err == nil:
+		page.ClientName, page.Scope = cl.Name, device.Scope
+	case errors.Is(err, ErrInvalidUserCode):
+		page.Error = "The code is invalid or has expired."
+		h.renderPage(c, http.StatusBadRequest, "device.html", page)
+		return
+	default:
+		h.logger.Error("ReadDeviceAuthorization service failed", zap.Error(err))
+		page.Error = "Something went wrong, please try again."
+		h.renderPage(c, http.StatusInternalServerError, "device.html", page)
+		return
+	}
+
+	user, err := h.authenticatePerson(c, page.Email)
+	if message, ok := loginErrorMessage(err); ok {
+		page.Error = message
+		h.renderPage(c, http.StatusUnauthorized, "device.html", page)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Authenticate service failed", zap.Error(err))
+		page.Error = "Something went wrong, please try again."
+		h.renderPage(c, http.StatusInternalServerError, "device.html", page)
+		return
+	}
+
+	approve := c.PostForm("decision") == "allow"
+	err = h.service.DecideDeviceAuthorization(c.Request.Context(), page.UserCode, user, approve)
+	switch {
+	case err == nil && approve:
+		h.renderPage(c, http.StatusOK, "device.html", devicePage{Message: "Device connected. You can return to your device."})
+	case err == nil:
+		h.renderPage(c, http.StatusOK, "device.html", devicePage{Message: "Request denied. The device was not connected."})
+	case errors.Is(err, ErrInvalidUserCode):
+		page.Error = "The code is invalid or has expired."
+		h.renderPage(c, http.StatusBadRequest, "device.html", page)
+	case errors.Is(err, ErrInvalidScope):
+		page.Error = "You may not grant the access this device asks for."
+		h.renderPage(c, http.StatusForbidden, "device.html", page)
+	default:
+		h.logger.Error("DecideDeviceAuthorization service failed", zap.Error(err))
+		page.Error = "Something went wrong, please try again."
+		h.renderPage(c, http.StatusInternalServerError, "device.html", page)
+	}
+}
+
+// UserInfo godoc
+// @Summary      UserInfo
+// @Description  OpenID Connect claims about the person the access token was issued to
+// @Tags         oauth
+// @Produce      json
+// @Success      200      {object}  UserInfoResponse
+// @Failure      401      {object}  map[string]string
+// @Router       /userinfo [get]
+func (h *OAuthHandler) UserInfo(c *gin.Context) {
+	raw, exists := c.Get(person.ContextUserKey)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		return
+	}
+	user := raw.(*person.Person)
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, UserInfoResponse{
+		Subject:       strconv.FormatUint(uint64(user.ID), 10),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+// Introspect godoc
+// @Summary      Token Introspection
+// @Description  RFC 7662 introspection of access and refresh tokens of any client, for confidential clients such as resource servers
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        token            formData  string  true   "Token to introspect"
+// @Param        token_type_hint  formData  string  false  "access_token or refresh_token"
+// @Success      200      {object}  IntrospectionResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Security     BasicAuth
+// @Router       /oauth/introspect [post]
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	if _, ok := h.authenticateClient(c); !ok {
+		return
+	}
+	var req IntrospectRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.logger.Warn("invalid introspection request", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	result, err := h.authService.Introspect(c.Request.Context(), req.Token, req.TokenTypeHint)
+	if err != nil {
+		h.logger.Error("Introspect service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	if !result.Active {
+		c.JSON(http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+	c.JSON(http.StatusOK, IntrospectionResponse{
+		Active:    true,
+		TokenType: string(result.TokenType),
+		Subject:   result.Subject,
+		ClientID:  result.ClientID,
+		Tenant:    result.OrganizationID,
+		Roles:     result.Roles,
+		Groups:    result.Groups,
+		Scope:     result.Scope,
+		IssuedAt:  result.IssuedAt.Unix(),
+		ExpiresAt: result.ExpiresAt.Unix(),
+	})
+}
+
+// Revoke godoc
+// @Summary      Token Revocation
+// @Description  RFC 7009 revocation of an access token (until it expires) or a refresh token (with its family) issued to the authenticated client
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        token            formData  string  true   "Token to revoke"
+// @Param        token_type_hint  formData  string  false  "access_token or refresh_token"
+// @Success      200
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      503      {object}  map[string]string
+// @Security     BasicAuth
+// @Router       /oauth/revoke [post]
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	cl, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+	var req RevokeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.logger.Warn("invalid revocation request", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	// invalid tokens and those of other clients are answered with 200 as
+	// well, see RFC 7009 section 2.2
+	ctx := authentication.WithClientID(c.Request.Context(), cl.ClientID)
+	if err := h.authService.Revoke(ctx, req.Token, req.TokenTypeHint); err != nil {
+		h.logger.Error("Revoke service failed", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "temporarily_unavailable"})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// redirectWithError sends an RFC 6749 section 4.1.2.1 error response to the
+// client's redirect URI.
+func redirectWithError(c *gin.Context, req *AuthorizeRequest, code, description string) {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	redirectTo(c, req.RedirectURI, params)
+}
+
+// redirectTo adds params to the query of a registered redirect URI.
+func redirectTo(c *gin.Context, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid redirect uri")
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	target.RawQuery = query.Encode()
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, target.String())
+}
@@ -0,0 +1,52 @@
+package oauth
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuthorizationCode is a single-use grant handed to a client after a person
+// logged in and consented on the hosted authorization page.
+type AuthorizationCode struct {
+	gorm.Model
+	// Code is the SHA-256 hash of the code given to the client
+	Code          string    `gorm:"uniqueIndex;not null"`
+	ClientID      string    `gorm:"index;not null"`
+	PersonID      uint      `gorm:"index;not null"`
+	RedirectURI   string    `gorm:"not null"`
+	CodeChallenge string    `gorm:"not null"`
+	Scope         string    `gorm:"not null;default:''"`
+	Nonce         string    `gorm:"not null;default:''"`
+	AuthTime      time.Time `gorm:"not null"`
+	ExpiresAt     time.Time `gorm:"index;not null"`
+}
+
+// DeviceStatus is the state of a device authorization.
+type DeviceStatus string
+
+const (
+	DevicePending  DeviceStatus = "pending"
+	DeviceApproved DeviceStatus = "approved"
+	DeviceDenied   DeviceStatus = "denied"
+)
+
+// DeviceAuthorization is an RFC 8628 device authorization: the device
+// polls with the device code while a person approves the user code on the
+// verification page.
+type DeviceAuthorization struct {
+	gorm.Model
+	// DeviceCode is the SHA-256 hash of the code given to the device
+	DeviceCode string `gorm:"uniqueIndex;not null"`
+	// UserCode is stored normalized, without the separator shown to people
+	UserCode string       `gorm:"uniqueIndex;not null"`
+	ClientID string       `gorm:"index;not null"`
+	Scope    string       `gorm:"not null;default:''"`
+	Status   DeviceStatus `gorm:"type:text;not null;default:'pending'"`
+	// PersonID is set once a person approved the request
+	PersonID *uint
+	// Interval is the minimum polling interval in seconds, raised on slow_down
+	Interval     int `gorm:"not null"`
+	LastPolledAt *time.Time
+	ExpiresAt    time.Time `gorm:"index;not null"`
+}
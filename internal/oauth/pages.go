@@ -0,0 +1,51 @@
+package oauth
+
+import (
+	"embed"
+	"html/template"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var pages = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// authorizePage is the data rendered by templates/authorize.html.
+type authorizePage struct {
+	ClientName string
+	Scope      string
+	Email      string
+	Error      string
+	Request    *AuthorizeRequest
+}
+
+// devicePage is the data rendered by templates/device.html. The form is
+// replaced by Message once a decision was recorded.
+type devicePage struct {
+	UserCode   string
+	ClientName string
+	Scope      string
+	Email      string
+	Error      string
+	Message    string
+}
+
+// errorPage is the data rendered by templates/error.html.
+type errorPage struct {
+	Error string
+}
+
+// renderPage writes an HTML page that may not be framed or cached.
+func (h *OAuthHandler) renderPage(c *gin.Context, status int, name string, data any) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := pages.ExecuteTemplate(c.Writer, name, data); err != nil {
+		h.logger.Error("failed to render page", zap.String("page", name), zap.Error(err))
+	}
+}
@@ -0,0 +1,192 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCodeNotFound         = errors.New("authorization code not found")
+	ErrCodeNotCreated       = errors.New("authorization code not created")
+	ErrUnresponsiveDatabase = errors.New("error occurred during writing to authorization codes table")
+
+	ErrDeviceNotFound   = errors.New("device authorization not found")
+	ErrDeviceNotCreated = errors.New("device authorization not created")
+	ErrUserCodeTaken    = errors.New("user code already in use")
+)
+
+type CodeRepository interface {
+	Create(ctx context.Context, code *AuthorizationCode) error
+	Consume(ctx context.Context, code string) (*AuthorizationCode, error)
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type codeRepository struct {
+	db *gorm.DB
+}
+
+func NewCodeRepository(db *gorm.DB) CodeRepository {
+	return &codeRepository{db: db}
+}
+
+func (r *codeRepository) Create(ctx context.Context, code *AuthorizationCode) error {
+	if err := r.db.WithContext(ctx).Create(code).Error; err != nil {
+		return ErrCodeNotCreated
+	}
+	return nil
+}
+
+// Consume reads and deletes a code in one transaction, so a code can only
+// ever be exchanged once even under concurrent requests.
+func (r *codeRepository) Consume(ctx context.Context, code string) (*AuthorizationCode, error) {
+	var record AuthorizationCode
+	err := r.db.
+		WithContext(ctx).
+		Transaction(func(tx *gorm.DB) error {
+			err := tx.
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("code = ?", code).
+				First(&record).
+				Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCodeNotFound
+			}
+			if err != nil {
+				return ErrUnresponsiveDatabase
+			}
+
+			if err := tx.Unscoped().Delete(&record).Error; err != nil {
+				return ErrUnresponsiveDatabase
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *codeRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("expires_at <= ?", now).
+		Delete(&AuthorizationCode{})
+	if res.Error != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	return res.RowsAffected, nil
+}
+
+type DeviceRepository interface {
+	Create(ctx context.Context, device *DeviceAuthorization) error
+	ReadByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error)
+	ReadByDeviceCode(ctx context.Context, deviceCode string) (*DeviceAuthorization, error)
+	Decide(ctx context.Context, userCode string, status DeviceStatus, personID *uint, now time.Time) error
+	Touch(ctx context.Context, id uint, polledAt time.Time, interval int) error
+	Consume(ctx context.Context, deviceCode string) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type deviceRepository struct {
+	db *gorm.DB
+}
+
+func NewDeviceRepository(db *gorm.DB) DeviceRepository {
+	return &deviceRepository{db: db}
+}
+
+func (r *deviceRepository) Create(ctx context.Context, device *DeviceAuthorization) error {
+	if err := r.db.WithContext(ctx).Create(device).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return ErrUserCodeTaken
+		}
+		return ErrDeviceNotCreated
+	}
+	return nil
+}
+
+func (r *deviceRepository) ReadByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	return r.readBy(ctx, "user_code = ?", userCode)
+}
+
+func (r *deviceRepository) ReadByDeviceCode(ctx context.Context, deviceCode string) (*DeviceAuthorization, error) {
+	return r.readBy(ctx, "device_code = ?", deviceCode)
+}
+
+func (r *deviceRepository) readBy(ctx context.Context, query string, arg string) (*DeviceAuthorization, error) {
+	var device DeviceAuthorization
+	err := r.db.WithContext(ctx).Where(query, arg).First(&device).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &device, nil
+}
+
+// Decide approves or denies a pending, unexpired authorization. Deciding
+// twice fails with ErrDeviceNotFound.
+func (r *deviceRepository) Decide(
+	ctx context.Context,
+	userCode string,
+	status DeviceStatus,
+	personID *uint,
+	now time.Time,
+) error {
+	res := r.db.WithContext(ctx).
+		Model(&DeviceAuthorization{}).
+		Where("user_code = ? AND status = ? AND expires_at > ?", userCode, DevicePending, now).
+		Updates(map[string]interface{}{"status": status, "person_id": personID})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+func (r *deviceRepository) Touch(ctx context.Context, id uint, polledAt time.Time, interval int) error {
+	err := r.db.WithContext(ctx).
+		Model(&DeviceAuthorization{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"last_polled_at": polledAt, "interval": interval}).
+		Error
+	if err != nil {
+		return ErrUnresponsiveDatabase
+	}
+	return nil
+}
+
+// Consume deletes a decided authorization. Only one of several concurrent
+// polls succeeds, so tokens are issued at most once.
+func (r *deviceRepository) Consume(ctx context.Context, deviceCode string) error {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("device_code = ? AND status <> ?", deviceCode, DevicePending).
+		Delete(&DeviceAuthorization{})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+func (r *deviceRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("expires_at <= ?", now).
+		Delete(&DeviceAuthorization{})
+	if res.Error != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	return res.RowsAffected, nil
+}
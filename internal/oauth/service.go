@@ -0,0 +1,575 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/authentication"
+	"github.com/mehmetcc/definitive-authentication-service/internal/client"
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+	"github.com/mehmetcc/definitive-authentication-service/internal/utils"
+)
+
+const (
+	authorizationCodeTTL   = time.Minute
+	authorizationCodeBytes = 32
+	// how often expired authorization codes are purged
+	purgeInterval = time.Hour
+
+	codeChallengeMethodS256 = "S256"
+	responseTypeCode        = "code"
+
+	// ScopeOpenID turns an authorization request into an OpenID Connect one
+	ScopeOpenID = "openid"
+
+	deviceAuthorizationTTL = 10 * time.Minute
+	// polling interval in seconds, and how much slow_down adds to it
+	devicePollInterval = 5
+	deviceSlowDownStep = 5
+	// consonants only, so user codes cannot spell words (RFC 8628 section 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+	// attempts at finding an unused user code
+	userCodeAttempts = 3
+)
+
+var (
+	// errors that make the redirect URI untrustworthy; never redirect on these
+	ErrUnknownClient      = errors.New("unknown client")
+	ErrInvalidRedirectURI = errors.New("redirect uri not registered for client")
+
+	// errors reported back to the client through the redirect URI
+	ErrUnsupportedResponseType = errors.New("unsupported response type")
+	ErrInvalidCodeChallenge    = errors.New("code challenge missing or method not S256")
+
+	ErrInvalidGrant         = errors.New("authorization code invalid, expired or already used")
+	ErrInvalidScope         = errors.New("scope exceeds what the person or client may grant")
+	ErrIssuingCodeFailed    = errors.New("issuing authorization code failed")
+	ErrIssuingIDTokenFailed = errors.New("issuing id token failed")
+	ErrUnauthorizedClient   = errors.New("client not allowed to use this grant")
+
+	// device authorization polling results, RFC 8628 section 3.5
+	ErrAuthorizationPending = errors.New("device authorization pending")
+	ErrSlowDown             = errors.New("device polling too fast")
+	ErrAccessDenied         = errors.New("device authorization denied")
+	ErrExpiredToken         = errors.New("device authorization expired")
+	ErrInvalidUserCode      = errors.New("user code invalid, expired or already used")
+)
+
+// TokenGrant is what the token endpoint hands to a client.
+type TokenGrant struct {
+	AccessToken  string
+	RefreshToken string
+	// IDToken is only set for OpenID Connect requests
+	IDToken   string
+	ExpiresIn time.Duration
+	Scope     string
+}
+
+// DeviceGrant is the RFC 8628 device authorization response.
+type DeviceGrant struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresIn               time.Duration
+	Interval                int
+}
+
+type OAuthService interface {
+	ValidateAuthorization(ctx context.Context, req *AuthorizeRequest) (*client.Client, error)
+	Authorize(ctx context.Context, req *AuthorizeRequest, user *person.Person) (code string, err error)
+	ExchangeCode(ctx context.Context, cl *client.Client, code, redirectURI, codeVerifier string) (*TokenGrant, error)
+	Refresh(ctx context.Context, cl *client.Client, refreshJWT string) (*TokenGrant, error)
+	ClientCredentials(ctx context.Context, cl *client.Client, scope string) (*TokenGrant, error)
+	StartDeviceAuthorization(ctx context.Context, cl *client.Client, scope string) (*DeviceGrant, error)
+	ReadDeviceAuthorization(ctx context.Context, userCode string) (*DeviceAuthorization, *client.Client, error)
+	DecideDeviceAuthorization(ctx context.Context, userCode string, user *person.Person, approve bool) error
+	PollDeviceAuthorization(ctx context.Context, cl *client.Client, deviceCode string) (*TokenGrant, error)
+	Run(ctx context.Context)
+}
+
+type oauthService struct {
+	codeRepo      CodeRepository
+	deviceRepo    DeviceRepository
+	authService   authentication.AuthenticationService
+	clientService client.ClientService
+	personService person.PersonService
+	logger        *zap.Logger
+
+	// OpenID Connect settings
+	issuer string
+	idKeys utils.KeyRing
+
+	// verificationURI is the page where people enter device user codes
+	verificationURI string
+}
+
+func NewOAuthService(
+	codeRepo CodeRepository,
+	deviceRepo DeviceRepository,
+	authService authentication.AuthenticationService,
+	clientService client.ClientService,
+	personService person.PersonService,
+	logger *zap.Logger,
+	issuer string,
+	idKeys utils.KeyRing,
+	verificationURI string,
+) OAuthService {
+	return &oauthService{
+		codeRepo:      codeRepo,
+		deviceRepo:    deviceRepo,
+		authService:   authService,
+		clientService: clientService,
+		personService: personService,
+		logger:        logger,
+		issuer:        issuer,
+		idKeys:        idKeys,
+
+		verificationURI: verificationURI,
+	}
+}
+
+// ValidateAuthorization checks an authorization request. The client and
+// redirect URI are checked first; ErrUnknownClient and ErrInvalidRedirectURI
+// must be shown to the user, every other error may be redirected back.
+// An omitted redirect_uri defaults to the only registered one.
+func (s *oauthService) ValidateAuthorization(ctx context.Context, req *AuthorizeRequest) (*client.Client, error) {
+	cl, err := s.clientService.ReadClientByClientID(ctx, req.ClientID)
+	if errors.Is(err, client.ErrClientNotFound) {
+		return nil, ErrUnknownClient
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if req.RedirectURI == "" && len(cl.RedirectURIs) == 1 {
+		req.RedirectURI = cl.RedirectURIs[0]
+	}
+	if !cl.HasRedirectURI(req.RedirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	if req.ResponseType != responseTypeCode {
+		return cl, ErrUnsupportedResponseType
+	}
+	if !cl.AllowsGrantType(client.GrantTypeAuthorizationCode) {
+		return cl, ErrUnauthorizedClient
+	}
+	if !authentication.ValidScope(req.Scope) {
+		return cl, ErrInvalidScope
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != codeChallengeMethodS256 {
+		return cl, ErrInvalidCodeChallenge
+	}
+	return cl, nil
+}
+
+// Authorize issues a code for a validated request once the person has
+// logged in and consented, or returns ErrInvalidScope if the person may not
+// grant the requested scope.
+func (s *oauthService) Authorize(ctx context.Context, req *AuthorizeRequest, user *person.Person) (string, error) {
+	err := s.authService.CheckScope(ctx, user.ID, req.Scope)
+	if errors.Is(err, authentication.ErrInvalidScope) {
+		return "", ErrInvalidScope
+	}
+	if err != nil {
+		return "", err
+	}
+
+	raw := make([]byte, authorizationCodeBytes)
+	if _, err := rand.Read(raw); err != nil {
+		s.logger.Error("failed to generate authorization code", zap.Error(err))
+		return "", ErrIssuingCodeFailed
+	}
+	code := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	record := &AuthorizationCode{
+		Code:          hashCode(code),
+		ClientID:      req.ClientID,
+		PersonID:      user.ID,
+		RedirectURI:   req.RedirectURI,
+		CodeChallenge: req.CodeChallenge,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		AuthTime:      now,
+		ExpiresAt:     now.Add(authorizationCodeTTL),
+	}
+	if err := s.codeRepo.Create(ctx, record); err != nil {
+		s.logger.Error("failed to store authorization code", zap.Error(err))
+		return "", err
+	}
+	return code, nil
+}
+
+// ExchangeCode redeems an authorization code for the same token pair a
+// password login issues, plus an ID token when openid was requested.
+func (s *oauthService) ExchangeCode(
+	ctx context.Context,
+	cl *client.Client,
+	code, redirectURI, codeVerifier string,
+) (*TokenGrant, error) {
+	record, err := s.codeRepo.Consume(ctx, hashCode(code))
+	if errors.Is(err, ErrCodeNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		s.logger.Error("failed to consume authorization code", zap.Error(err))
+		return nil, err
+	}
+
+	if time.Now().After(record.ExpiresAt) ||
+		record.ClientID != cl.ClientID ||
+		record.RedirectURI != redirectURI ||
+		!verifyCodeChallenge(record.CodeChallenge, codeVerifier) {
+		return nil, ErrInvalidGrant
+	}
+
+	user, err := s.personService.ReadPersonByID(ctx, record.PersonID)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = authentication.WithClientID(authentication.WithScope(ctx, record.Scope), cl.ClientID)
+	access, refresh, err := s.authService.IssueTokens(ctx, user)
+	if errors.Is(err, authentication.ErrInvalidScope) {
+		return nil, ErrInvalidScope
+	}
+	if err != nil {
+		s.logger.Error("failed to issue tokens for authorization code", zap.Error(err))
+		return nil, err
+	}
+	grant := &TokenGrant{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    s.authService.AccessTokenTTL(),
+		Scope:        record.Scope,
+	}
+	if HasScope(record.Scope, ScopeOpenID) {
+		grant.IDToken, err = utils.IssueIDToken(
+			s.issuer,
+			cl.ClientID,
+			user,
+			record.Nonce,
+			record.AuthTime,
+			s.idKeys,
+			s.authService.AccessTokenTTL(),
+		)
+		if err != nil {
+			s.logger.Error("failed to issue id token", zap.Error(err))
+			return nil, ErrIssuingIDTokenFailed
+		}
+	}
+	return grant, nil
+}
+
+// Refresh rotates a refresh token issued to cl; tokens of other clients
+// are refused as invalid grants.
+func (s *oauthService) Refresh(ctx context.Context, cl *client.Client, refreshJWT string) (*TokenGrant, error) {
+	access, refresh, err := s.authService.Refresh(authentication.WithClientID(ctx, cl.ClientID), refreshJWT)
+	if errors.Is(err, authentication.ErrInvalidRefreshToken) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &TokenGrant{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    s.authService.AccessTokenTTL(),
+	}, nil
+}
+
+// ClientCredentials issues an access token to a confidential client acting
+// on its own behalf, limited to the scopes registered for the client.
+func (s *oauthService) ClientCredentials(ctx context.Context, cl *client.Client, requested string) (*TokenGrant, error) {
+	if cl.Public {
+		return nil, ErrUnauthorizedClient
+	}
+	scope, ok := cl.GrantScope(requested)
+	if !ok {
+		return nil, ErrInvalidScope
+	}
+	access, err := s.authService.IssueClientToken(ctx, cl, scope)
+	if err != nil {
+		s.logger.Error("failed to issue client token", zap.String("clientID", cl.ClientID), zap.Error(err))
+		return nil, err
+	}
+	return &TokenGrant{
+		AccessToken: access,
+		ExpiresIn:   s.authService.AccessTokenTTL(),
+		Scope:       scope,
+	}, nil
+}
+
+// StartDeviceAuthorization creates a pending device authorization for the
+// device to poll on while a person approves it, or returns ErrInvalidScope
+// if no person could grant the requested scope.
+func (s *oauthService) StartDeviceAuthorization(ctx context.Context, cl *client.Client, scope string) (*DeviceGrant, error) {
+	if !authentication.ValidScope(scope) {
+		return nil, ErrInvalidScope
+	}
+	raw := make([]byte, authorizationCodeBytes)
+	if _, err := rand.Read(raw); err != nil {
+		s.logger.Error("failed to generate device code", zap.Error(err))
+		return nil, ErrIssuingCodeFailed
+	}
+	deviceCode := base64.RawURLEncoding.EncodeToString(raw)
+
+	for attempt := 0; attempt < userCodeAttempts; attempt++ {
+		userCode, err := generateUserCode()
+		if err != nil {
+			s.logger.Error("failed to generate user code", zap.Error(err))
+			return nil, ErrIssuingCodeFailed
+		}
+
+		err = s.deviceRepo.Create(ctx, &DeviceAuthorization{
+			DeviceCode: hashCode(deviceCode),
+			UserCode:   userCode,
+			ClientID:   cl.ClientID,
+			Scope:      scope,
+			Status:     DevicePending,
+			Interval:   devicePollInterval,
+			ExpiresAt:  time.Now().Add(deviceAuthorizationTTL),
+		})
+		if errors.Is(err, ErrUserCodeTaken) {
+			continue
+		}
+		if err != nil {
+			s.logger.Error("failed to store device authorization", zap.Error(err))
+			return nil, err
+		}
+
+		return &DeviceGrant{
+			DeviceCode:              deviceCode,
+			UserCode:                FormatUserCode(userCode),
+			VerificationURI:         s.verificationURI,
+			VerificationURIComplete: s.verificationURI + "?user_code=" + url.QueryEscape(FormatUserCode(userCode)),
+			ExpiresIn:               deviceAuthorizationTTL,
+			Interval:                devicePollInterval,
+		}, nil
+	}
+	s.logger.Error("no unused user code found", zap.Int("attempts", userCodeAttempts))
+	return nil, ErrIssuingCodeFailed
+}
+
+// ReadDeviceAuthorization looks up a pending device authorization by the
+// user code a person typed in, and the client that requested it.
+func (s *oauthService) ReadDeviceAuthorization(ctx context.Context, userCode string) (*DeviceAuthorization, *client.Client, error) {
+	device, err := s.deviceRepo.ReadByUserCode(ctx, NormalizeUserCode(userCode))
+	if errors.Is(err, ErrDeviceNotFound) {
+		return nil, nil, ErrInvalidUserCode
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if device.Status != DevicePending || time.Now().After(device.ExpiresAt) {
+		return nil, nil, ErrInvalidUserCode
+	}
+
+	cl, err := s.clientService.ReadClientByClientID(ctx, device.ClientID)
+	if errors.Is(err, client.ErrClientNotFound) {
+		return nil, nil, ErrInvalidUserCode
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return device, cl, nil
+}
+
+// DecideDeviceAuthorization records whether the person approved the device.
+// Approving returns ErrInvalidScope, leaving the authorization pending, if
+// the person may not grant the scope the device asked for.
+func (s *oauthService) DecideDeviceAuthorization(ctx context.Context, userCode string, user *person.Person, approve bool) error {
+	status, personID := DeviceDenied, (*uint)(nil)
+	if approve {
+		device, _, err := s.ReadDeviceAuthorization(ctx, userCode)
+		if err != nil {
+			return err
+		}
+		err = s.authService.CheckScope(ctx, user.ID, device.Scope)
+		if errors.Is(err, authentication.ErrInvalidScope) {
+			return ErrInvalidScope
+		}
+		if err != nil {
+			return err
+		}
+		status, personID = DeviceApproved, &user.ID
+	}
+	err := s.deviceRepo.Decide(ctx, NormalizeUserCode(userCode), status, personID, time.Now())
+	if errors.Is(err, ErrDeviceNotFound) {
+		return ErrInvalidUserCode
+	}
+	return err
+}
+
+// PollDeviceAuthorization answers a device polling the token endpoint.
+// Until the person decides it returns ErrAuthorizationPending, or
+// ErrSlowDown when the device polls faster than its interval.
+func (s *oauthService) PollDeviceAuthorization(ctx context.Context, cl *client.Client, deviceCode string) (*TokenGrant, error) {
+	hash := hashCode(deviceCode)
+	device, err := s.deviceRepo.ReadByDeviceCode(ctx, hash)
+	if errors.Is(err, ErrDeviceNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	if device.ClientID != cl.ClientID {
+		return nil, ErrInvalidGrant
+	}
+
+	now := time.Now()
+	if now.After(device.ExpiresAt) {
+		return nil, ErrExpiredToken
+	}
+
+	switch device.Status {
+	case DevicePending:
+		interval := device.Interval
+		tooFast := device.LastPolledAt != nil &&
+			now.Sub(*device.LastPolledAt) < time.Duration(interval)*time.Second
+		if tooFast {
+			interval += deviceSlowDownStep
+		}
+		if err := s.deviceRepo.Touch(ctx, device.ID, now, interval); err != nil {
+			return nil, err
+		}
+		if tooFast {
+			return nil, ErrSlowDown
+		}
+		return nil, ErrAuthorizationPending
+	case DeviceDenied:
+		if err := s.deviceRepo.Consume(ctx, hash); err != nil && !errors.Is(err, ErrDeviceNotFound) {
+			return nil, err
+		}
+		return nil, ErrAccessDenied
+	}
+
+	// approved: only the poll that consumes the authorization gets tokens
+	if err := s.deviceRepo.Consume(ctx, hash); err != nil {
+		if errors.Is(err, ErrDeviceNotFound) {
+			return nil, ErrInvalidGrant
+		}
+		return nil, err
+	}
+	if device.PersonID == nil {
+		return nil, ErrInvalidGrant
+	}
+	user, err := s.personService.ReadPersonByID(ctx, *device.PersonID)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = authentication.WithClientID(authentication.WithScope(ctx, device.Scope), cl.ClientID)
+	access, refresh, err := s.authService.IssueTokens(ctx, user)
+	if errors.Is(err, authentication.ErrInvalidScope) {
+		return nil, ErrInvalidScope
+	}
+	if err != nil {
+		s.logger.Error("failed to issue tokens for device authorization", zap.Error(err))
+		return nil, err
+	}
+	return &TokenGrant{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    s.authService.AccessTokenTTL(),
+		Scope:        device.Scope,
+	}, nil
+}
+
+// Run purges authorization codes and device authorizations that were never
+// redeemed, until ctx is cancelled.
+func (s *oauthService) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.codeRepo.DeleteExpired(ctx, time.Now()); err != nil {
+				s.logger.Error("failed to purge authorization codes", zap.Error(err))
+			}
+			if _, err := s.deviceRepo.DeleteExpired(ctx, time.Now()); err != nil {
+				s.logger.Error("failed to purge device authorizations", zap.Error(err))
+			}
+		}
+	}
+}
+
+// HasScope reports whether the space separated scope contains want.
+func HasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatUserCode splits a user code in two halves for people to read.
+func FormatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// NormalizeUserCode undoes FormatUserCode and forgives case and spacing.
+func NormalizeUserCode(input string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(input) {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func generateUserCode() (string, error) {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	code := make([]byte, userCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyCodeChallenge implements the S256 method of RFC 7636.
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,504 @@
+package organization
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+	"github.com/mehmetcc/definitive-authentication-service/internal/rbac"
+)
+
+// OrganizationRequest represents the payload for creating an organization.
+// @Description payload to create an organization
+// @Property name     body string true  "display name"
+// @Property slug     body string true  "unique short name"
+// @Property settings body object false "free-form settings"
+type OrganizationRequest struct {
+	Name     string            `json:"name" binding:"required,max=255"`
+	Slug     string            `json:"slug" binding:"required"`
+	Settings map[string]string `json:"settings" binding:"max=50"`
+}
+
+// UpdateOrganizationRequest represents the payload for changing an organization.
+// @Description payload to replace the name and settings of an organization
+// @Property name     body string true  "display name"
+// @Property settings body object false "free-form settings"
+type UpdateOrganizationRequest struct {
+	Name     string            `json:"name" binding:"required,max=255"`
+	Settings map[string]string `json:"settings" binding:"max=50"`
+}
+
+// InviteMemberRequest represents the payload for inviting a member.
+// @Description payload to invite a person to an organization
+// @Property email body string   true  "email address of the person"
+// @Property roles body []string false "names of the person's roles within the organization"
+type InviteMemberRequest struct {
+	Email string   `json:"email" binding:"required,email"`
+	Roles []string `json:"roles"`
+}
+
+// MemberRolesRequest represents the payload for assigning roles to a member.
+// @Description payload to replace the roles of a member
+// @Property roles body []string true "names of the member's roles within the organization"
+type MemberRolesRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// IDRequest represents a URI id parameter.
+type IDRequest struct {
+	ID uint `uri:"id" binding:"required,min=1"`
+}
+
+// MemberRequest represents the URI parameters naming a member.
+type MemberRequest struct {
+	ID       uint `uri:"id" binding:"required,min=1"`
+	PersonID uint `uri:"person_id" binding:"required,min=1"`
+}
+
+// OrganizationHandler handles HTTP requests for organizations.
+type OrganizationHandler struct {
+	router  *gin.RouterGroup
+	service OrganizationService
+	logger  *zap.Logger
+}
+
+// NewOrganizationHandler registers organization endpoints on the given
+// router group; authorize guards each of them with a permission.
+func NewOrganizationHandler(
+	router *gin.RouterGroup,
+	service OrganizationService,
+	logger *zap.Logger,
+	authorize func(permission string) gin.HandlerFunc,
+) *OrganizationHandler {
+	h := &OrganizationHandler{router: router, service: service, logger: logger}
+	h.router.POST("/organizations", authorize(rbac.OrganizationsManage), h.CreateOrganization)
+	h.router.GET("/organizations", authorize(rbac.OrganizationsRead), h.ListOrganizations)
+	h.router.GET("/organizations/:id", authorize(rbac.OrganizationsRead), h.ReadOrganization)
+	h.router.PUT("/organizations/:id", authorize(rbac.OrganizationsManage), h.UpdateOrganization)
+	h.router.DELETE("/organizations/:id", authorize(rbac.OrganizationsManage), h.DeleteOrganization)
+	h.router.GET("/organizations/:id/members", authorize(rbac.MembersRead), h.ListMembers)
+	h.router.POST("/organizations/:id/members", authorize(rbac.MembersManage), h.InviteMember)
+	h.router.PUT("/organizations/:id/members/:person_id", authorize(rbac.MembersManage), h.SetMemberRoles)
+	h.router.DELETE("/organizations/:id/members/:person_id", authorize(rbac.MembersManage), h.RemoveMember)
+	return h
+}
+
+func (h *OrganizationHandler) bindID(c *gin.Context) (uint, bool) {
+	var uri IDRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id"})
+		return 0, false
+	}
+	return uri.ID, true
+}
+
+func (h *OrganizationHandler) bindMember(c *gin.Context) (MemberRequest, bool) {
+	var uri MemberRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id or person_id"})
+		return uri, false
+	}
+	return uri, true
+}
+
+// CreateOrganization godoc
+// @Summary      Create Organization
+// @Description  Create an organization
+// @Tags         organizations
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      OrganizationRequest  true  "Organization payload"
+// @Success      201      {object}  Organization
+// @Failure      400      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /organizations [post]
+func (h *OrganizationHandler) CreateOrganization(c *gin.Context) {
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid create organization payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and slug required, at most 50 settings"})
+		return
+	}
+	organization, err := h.service.CreateOrganization(c.Request.Context(), req.Name, req.Slug, req.Settings)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, organization)
+	case errors.Is(err, ErrInvalidSlug):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrOrganizationAlreadyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "organization already exists"})
+	default:
+		h.logger.Error("service.CreateOrganization failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create organization"})
+	}
+}
+
+// ListOrganizations godoc
+// @Summary      List Organizations
+// @Description  List all organizations, or only the current one when acting in an organization
+// @Tags         organizations
+// @Produce      json
+// @Success      200      {array}   Organization
+// @Failure      500      {object}  map[string]string
+// @Router       /organizations [get]
+func (h *OrganizationHandler) ListOrganizations(c *gin.Context) {
+	organizations, err := h.service.ListOrganizations(c.Request.Context())
+	if err != nil {
+		h.logger.Error("service.ListOrganizations failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list organizations"})
+		return
+	}
+	c.JSON(http.StatusOK, organizations)
+}
+
+// ReadOrganization godoc
+// @Summary      Get Organization
+// @Description  Fetch an organization by ID
+// @Tags         organizations
+// @Produce      json
+// @Param        id       path      int  true  "Organization ID"
+// @Success      200      {object}  Organization
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /organizations/{id} [get]
+func (h *OrganizationHandler) ReadOrganization(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	organization, err := h.service.ReadOrganization(c.Request.Context(), id)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, organization)
+	case errors.Is(err, ErrOrganizationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+	default:
+		h.logger.Error("service.ReadOrganization failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch organization"})
+	}
+}
+
+// UpdateOrganization godoc
+// @Summary      Update Organization
+// @Description  Replace the name and settings of an organization
+// @Tags         organizations
+// @Accept       json
+// @Produce      json
+// @Param        id       path      int                        true  "Organization ID"
+// @Param        payload  body      UpdateOrganizationRequest  true  "Organization payload"
+// @Success      200      {object}  Organization
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /organizations/{id} [put]
+func (h *OrganizationHandler) UpdateOrganization(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	var req UpdateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid update organization payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name required, at most 50 settings"})
+		return
+	}
+	organization, err := h.service.UpdateOrganization(c.Request.Context(), id, req.Name, req.Settings)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, organization)
+	case errors.Is(err, ErrOrganizationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+	default:
+		h.logger.Error("service.UpdateOrganization failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not update organization"})
+	}
+}
+
+// DeleteOrganization godoc
+// @Summary      Delete Organization
+// @Description  Delete an organization and every membership in it
+// @Tags         organizations
+// @Param        id       path      int  true  "Organization ID"
+// @Success      204      "No Content"
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /organizations/{id} [delete]
+func (h *OrganizationHandler) DeleteOrganization(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	err := h.service.DeleteOrganization(c.Request.Context(), id)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrOrganizationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+	default:
+		h.logger.Error("service.DeleteOrganization failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete organization"})
+	}
+}
+
+// ListMembers godoc
+// @Summary      List Members
+// @Description  List the members of an organization with their roles in it
+// @Tags         organizations
+// @Produce      json
+// @Param        id       path      int  true  "Organization ID"
+// @Success      200      {array}   Member
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /organizations/{id}/members [get]
+func (h *OrganizationHandler) ListMembers(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	members, err := h.service.ListMembers(c.Request.Context(), id)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, members)
+	case errors.Is(err, ErrOrganizationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+	default:
+		h.logger.Error("service.ListMembers failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list members"})
+	}
+}
+
+// InviteMember godoc
+// @Summary      Invite Member
+// @Description  Invite an existing person to an organization; they become a member with the given roles once they accept. The response is the same whether or not the address is registered or already a member.
+// @Tags         organizations
+// @Accept       json
+// @Param        id       path      int                  true  "Organization ID"
+// @Param        payload  body      InviteMemberRequest  true  "Invitation payload"
+// @Success      202
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /organizations/{id}/members [post]
+func (h *OrganizationHandler) InviteMember(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	var req InviteMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid invite member payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "valid email required"})
+		return
+	}
+	err := h.service.InviteMember(c.Request.Context(), id, req.Email, req.Roles)
+	switch {
+	case err == nil:
+		c.Status(http.StatusAccepted)
+	case errors.Is(err, rbac.ErrUnknownRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrOrganizationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+	default:
+		h.logger.Error("service.InviteMember failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not invite member"})
+	}
+}
+
+// SetMemberRoles godoc
+// @Summary      Set Member Roles
+// @Description  Replace the roles of a member within an organization
+// @Tags         organizations
+// @Accept       json
+// @Produce      json
+// @Param        id         path      int                 true  "Organization ID"
+// @Param        person_id  path      int                 true  "Person ID"
+// @Param        payload    body      MemberRolesRequest  true  "Roles payload"
+// @Success      200        {object}  Member
+// @Failure      400        {object}  map[string]string
+// @Failure      404        {object}  map[string]string
+// @Failure      500        {object}  map[string]string
+// @Router       /organizations/{id}/members/{person_id} [put]
+func (h *OrganizationHandler) SetMemberRoles(c *gin.Context) {
+	uri, ok := h.bindMember(c)
+	if !ok {
+		return
+	}
+	var req MemberRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid member roles payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "roles required"})
+		return
+	}
+	member, err := h.service.SetMemberRoles(c.Request.Context(), uri.ID, uri.PersonID, req.Roles)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, member)
+	case errors.Is(err, rbac.ErrUnknownRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrMemberNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+	default:
+		h.logger.Error("service.SetMemberRoles failed", zap.Uint("id", uri.ID), zap.Uint("personID", uri.PersonID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not assign roles"})
+	}
+}
+
+// RemoveMember godoc
+// @Summary      Remove Member
+// @Description  End a person's membership of an organization, and the roles that came with it
+// @Tags         organizations
+// @Param        id         path      int  true  "Organization ID"
+// @Param        person_id  path      int  true  "Person ID"
+// @Success      204        "No Content"
+// @Failure      400        {object}  map[string]string
+// @Failure      404        {object}  map[string]string
+// @Failure      500        {object}  map[string]string
+// @Router       /organizations/{id}/members/{person_id} [delete]
+func (h *OrganizationHandler) RemoveMember(c *gin.Context) {
+	uri, ok := h.bindMember(c)
+	if !ok {
+		return
+	}
+	err := h.service.RemoveMember(c.Request.Context(), uri.ID, uri.PersonID)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrMemberNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+	default:
+		h.logger.Error("service.RemoveMember failed", zap.Uint("id", uri.ID), zap.Uint("personID", uri.PersonID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not remove member"})
+	}
+}
+
+// ReadCurrentOrganizations godoc
+// @Summary      List My Organizations
+// @Description  List the organizations the authenticated person is a member of
+// @Tags         organizations
+// @Produce      json
+// @Success      200      {array}   Organization
+// @Failure      401      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /persons/me/organizations [get]
+func (h *OrganizationHandler) ReadCurrentOrganizations(c *gin.Context) {
+	user, ok := currentPerson(c)
+	if !ok {
+		return
+	}
+	organizations, err := h.service.PersonOrganizations(c.Request.Context(), user.ID)
+	if err != nil {
+		h.logger.Error("service.PersonOrganizations failed", zap.Uint("personID", user.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list organizations"})
+		return
+	}
+	c.JSON(http.StatusOK, organizations)
+}
+
+// ReadCurrentInvitations godoc
+// @Summary      List My Invitations
+// @Description  List the invitations to organizations the authenticated person has not answered
+// @Tags         organizations
+// @Produce      json
+// @Success      200      {array}   Invitation
+// @Failure      401      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /persons/me/invitations [get]
+func (h *OrganizationHandler) ReadCurrentInvitations(c *gin.Context) {
+	user, ok := currentPerson(c)
+	if !ok {
+		return
+	}
+	invitations, err := h.service.PersonInvitations(c.Request.Context(), user.ID)
+	if err != nil {
+		h.logger.Error("service.PersonInvitations failed", zap.Uint("personID", user.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list invitations"})
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+// AcceptCurrentInvitation godoc
+// @Summary      Accept Invitation
+// @Description  Become a member of the organization that invited the authenticated person, with the roles of the invitation
+// @Tags         organizations
+// @Produce      json
+// @Param        id       path      int  true  "Invitation ID"
+// @Success      200      {object}  Member
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /persons/me/invitations/{id} [post]
+func (h *OrganizationHandler) AcceptCurrentInvitation(c *gin.Context) {
+	user, ok := currentPerson(c)
+	if !ok {
+		return
+	}
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	member, err := h.service.AcceptInvitation(c.Request.Context(), user.ID, id)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, member)
+	case errors.Is(err, ErrInvitationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+	case errors.Is(err, ErrMemberAlreadyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("service.AcceptInvitation failed", zap.Uint("personID", user.ID), zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not accept invitation"})
+	}
+}
+
+// DeclineCurrentInvitation godoc
+// @Summary      Decline Invitation
+// @Description  Decline an invitation of the authenticated person to an organization
+// @Tags         organizations
+// @Param        id       path      int  true  "Invitation ID"
+// @Success      204
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /persons/me/invitations/{id} [delete]
+func (h *OrganizationHandler) DeclineCurrentInvitation(c *gin.Context) {
+	user, ok := currentPerson(c)
+	if !ok {
+		return
+	}
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	err := h.service.DeclineInvitation(c.Request.Context(), user.ID, id)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrInvitationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+	default:
+		h.logger.Error("service.DeclineInvitation failed", zap.Uint("personID", user.ID), zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not decline invitation"})
+	}
+}
+
+// currentPerson returns the authenticated person, answering 401 if there
+// is none.
+func currentPerson(c *gin.Context) (*person.Person, bool) {
+	raw, exists := c.Get(person.ContextUserKey)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+	return raw.(*person.Person), true
+}
@@ -0,0 +1,67 @@
+package organization
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Organization is a tenant: persons are members of any number of them, and
+// have roles within each.
+// swagger:model OrganizationResponse
+// @Description organization model
+// @Property ID       body integer true "unique identifier"
+// @Property name     body string  true "display name"
+// @Property slug     body string  true "unique short name"
+// @Property settings body object  true "free-form settings of the organization"
+type Organization struct {
+	gorm.Model
+	Name string `json:"name" gorm:"not null"`
+	Slug string `json:"slug" gorm:"uniqueIndex;not null"`
+	// Settings are kept for the organization's applications and not
+	// interpreted by this service
+	Settings map[string]string `json:"settings" gorm:"serializer:json;type:jsonb;not null;default:'{}'"`
+}
+
+// Member makes a person a member of an organization.
+// swagger:model MemberResponse
+// @Description membership of a person in an organization
+// @Property organization_id body integer  true "organization ID"
+// @Property person_id       body integer  true "person ID"
+// @Property email           body string   true "email address of the person"
+// @Property roles           body []string true "names of the person's roles within the organization"
+type Member struct {
+	OrganizationID uint      `json:"organization_id" gorm:"primaryKey"`
+	PersonID       uint      `json:"person_id" gorm:"primaryKey;index"`
+	CreatedAt      time.Time `json:"created_at"`
+	// Email is read from persons when members are listed
+	Email string `json:"email" gorm:"->;-:migration"`
+	// Roles are filled in from the role assignments of the organization
+	Roles []string `json:"roles" gorm:"-"`
+}
+
+func (Member) TableName() string {
+	return "organization_members"
+}
+
+// Invitation offers a person membership of an organization, with roles,
+// until they accept or decline it.
+// swagger:model InvitationResponse
+// @Description pending invitation to an organization
+// @Property id                body integer  true "unique identifier"
+// @Property organization_id   body integer  true "organization ID"
+// @Property organization_name body string   true "display name of the organization"
+// @Property roles             body []string true "names of the roles the person gets within the organization"
+type Invitation struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	OrganizationID uint      `json:"organization_id" gorm:"uniqueIndex:idx_invitation_person;not null"`
+	PersonID       uint      `json:"-" gorm:"uniqueIndex:idx_invitation_person;index;not null"`
+	Roles          []string  `json:"roles" gorm:"serializer:json"`
+	CreatedAt      time.Time `json:"created_at"`
+	// OrganizationName is read from organizations when invitations are listed
+	OrganizationName string `json:"organization_name" gorm:"->;-:migration"`
+}
+
+func (Invitation) TableName() string {
+	return "organization_invitations"
+}
@@ -0,0 +1,275 @@
+package organization
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/tenant"
+)
+
+var (
+	ErrOrganizationNotFound      = errors.New("organization not found")
+	ErrOrganizationAlreadyExists = errors.New("organization already exists")
+	ErrOrganizationNotCreated    = errors.New("organization not created")
+	ErrMemberNotFound            = errors.New("member not found")
+	ErrMemberAlreadyExists       = errors.New("person is already a member")
+	ErrInvitationNotFound        = errors.New("invitation not found")
+	ErrUnresponsiveDatabase      = errors.New("error occurred during writing to organizations table")
+)
+
+// OrganizationRepository reads and writes organizations and their members.
+// Queries made in the context of an organization only see that one.
+type OrganizationRepository interface {
+	Create(ctx context.Context, organization *Organization) error
+	ReadByID(ctx context.Context, id uint) (*Organization, error)
+	ReadAll(ctx context.Context) ([]Organization, error)
+	// ReadByPersonID returns the organizations a person is a member of
+	ReadByPersonID(ctx context.Context, personID uint) ([]Organization, error)
+	Update(ctx context.Context, organization *Organization) error
+	// Delete removes an organization and its memberships
+	Delete(ctx context.Context, id uint) error
+	AddMember(ctx context.Context, member *Member) error
+	ReadMember(ctx context.Context, organizationID, personID uint) (*Member, error)
+	ReadMembers(ctx context.Context, organizationID uint) ([]Member, error)
+	RemoveMember(ctx context.Context, organizationID, personID uint) error
+	IsMember(ctx context.Context, organizationID, personID uint) (bool, error)
+	// Invite stores an invitation, replacing the roles of an earlier one
+	// to the same person
+	Invite(ctx context.Context, invitation *Invitation) error
+	// ReadInvitations returns the invitations of a person
+	ReadInvitations(ctx context.Context, personID uint) ([]Invitation, error)
+	// TakeInvitation removes an invitation of a person and returns it
+	TakeInvitation(ctx context.Context, personID, id uint) (*Invitation, error)
+}
+
+type organizationRepository struct {
+	db *gorm.DB
+}
+
+func NewOrganizationRepository(db *gorm.DB) OrganizationRepository {
+	return &organizationRepository{db: db}
+}
+
+func (r *organizationRepository) Create(ctx context.Context, organization *Organization) error {
+	err := r.db.WithContext(ctx).Create(organization).Error
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" &&
+			strings.Contains(pgErr.ConstraintName, "slug") {
+			return ErrOrganizationAlreadyExists
+		}
+		return ErrOrganizationNotCreated
+	}
+	return nil
+}
+
+func (r *organizationRepository) ReadByID(ctx context.Context, id uint) (*Organization, error) {
+	var organization Organization
+	err := r.db.WithContext(ctx).
+		Scopes(tenant.Organizations(ctx, "id")).
+		First(&organization, id).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &organization, nil
+}
+
+func (r *organizationRepository) ReadAll(ctx context.Context) ([]Organization, error) {
+	var organizations []Organization
+	err := r.db.WithContext(ctx).
+		Scopes(tenant.Organizations(ctx, "id")).
+		Order("slug").
+		Find(&organizations).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return organizations, nil
+}
+
+// ReadByPersonID is not limited to the organization in ctx: a person may
+// always see which organizations they can switch to.
+func (r *organizationRepository) ReadByPersonID(ctx context.Context, personID uint) ([]Organization, error) {
+	var organizations []Organization
+	err := r.db.WithContext(ctx).
+		Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.person_id = ?", personID).
+		Order("organizations.slug").
+		Find(&organizations).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return organizations, nil
+}
+
+func (r *organizationRepository) Update(ctx context.Context, organization *Organization) error {
+	res := r.db.WithContext(ctx).
+		Model(organization).
+		Scopes(tenant.Organizations(ctx, "id")).
+		Select("name", "settings").
+		Updates(organization)
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrOrganizationNotFound
+	}
+	return nil
+}
+
+func (r *organizationRepository) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.
+			Unscoped().
+			Scopes(tenant.Organizations(ctx, "id")).
+			Delete(&Organization{}, id)
+		if res.Error != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if res.RowsAffected == 0 {
+			return ErrOrganizationNotFound
+		}
+		if err := tx.Where("organization_id = ?", id).Delete(&Member{}).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if err := tx.Where("organization_id = ?", id).Delete(&Invitation{}).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		return nil
+	})
+}
+
+func (r *organizationRepository) AddMember(ctx context.Context, member *Member) error {
+	res := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(member)
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrMemberAlreadyExists
+	}
+	return nil
+}
+
+func (r *organizationRepository) ReadMember(ctx context.Context, organizationID, personID uint) (*Member, error) {
+	var member Member
+	err := r.members(ctx).
+		Where("organization_members.organization_id = ?", organizationID).
+		Where("organization_members.person_id = ?", personID).
+		First(&member).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMemberNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &member, nil
+}
+
+func (r *organizationRepository) ReadMembers(ctx context.Context, organizationID uint) ([]Member, error) {
+	var members []Member
+	err := r.members(ctx).
+		Where("organization_members.organization_id = ?", organizationID).
+		Order("persons.email").
+		Find(&members).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return members, nil
+}
+
+func (r *organizationRepository) RemoveMember(ctx context.Context, organizationID, personID uint) error {
+	res := r.db.WithContext(ctx).
+		Scopes(tenant.Organizations(ctx, "organization_id")).
+		Where("organization_id = ? AND person_id = ?", organizationID, personID).
+		Delete(&Member{})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+func (r *organizationRepository) IsMember(ctx context.Context, organizationID, personID uint) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&Member{}).
+		Where("organization_id = ? AND person_id = ?", organizationID, personID).
+		Count(&count).
+		Error
+	if err != nil {
+		return false, ErrUnresponsiveDatabase
+	}
+	return count > 0, nil
+}
+
+func (r *organizationRepository) Invite(ctx context.Context, invitation *Invitation) error {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "organization_id"}, {Name: "person_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"roles", "created_at"}),
+		}).
+		Create(invitation).
+		Error
+	if err != nil {
+		return ErrUnresponsiveDatabase
+	}
+	return nil
+}
+
+// ReadInvitations is not limited to the organization in ctx: a person sees
+// the invitations of every organization.
+func (r *organizationRepository) ReadInvitations(ctx context.Context, personID uint) ([]Invitation, error) {
+	var invitations []Invitation
+	err := r.db.WithContext(ctx).
+		Model(&Invitation{}).
+		Select("organization_invitations.*, organizations.name AS organization_name").
+		Joins("JOIN organizations ON organizations.id = organization_invitations.organization_id AND organizations.deleted_at IS NULL").
+		Where("organization_invitations.person_id = ?", personID).
+		Order("organization_invitations.created_at").
+		Find(&invitations).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return invitations, nil
+}
+
+func (r *organizationRepository) TakeInvitation(ctx context.Context, personID, id uint) (*Invitation, error) {
+	var invitation Invitation
+	res := r.db.WithContext(ctx).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND person_id = ?", id, personID).
+		Delete(&invitation)
+	if res.Error != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrInvitationNotFound
+	}
+	return &invitation, nil
+}
+
+// members selects memberships of live persons, with their email address.
+func (r *organizationRepository) members(ctx context.Context) *gorm.DB {
+	return r.db.WithContext(ctx).
+		Model(&Member{}).
+		Select("organization_members.*, persons.email").
+		Joins("JOIN persons ON persons.id = organization_members.person_id AND persons.deleted_at IS NULL").
+		Scopes(tenant.Organizations(ctx, "organization_members.organization_id"))
+}
@@ -0,0 +1,258 @@
+package organization
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+	"github.com/mehmetcc/definitive-authentication-service/internal/rbac"
+	"github.com/mehmetcc/definitive-authentication-service/internal/tenant"
+)
+
+var ErrInvalidSlug = errors.New("slugs are 1 to 64 lowercase letters, digits, '-' or '_'")
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+type OrganizationService interface {
+	CreateOrganization(ctx context.Context, name, slug string, settings map[string]string) (*Organization, error)
+	ListOrganizations(ctx context.Context) ([]Organization, error)
+	ReadOrganization(ctx context.Context, id uint) (*Organization, error)
+	// UpdateOrganization replaces the name and settings of an organization
+	UpdateOrganization(ctx context.Context, id uint, name string, settings map[string]string) (*Organization, error)
+	DeleteOrganization(ctx context.Context, id uint) error
+	PersonOrganizations(ctx context.Context, personID uint) ([]Organization, error)
+	ListMembers(ctx context.Context, organizationID uint) ([]Member, error)
+	// InviteMember invites the person with the given email address to
+	// become a member with the given roles. It answers alike whether or
+	// not such a person exists or is a member already, so that nothing is
+	// learned about persons outside the organization.
+	InviteMember(ctx context.Context, organizationID uint, email string, roles []string) error
+	// PersonInvitations returns the invitations a person has not answered
+	PersonInvitations(ctx context.Context, personID uint) ([]Invitation, error)
+	// AcceptInvitation makes a person a member of the organization that
+	// invited them, with the roles of the invitation
+	AcceptInvitation(ctx context.Context, personID, invitationID uint) (*Member, error)
+	DeclineInvitation(ctx context.Context, personID, invitationID uint) error
+	SetMemberRoles(ctx context.Context, organizationID, personID uint, roles []string) (*Member, error)
+	// RemoveMember ends a membership and the roles that came with it
+	RemoveMember(ctx context.Context, organizationID, personID uint) error
+	IsMember(ctx context.Context, organizationID, personID uint) (bool, error)
+}
+
+type organizationService struct {
+	repo          OrganizationRepository
+	personService person.PersonService
+	rbacService   rbac.RBACService
+	logger        *zap.Logger
+}
+
+func NewOrganizationService(
+	repo OrganizationRepository,
+	personService person.PersonService,
+	rbacService rbac.RBACService,
+	logger *zap.Logger,
+) OrganizationService {
+	return &organizationService{
+		repo:          repo,
+		personService: personService,
+		rbacService:   rbacService,
+		logger:        logger,
+	}
+}
+
+func (s *organizationService) CreateOrganization(ctx context.Context, name, slug string, settings map[string]string) (*Organization, error) {
+	if !slugPattern.MatchString(slug) {
+		return nil, ErrInvalidSlug
+	}
+	if settings == nil {
+		settings = map[string]string{}
+	}
+	organization := &Organization{Name: name, Slug: slug, Settings: settings}
+	if err := s.repo.Create(ctx, organization); err != nil {
+		s.logger.Error("failed to create organization", zap.String("slug", slug), zap.Error(err))
+		return nil, err
+	}
+	return organization, nil
+}
+
+func (s *organizationService) ListOrganizations(ctx context.Context) ([]Organization, error) {
+	return s.repo.ReadAll(ctx)
+}
+
+func (s *organizationService) ReadOrganization(ctx context.Context, id uint) (*Organization, error) {
+	return s.repo.ReadByID(ctx, id)
+}
+
+func (s *organizationService) UpdateOrganization(ctx context.Context, id uint, name string, settings map[string]string) (*Organization, error) {
+	organization, err := s.repo.ReadByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		settings = map[string]string{}
+	}
+	organization.Name = name
+	organization.Settings = settings
+	if err := s.repo.Update(ctx, organization); err != nil {
+		s.logger.Error("failed to update organization", zap.Uint("id", id), zap.Error(err))
+		return nil, err
+	}
+	return organization, nil
+}
+
+func (s *organizationService) DeleteOrganization(ctx context.Context, id uint) error {
+	if err := s.repo.Delete(ctx, id); err != nil {
+		s.logger.Error("failed to delete organization", zap.Uint("id", id), zap.Error(err))
+		return err
+	}
+	return s.rbacService.RemoveOrganizationRoles(ctx, id, 0)
+}
+
+func (s *organizationService) PersonOrganizations(ctx context.Context, personID uint) ([]Organization, error) {
+	return s.repo.ReadByPersonID(ctx, personID)
+}
+
+func (s *organizationService) ListMembers(ctx context.Context, organizationID uint) ([]Member, error) {
+	if _, err := s.repo.ReadByID(ctx, organizationID); err != nil {
+		return nil, err
+	}
+	members, err := s.repo.ReadMembers(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range members {
+		if err := s.loadRoles(ctx, &members[i]); err != nil {
+			return nil, err
+		}
+	}
+	return members, nil
+}
+
+func (s *organizationService) InviteMember(ctx context.Context, organizationID uint, email string, roles []string) error {
+	if _, err := s.repo.ReadByID(ctx, organizationID); err != nil {
+		return err
+	}
+	if err := s.checkRoles(ctx, roles); err != nil {
+		return err
+	}
+	// the person is not a member of the organization yet; whether they
+	// were found is never told to the caller
+	user, err := s.personService.ReadPersonByEmail(tenant.WithoutOrganization(ctx), email)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	member, err := s.repo.IsMember(ctx, organizationID, user.ID)
+	if err != nil || member {
+		return err
+	}
+
+	invitation := &Invitation{OrganizationID: organizationID, PersonID: user.ID, Roles: roles}
+	if err := s.repo.Invite(ctx, invitation); err != nil {
+		s.logger.Error("failed to invite member",
+			zap.Uint("organizationID", organizationID), zap.Uint("personID", user.ID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *organizationService) PersonInvitations(ctx context.Context, personID uint) ([]Invitation, error) {
+	return s.repo.ReadInvitations(ctx, personID)
+}
+
+func (s *organizationService) AcceptInvitation(ctx context.Context, personID, invitationID uint) (*Member, error) {
+	// the person answers from whichever organization they act in
+	ctx = tenant.WithoutOrganization(ctx)
+	invitation, err := s.repo.TakeInvitation(ctx, personID, invitationID)
+	if err != nil {
+		return nil, err
+	}
+
+	member := &Member{OrganizationID: invitation.OrganizationID, PersonID: personID}
+	if err := s.repo.AddMember(ctx, member); err != nil {
+		s.logger.Error("failed to add member",
+			zap.Uint("organizationID", invitation.OrganizationID), zap.Uint("personID", personID), zap.Error(err))
+		return nil, err
+	}
+	if len(invitation.Roles) > 0 {
+		if _, err := s.rbacService.SetOrganizationRoles(ctx, invitation.OrganizationID, personID, invitation.Roles); err != nil {
+			return nil, err
+		}
+	}
+	return s.member(ctx, invitation.OrganizationID, personID)
+}
+
+func (s *organizationService) DeclineInvitation(ctx context.Context, personID, invitationID uint) error {
+	_, err := s.repo.TakeInvitation(ctx, personID, invitationID)
+	return err
+}
+
+func (s *organizationService) SetMemberRoles(ctx context.Context, organizationID, personID uint, roles []string) (*Member, error) {
+	if _, err := s.repo.ReadMember(ctx, organizationID, personID); err != nil {
+		return nil, err
+	}
+	if _, err := s.rbacService.SetOrganizationRoles(ctx, organizationID, personID, roles); err != nil {
+		return nil, err
+	}
+	return s.member(ctx, organizationID, personID)
+}
+
+func (s *organizationService) RemoveMember(ctx context.Context, organizationID, personID uint) error {
+	if err := s.repo.RemoveMember(ctx, organizationID, personID); err != nil {
+		return err
+	}
+	// tokens issued for the organization stop being accepted along with
+	// the membership
+	return s.rbacService.RemoveOrganizationRoles(ctx, organizationID, personID)
+}
+
+func (s *organizationService) IsMember(ctx context.Context, organizationID, personID uint) (bool, error) {
+	return s.repo.IsMember(ctx, organizationID, personID)
+}
+
+func (s *organizationService) member(ctx context.Context, organizationID, personID uint) (*Member, error) {
+	member, err := s.repo.ReadMember(ctx, organizationID, personID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadRoles(ctx, member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// checkRoles fails with rbac.ErrUnknownRole unless every role in names
+// exists.
+func (s *organizationService) checkRoles(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	roles, err := s.rbacService.ListRoles(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(roles, func(role rbac.Role) bool { return role.Name == name }) {
+			return rbac.ErrUnknownRole
+		}
+	}
+	return nil
+}
+
+func (s *organizationService) loadRoles(ctx context.Context, member *Member) error {
+	roles, err := s.rbacService.OrganizationRoles(ctx, member.OrganizationID, member.PersonID)
+	if err != nil {
+		return err
+	}
+	member.Roles = make([]string, 0, len(roles))
+	for _, role := range roles {
+		member.Roles = append(member.Roles, role.Name)
+	}
+	return nil
+}
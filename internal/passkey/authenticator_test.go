@@ -0,0 +1,340 @@
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/security"
+)
+
+const (
+	testRPID   = "auth.example.com"
+	testOrigin = "https://auth.example.com"
+)
+
+// softAuthenticator is a software WebAuthn authenticator holding a single
+// credential, answering ceremonies the way a browser would pass them on.
+type softAuthenticator struct {
+	alg          int
+	signer       crypto.Signer
+	credentialID []byte
+	signCount    uint32
+	// rpID is hashed into the authenticator data; a phishing page would
+	// present its own
+	rpID string
+	// flags are set in the authenticator data of every response
+	flags byte
+}
+
+func newSoftAuthenticator(t *testing.T, alg int) *softAuthenticator {
+	t.Helper()
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case AlgES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported algorithm %d", alg)
+	}
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatalf("generating credential id: %v", err)
+	}
+	return &softAuthenticator{
+		alg:          alg,
+		signer:       signer,
+		credentialID: credentialID,
+		rpID:         testRPID,
+		flags:        flagUserPresent | flagUserVerified,
+	}
+}
+
+// coseKey encodes the credential public key as a COSE_Key.
+func (a *softAuthenticator) coseKey() []byte {
+	switch key := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		return encodeCBOR(cborMap{
+			{int64(1), int64(2)},
+			{int64(3), int64(AlgES256)},
+			{int64(-1), int64(1)},
+			{int64(-2), key.X.FillBytes(make([]byte, 32))},
+			{int64(-3), key.Y.FillBytes(make([]byte, 32))},
+		})
+	case ed25519.PublicKey:
+		return encodeCBOR(cborMap{
+			{int64(1), int64(1)},
+			{int64(3), int64(AlgEdDSA)},
+			{int64(-1), int64(6)},
+			{int64(-2), []byte(key)},
+		})
+	}
+	return nil
+}
+
+// authenticatorData builds authenticator data, with the attested
+// credential when attested is set.
+func (a *softAuthenticator) authenticatorData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := a.flags
+	if attested {
+		flags |= flagAttested
+	}
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // aaguid
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+// sign signs authenticator data followed by the client data hash.
+func (a *softAuthenticator) sign(t *testing.T, authData, clientDataJSON []byte) []byte {
+	t.Helper()
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	var signature []byte
+	var err error
+	switch a.alg {
+	case AlgES256:
+		digest := sha256.Sum256(signed)
+		signature, err = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case AlgEdDSA:
+		signature, err = a.signer.Sign(rand.Reader, signed, crypto.Hash(0))
+	}
+	if err != nil {
+		t.Fatalf("signing: %v", err)
+	}
+	return signature
+}
+
+// register answers BeginRegistration.
+func (a *softAuthenticator) register(t *testing.T, options *CreationOptions) *AttestationResponse {
+	t.Helper()
+	clientDataJSON := collectClientData(t, clientDataCreate, options.Challenge)
+	attestationObject := encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", a.authenticatorData(true)},
+	})
+
+	resp := &AttestationResponse{
+		ID:    encodeBase64URL(a.credentialID),
+		RawID: encodeBase64URL(a.credentialID),
+		Type:  "public-key",
+	}
+	resp.Response.ClientDataJSON = encodeBase64URL(clientDataJSON)
+	resp.Response.AttestationObject = encodeBase64URL(attestationObject)
+	return resp
+}
+
+// assert answers BeginLogin, counting the signature like hardware does.
+func (a *softAuthenticator) assert(t *testing.T, options *RequestOptions, personID uint) *AssertionResponse {
+	t.Helper()
+	a.signCount++
+	clientDataJSON := collectClientData(t, clientDataGet, options.Challenge)
+	authData := a.authenticatorData(false)
+
+	resp := &AssertionResponse{
+		ID:    encodeBase64URL(a.credentialID),
+		RawID: encodeBase64URL(a.credentialID),
+		Type:  "public-key",
+	}
+	resp.Response.ClientDataJSON = encodeBase64URL(clientDataJSON)
+	resp.Response.AuthenticatorData = encodeBase64URL(authData)
+	resp.Response.Signature = encodeBase64URL(a.sign(t, authData, clientDataJSON))
+	resp.Response.UserHandle = encodeBase64URL(userHandle(personID))
+	return resp
+}
+
+func collectClientData(t *testing.T, ceremonyType, challenge string) []byte {
+	t.Helper()
+	raw, err := json.Marshal(clientData{Type: ceremonyType, Challenge: challenge, Origin: testOrigin})
+	if err != nil {
+		t.Fatalf("encoding client data: %v", err)
+	}
+	return raw
+}
+
+// cborMap is a CBOR map whose entries are encoded in order.
+type cborMap [][2]interface{}
+
+// encodeCBOR encodes the subset of CBOR that decodeCBOR reads back.
+func encodeCBOR(value interface{}) []byte {
+	switch v := value.(type) {
+	case int64:
+		if v < 0 {
+			return cborHeader(1, uint64(-1-v))
+		}
+		return cborHeader(0, uint64(v))
+	case []byte:
+		return append(cborHeader(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHeader(3, uint64(len(v))), v...)
+	case cborMap:
+		out := cborHeader(5, uint64(len(v)))
+		for _, entry := range v {
+			out = append(out, encodeCBOR(entry[0])...)
+			out = append(out, encodeCBOR(entry[1])...)
+		}
+		return out
+	}
+	panic("unsupported cbor value")
+}
+
+func cborHeader(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	}
+	return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+}
+
+// memoryCredentials is a CredentialRepository kept in memory.
+type memoryCredentials struct {
+	mu          sync.Mutex
+	credentials []*Credential
+}
+
+func (r *memoryCredentials) Create(_ context.Context, credential *Credential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.credentials {
+		if bytes.Equal(existing.CredentialID, credential.CredentialID) {
+			return ErrCredentialAlreadyExists
+		}
+	}
+	credential.ID = uint(len(r.credentials) + 1)
+	stored := *credential
+	r.credentials = append(r.credentials, &stored)
+	return nil
+}
+
+func (r *memoryCredentials) ReadByCredentialID(_ context.Context, credentialID []byte) (*Credential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, credential := range r.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			found := *credential
+			return &found, nil
+		}
+	}
+	return nil, ErrCredentialNotFound
+}
+
+func (r *memoryCredentials) ReadByPersonID(_ context.Context, personID uint) ([]Credential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []Credential
+	for _, credential := range r.credentials {
+		if credential.PersonID == personID {
+			found = append(found, *credential)
+		}
+	}
+	return found, nil
+}
+
+func (r *memoryCredentials) UpdateSignCount(_ context.Context, id uint, oldCount, newCount uint32, usedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, credential := range r.credentials {
+		if credential.ID != id {
+			continue
+		}
+		if credential.SignCount != oldCount {
+			return ErrSignCountChanged
+		}
+		credential.SignCount = newCount
+		credential.LastUsedAt = &usedAt
+		return nil
+	}
+	return ErrCredentialNotFound
+}
+
+func (r *memoryCredentials) Delete(_ context.Context, personID, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, credential := range r.credentials {
+		if credential.ID == id && credential.PersonID == personID {
+			r.credentials = append(r.credentials[:i], r.credentials[i+1:]...)
+			return nil
+		}
+	}
+	return ErrCredentialNotFound
+}
+
+// memoryCeremonies is a CeremonyRepository kept in memory.
+type memoryCeremonies struct {
+	mu         sync.Mutex
+	ceremonies map[string]Ceremony
+}
+
+func (r *memoryCeremonies) Create(_ context.Context, ceremony *Ceremony) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ceremonies == nil {
+		r.ceremonies = make(map[string]Ceremony)
+	}
+	r.ceremonies[ceremony.Challenge] = *ceremony
+	return nil
+}
+
+func (r *memoryCeremonies) Consume(_ context.Context, challenge string) (*Ceremony, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ceremony, ok := r.ceremonies[challenge]
+	if !ok {
+		return nil, ErrCeremonyNotFound
+	}
+	delete(r.ceremonies, challenge)
+	return &ceremony, nil
+}
+
+func (r *memoryCeremonies) DeleteExpired(_ context.Context, now time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for challenge, ceremony := range r.ceremonies {
+		if !now.Before(ceremony.ExpiresAt) {
+			delete(r.ceremonies, challenge)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// recordedEvents is an EventPublisher that keeps what was published.
+type recordedEvents struct {
+	mu     sync.Mutex
+	events []security.Event
+}
+
+func (p *recordedEvents) Publish(_ context.Context, event security.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+}
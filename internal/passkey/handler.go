@@ -0,0 +1,170 @@
+package passkey
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+)
+
+// FinishRegistrationRequest carries the browser's new credential.
+// @Description credential from navigator.credentials.create, serialized with toJSON()
+// @Property credential body object true  "PublicKeyCredential JSON"
+// @Property name       body string false "name to recognize the passkey by"
+type FinishRegistrationRequest struct {
+	Credential AttestationResponse `json:"credential" binding:"required"`
+	Name       string              `json:"name" binding:"max=64"`
+}
+
+// CredentialIDRequest represents a URI passkey id parameter.
+type CredentialIDRequest struct {
+	ID uint `uri:"id" binding:"required,min=1"`
+}
+
+// PasskeyHandler handles passkey management for the authenticated person.
+type PasskeyHandler struct {
+	router  *gin.RouterGroup
+	service PasskeyService
+	logger  *zap.Logger
+}
+
+// NewPasskeyHandler registers passkey endpoints on the given router group,
+// which must require an authenticated person.
+func NewPasskeyHandler(router *gin.RouterGroup, service PasskeyService, logger *zap.Logger) *PasskeyHandler {
+	h := &PasskeyHandler{router: router, service: service, logger: logger}
+	h.router.POST("/passkeys/register/begin", h.BeginRegistration)
+	h.router.POST("/passkeys/register/finish", h.FinishRegistration)
+	h.router.GET("/passkeys", h.ListPasskeys)
+	h.router.DELETE("/passkeys/:id", h.DeletePasskey)
+	return h
+}
+
+func (h *PasskeyHandler) currentPerson(c *gin.Context) (*person.Person, bool) {
+	raw, exists := c.Get(person.ContextUserKey)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+	return raw.(*person.Person), true
+}
+
+// BeginRegistration godoc
+// @Summary      Begin Passkey Registration
+// @Description  Get the options for navigator.credentials.create to add a passkey to the current person
+// @Tags         passkeys
+// @Produce      json
+// @Success      200      {object}  CreationOptions
+// @Failure      401      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /passkeys/register/begin [post]
+func (h *PasskeyHandler) BeginRegistration(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	options, err := h.service.BeginRegistration(c.Request.Context(), user)
+	if err != nil {
+		h.logger.Error("BeginRegistration service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not start passkey registration"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, options)
+}
+
+// FinishRegistration godoc
+// @Summary      Finish Passkey Registration
+// @Description  Verify and store the credential created by the browser
+// @Tags         passkeys
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      FinishRegistrationRequest  true  "New credential"
+// @Success      201      {object}  Credential
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /passkeys/register/finish [post]
+func (h *PasskeyHandler) FinishRegistration(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	var req FinishRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid passkey registration payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "credential required"})
+		return
+	}
+	credential, err := h.service.FinishRegistration(c.Request.Context(), user, &req.Credential, req.Name)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, credential)
+	case errors.Is(err, ErrInvalidCredential):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "passkey could not be verified"})
+	case errors.Is(err, ErrCredentialAlreadyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "passkey already registered"})
+	default:
+		h.logger.Error("FinishRegistration service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not register passkey"})
+	}
+}
+
+// ListPasskeys godoc
+// @Summary      List Passkeys
+// @Description  List the passkeys of the current person
+// @Tags         passkeys
+// @Produce      json
+// @Success      200      {array}   Credential
+// @Failure      401      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /passkeys [get]
+func (h *PasskeyHandler) ListPasskeys(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	credentials, err := h.service.ListCredentials(c.Request.Context(), user.ID)
+	if err != nil {
+		h.logger.Error("ListCredentials service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list passkeys"})
+		return
+	}
+	c.JSON(http.StatusOK, credentials)
+}
+
+// DeletePasskey godoc
+// @Summary      Delete Passkey
+// @Description  Remove one of the current person's passkeys
+// @Tags         passkeys
+// @Param        id   path      int  true  "Passkey ID"
+// @Success      204
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /passkeys/{id} [delete]
+func (h *PasskeyHandler) DeletePasskey(c *gin.Context) {
+	user, ok := h.currentPerson(c)
+	if !ok {
+		return
+	}
+	var uri CredentialIDRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id"})
+		return
+	}
+	err := h.service.DeleteCredential(c.Request.Context(), user.ID, uri.ID)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrCredentialNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "passkey not found"})
+	default:
+		h.logger.Error("DeleteCredential service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete passkey"})
+	}
+}
@@ -0,0 +1,53 @@
+package passkey
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Credential is a WebAuthn public key credential (passkey or security key)
+// registered by a person.
+// swagger:model PasskeyResponse
+// @Description registered passkey
+// @Property ID           body integer  true  "unique identifier"
+// @Property name         body string   true  "name given at registration"
+// @Property transports   body []string false "how the authenticator is reached"
+// @Property last_used_at body string   false "last successful login"
+type Credential struct {
+	gorm.Model
+	PersonID uint `json:"-" gorm:"index;not null"`
+	// CredentialID is the authenticator's credential id
+	CredentialID []byte `json:"-" gorm:"uniqueIndex;not null"`
+	// PublicKey is the COSE encoded credential public key
+	PublicKey []byte `json:"-" gorm:"not null"`
+	Algorithm int    `json:"-" gorm:"not null"`
+	// SignCount is the last signature counter seen, used to detect clones
+	SignCount  uint32     `json:"-" gorm:"not null;default:0"`
+	Transports []string   `json:"transports" gorm:"serializer:json"`
+	Name       string     `json:"name" gorm:"not null;default:''"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// CeremonyKind is what a WebAuthn challenge was issued for.
+type CeremonyKind string
+
+const (
+	CeremonyRegistration CeremonyKind = "registration"
+	// CeremonyLogin is a passwordless login by a discoverable credential
+	CeremonyLogin CeremonyKind = "login"
+	// CeremonySecondFactor follows a successful password step
+	CeremonySecondFactor CeremonyKind = "second_factor"
+)
+
+// Ceremony is a pending WebAuthn challenge, found again by the challenge
+// the browser echoes in its client data.
+type Ceremony struct {
+	gorm.Model
+	// Challenge is the SHA-256 hash of the challenge sent to the browser
+	Challenge string       `gorm:"uniqueIndex;not null"`
+	Kind      CeremonyKind `gorm:"type:text;not null"`
+	// PersonID is set unless Kind is CeremonyLogin
+	PersonID  *uint
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
@@ -0,0 +1,335 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// COSE algorithm identifiers accepted for credentials
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// authenticator data flags, WebAuthn section 6.1
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+const (
+	clientDataCreate = "webauthn.create"
+	clientDataGet    = "webauthn.get"
+)
+
+var (
+	ErrMalformedResponse    = errors.New("malformed webauthn response")
+	ErrUnsupportedAlgorithm = errors.New("unsupported credential algorithm")
+	ErrInvalidSignature     = errors.New("invalid webauthn signature")
+)
+
+// CredentialParameter names an acceptable credential algorithm.
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor identifies a credential to the browser.
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// RelyingParty identifies this service to authenticators.
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity identifies the person a credential is created for.
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// AuthenticatorSelection states what kind of authenticator is wanted.
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the PublicKeyCredentialCreationOptions passed to
+// navigator.credentials.create, with binary fields base64url encoded.
+type CreationOptions struct {
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+}
+
+// RequestOptions are the PublicKeyCredentialRequestOptions passed to
+// navigator.credentials.get, with binary fields base64url encoded.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse is a PublicKeyCredential from
+// navigator.credentials.create, serialized with toJSON().
+type AttestationResponse struct {
+	ID       string `json:"id" binding:"required"`
+	RawID    string `json:"rawId" binding:"required"`
+	Type     string `json:"type" binding:"required"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON" binding:"required"`
+		AttestationObject string   `json:"attestationObject" binding:"required"`
+		Transports        []string `json:"transports"`
+	} `json:"response" binding:"required"`
+}
+
+// AssertionResponse is a PublicKeyCredential from
+// navigator.credentials.get, serialized with toJSON().
+type AssertionResponse struct {
+	ID       string `json:"id" binding:"required"`
+	RawID    string `json:"rawId" binding:"required"`
+	Type     string `json:"type" binding:"required"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+		AuthenticatorData string `json:"authenticatorData" binding:"required"`
+		Signature         string `json:"signature" binding:"required"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response" binding:"required"`
+}
+
+// clientData is the part of CollectedClientData that is checked.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData is parsed authenticator data, WebAuthn section 6.1.
+type authenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// set when flagAttested is present
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// decodeBase64URL accepts base64url with or without padding, as browsers
+// and libraries differ.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseClientData decodes clientDataJSON and checks its type and origin.
+// It returns the raw JSON too, whose hash is part of what gets signed.
+func parseClientData(encoded, wantType string, origins []string) (*clientData, []byte, error) {
+	raw, err := decodeBase64URL(encoded)
+	if err != nil {
+		return nil, nil, ErrMalformedResponse
+	}
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, nil, ErrMalformedResponse
+	}
+	if data.Type != wantType {
+		return nil, nil, ErrMalformedResponse
+	}
+	for _, origin := range origins {
+		if data.Origin == origin {
+			return &data, raw, nil
+		}
+	}
+	return nil, nil, ErrMalformedResponse
+}
+
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, ErrMalformedResponse
+	}
+	data := &authenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if data.Flags&flagAttested == 0 {
+		return data, nil
+	}
+
+	rest := raw[37:]
+	// aaguid (16) and credential id length (2)
+	if len(rest) < 18 {
+		return nil, ErrMalformedResponse
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, ErrMalformedResponse
+	}
+	data.CredentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, ErrMalformedResponse
+	}
+	data.PublicKey = rest[:len(rest)-len(after)]
+	return data, nil
+}
+
+// check verifies the RP ID hash and the user presence and, when
+// required, verification flags.
+func (d *authenticatorData) check(rpID string, requireVerification bool) error {
+	want := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(d.RPIDHash, want[:]) {
+		return ErrMalformedResponse
+	}
+	if d.Flags&flagUserPresent == 0 {
+		return ErrMalformedResponse
+	}
+	if requireVerification && d.Flags&flagUserVerified == 0 {
+		return ErrMalformedResponse
+	}
+	return nil
+}
+
+// parseAttestationObject returns the authenticator data of an attestation
+// object. The attestation statement is not verified: registration asks
+// for "none" attestation since no authenticator models are trusted or
+// banned, so any format is treated as self-asserted.
+func parseAttestationObject(encoded string) (*authenticatorData, error) {
+	raw, err := decodeBase64URL(encoded)
+	if err != nil {
+		return nil, ErrMalformedResponse
+	}
+	value, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, ErrMalformedResponse
+	}
+	object, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrMalformedResponse
+	}
+	authData, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, ErrMalformedResponse
+	}
+	data, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if data.Flags&flagAttested == 0 || len(data.CredentialID) == 0 {
+		return nil, ErrMalformedResponse
+	}
+	return data, nil
+}
+
+// parseCOSEKey decodes a COSE_Key (RFC 9053) into a public key and its
+// algorithm.
+func parseCOSEKey(raw []byte) (crypto.PublicKey, int, error) {
+	value, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, 0, ErrMalformedResponse
+	}
+	key, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, ErrMalformedResponse
+	}
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrMalformedResponse
+		}
+		// ecdh rejects points that are not on the curve
+		point := append(append([]byte{0x04}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, 0, ErrMalformedResponse
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, AlgES256, nil
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrMalformedResponse
+		}
+		return ed25519.PublicKey(x), AlgEdDSA, nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrMalformedResponse
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, AlgRS256, nil
+	}
+	return nil, 0, ErrUnsupportedAlgorithm
+}
+
+// verifySignature checks an assertion signature over authenticator data
+// followed by the hash of the client data.
+func verifySignature(coseKey, authData, clientDataJSON, signature []byte) error {
+	publicKey, alg, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	var valid bool
+	switch alg {
+	case AlgES256:
+		digest := sha256.Sum256(signed)
+		valid = ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), digest[:], signature)
+	case AlgEdDSA:
+		valid = ed25519.Verify(publicKey.(ed25519.PublicKey), signed, signature)
+	case AlgRS256:
+		digest := sha256.Sum256(signed)
+		valid = rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+func TestParseAuthenticatorData(t *testing.T) {
+	for _, tc := range algorithms {
+		t.Run(tc.name, func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t, tc.alg)
+			authenticator.signCount = 7
+
+			data, err := parseAuthenticatorData(authenticator.authenticatorData(true))
+			if err != nil {
+				t.Fatalf("parseAuthenticatorData: %v", err)
+			}
+			if data.SignCount != 7 || data.Flags&flagAttested == 0 {
+				t.Fatalf("parsed sign count %d and flags %#x", data.SignCount, data.Flags)
+			}
+			if !bytes.Equal(data.CredentialID, authenticator.credentialID) {
+				t.Fatalf("parsed credential id %x, want %x", data.CredentialID, authenticator.credentialID)
+			}
+			if !bytes.Equal(data.PublicKey, authenticator.coseKey()) {
+				t.Fatalf("parsed public key %x, want %x", data.PublicKey, authenticator.coseKey())
+			}
+			if err := data.check(testRPID, true); err != nil {
+				t.Fatalf("check: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseAuthenticatorDataRejectsTruncatedData(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, AlgES256)
+	full := authenticator.authenticatorData(true)
+
+	// cut inside the header, the credential id and the public key
+	for _, length := range []int{0, 36, 40, 37 + 18 + len(authenticator.credentialID) - 1, len(full) - 1} {
+		if _, err := parseAuthenticatorData(full[:length]); !errors.Is(err, ErrMalformedResponse) {
+			t.Errorf("%d bytes: got %v, want ErrMalformedResponse", length, err)
+		}
+	}
+}
+
+func TestAuthenticatorDataCheck(t *testing.T) {
+	tests := []struct {
+		name                string
+		rpID                string
+		flags               byte
+		requireVerification bool
+		want                error
+	}{
+		{"present and verified", testRPID, flagUserPresent | flagUserVerified, true, nil},
+		{"present only, verification not required", testRPID, flagUserPresent, false, nil},
+		{"wrong rp id hash", "evil.example.com", flagUserPresent | flagUserVerified, false, ErrMalformedResponse},
+		{"user not present", testRPID, flagUserVerified, false, ErrMalformedResponse},
+		{"user not verified", testRPID, flagUserPresent, true, ErrMalformedResponse},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t, AlgES256)
+			authenticator.rpID = tc.rpID
+			authenticator.flags = tc.flags
+
+			data, err := parseAuthenticatorData(authenticator.authenticatorData(false))
+			if err != nil {
+				t.Fatalf("parseAuthenticatorData: %v", err)
+			}
+			if err := data.check(testRPID, tc.requireVerification); !errors.Is(err, tc.want) {
+				t.Fatalf("check: got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseCOSEKey(t *testing.T) {
+	for _, tc := range algorithms {
+		t.Run(tc.name, func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t, tc.alg)
+
+			publicKey, alg, err := parseCOSEKey(authenticator.coseKey())
+			if err != nil {
+				t.Fatalf("parseCOSEKey: %v", err)
+			}
+			if alg != tc.alg {
+				t.Fatalf("parsed algorithm %d, want %d", alg, tc.alg)
+			}
+			equal, ok := authenticator.signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+			if !ok || !equal.Equal(publicKey) {
+				t.Fatalf("parsed key does not match the authenticator's")
+			}
+		})
+	}
+}
+
+func TestParseCOSEKeyRejectsPointOffCurve(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, AlgES256)
+	key := authenticator.signer.Public().(*ecdsa.PublicKey)
+	y := key.Y.FillBytes(make([]byte, 32))
+	y[31] ^= 0x01
+
+	raw := encodeCBOR(cborMap{
+		{int64(1), int64(2)},
+		{int64(3), int64(AlgES256)},
+		{int64(-1), int64(1)},
+		{int64(-2), key.X.FillBytes(make([]byte, 32))},
+		{int64(-3), y},
+	})
+	if _, _, err := parseCOSEKey(raw); !errors.Is(err, ErrMalformedResponse) {
+		t.Fatalf("got %v, want ErrMalformedResponse", err)
+	}
+}
+
+func TestParseCOSEKeyRejectsUnsupportedAlgorithm(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, AlgEdDSA)
+	raw := encodeCBOR(cborMap{
+		{int64(1), int64(1)},
+		{int64(3), int64(-19)}, // Ed25519 under its fully specified identifier
+		{int64(-1), int64(6)},
+		{int64(-2), []byte(authenticator.signer.Public().(ed25519.PublicKey))},
+	})
+	if _, _, err := parseCOSEKey(raw); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("got %v, want ErrUnsupportedAlgorithm", err)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	for _, tc := range algorithms {
+		t.Run(tc.name, func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t, tc.alg)
+			authData := authenticator.authenticatorData(false)
+			clientDataJSON := collectClientData(t, clientDataGet, "challenge")
+			signature := authenticator.sign(t, authData, clientDataJSON)
+
+			if err := verifySignature(authenticator.coseKey(), authData, clientDataJSON, signature); err != nil {
+				t.Fatalf("verifySignature: %v", err)
+			}
+
+			tampered := append([]byte(nil), authData...)
+			tampered[32] ^= flagUserVerified
+			if err := verifySignature(authenticator.coseKey(), tampered, clientDataJSON, signature); !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("tampered authenticator data: got %v, want ErrInvalidSignature", err)
+			}
+			otherClientData := collectClientData(t, clientDataGet, "other challenge")
+			if err := verifySignature(authenticator.coseKey(), authData, otherClientData, signature); !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("other client data: got %v, want ErrInvalidSignature", err)
+			}
+			other := newSoftAuthenticator(t, tc.alg)
+			if err := verifySignature(other.coseKey(), authData, clientDataJSON, signature); !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("other key: got %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
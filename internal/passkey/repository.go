@@ -0,0 +1,169 @@
+package passkey
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCredentialNotFound      = errors.New("credential not found")
+	ErrCredentialNotCreated    = errors.New("credential not created")
+	ErrCredentialAlreadyExists = errors.New("credential already registered")
+	ErrSignCountChanged        = errors.New("credential used concurrently")
+	ErrCeremonyNotFound        = errors.New("webauthn ceremony not found")
+	ErrCeremonyNotCreated      = errors.New("webauthn ceremony not created")
+	ErrUnresponsiveDatabase    = errors.New("error occurred during writing to passkey tables")
+)
+
+type CredentialRepository interface {
+	Create(ctx context.Context, credential *Credential) error
+	ReadByCredentialID(ctx context.Context, credentialID []byte) (*Credential, error)
+	ReadByPersonID(ctx context.Context, personID uint) ([]Credential, error)
+	// UpdateSignCount stores a new counter unless another login changed it
+	// since oldCount was read
+	UpdateSignCount(ctx context.Context, id uint, oldCount, newCount uint32, usedAt time.Time) error
+	Delete(ctx context.Context, personID, id uint) error
+}
+
+type credentialRepository struct {
+	db *gorm.DB
+}
+
+func NewCredentialRepository(db *gorm.DB) CredentialRepository {
+	return &credentialRepository{db: db}
+}
+
+func (r *credentialRepository) Create(ctx context.Context, credential *Credential) error {
+	if err := r.db.WithContext(ctx).Create(credential).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return ErrCredentialAlreadyExists
+		}
+		return ErrCredentialNotCreated
+	}
+	return nil
+}
+
+func (r *credentialRepository) ReadByCredentialID(ctx context.Context, credentialID []byte) (*Credential, error) {
+	var credential Credential
+	err := r.db.WithContext(ctx).Where("credential_id = ?", credentialID).First(&credential).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &credential, nil
+}
+
+func (r *credentialRepository) ReadByPersonID(ctx context.Context, personID uint) ([]Credential, error) {
+	var credentials []Credential
+	err := r.db.WithContext(ctx).
+		Where("person_id = ?", personID).
+		Order("created_at").
+		Find(&credentials).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return credentials, nil
+}
+
+func (r *credentialRepository) UpdateSignCount(
+	ctx context.Context,
+	id uint,
+	oldCount, newCount uint32,
+	usedAt time.Time,
+) error {
+	res := r.db.WithContext(ctx).
+		Model(&Credential{}).
+		Where("id = ? AND sign_count = ?", id, oldCount).
+		Updates(map[string]interface{}{"sign_count": newCount, "last_used_at": usedAt})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrSignCountChanged
+	}
+	return nil
+}
+
+func (r *credentialRepository) Delete(ctx context.Context, personID, id uint) error {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("id = ? AND person_id = ?", id, personID).
+		Delete(&Credential{})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrCredentialNotFound
+	}
+	return nil
+}
+
+type CeremonyRepository interface {
+	Create(ctx context.Context, ceremony *Ceremony) error
+	Consume(ctx context.Context, challenge string) (*Ceremony, error)
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type ceremonyRepository struct {
+	db *gorm.DB
+}
+
+func NewCeremonyRepository(db *gorm.DB) CeremonyRepository {
+	return &ceremonyRepository{db: db}
+}
+
+func (r *ceremonyRepository) Create(ctx context.Context, ceremony *Ceremony) error {
+	if err := r.db.WithContext(ctx).Create(ceremony).Error; err != nil {
+		return ErrCeremonyNotCreated
+	}
+	return nil
+}
+
+// Consume reads and deletes a ceremony in one transaction, so a challenge
+// can only be answered once.
+func (r *ceremonyRepository) Consume(ctx context.Context, challenge string) (*Ceremony, error) {
+	var ceremony Ceremony
+	err := r.db.
+		WithContext(ctx).
+		Transaction(func(tx *gorm.DB) error {
+			err := tx.
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("challenge = ?", challenge).
+				First(&ceremony).
+				Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCeremonyNotFound
+			}
+			if err != nil {
+				return ErrUnresponsiveDatabase
+			}
+
+			if err := tx.Unscoped().Delete(&ceremony).Error; err != nil {
+				return ErrUnresponsiveDatabase
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &ceremony, nil
+}
+
+func (r *ceremonyRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("expires_at <= ?", now).
+		Delete(&Ceremony{})
+	if res.Error != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	return res.RowsAffected, nil
+}
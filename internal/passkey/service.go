@@ -0,0 +1,371 @@
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+	"github.com/mehmetcc/definitive-authentication-service/internal/security"
+)
+
+const (
+	ceremonyTTL    = 5 * time.Minute
+	challengeBytes = 32
+	// how often expired ceremonies are purged
+	purgeInterval = time.Hour
+)
+
+var (
+	// ErrInvalidCredential covers every way a WebAuthn response can fail
+	// verification; details are only logged
+	ErrInvalidCredential         = errors.New("passkey could not be verified")
+	ErrNoCredentials             = errors.New("person has no passkeys")
+	ErrGeneratingChallengeFailed = errors.New("generating webauthn challenge failed")
+)
+
+// Settings identify this service as a WebAuthn relying party.
+type Settings struct {
+	RPID   string
+	RPName string
+	// Origins are the exact origins allowed to run ceremonies
+	Origins []string
+}
+
+type PasskeyService interface {
+	BeginRegistration(ctx context.Context, user *person.Person) (*CreationOptions, error)
+	FinishRegistration(ctx context.Context, user *person.Person, resp *AttestationResponse, name string) (*Credential, error)
+	// BeginLogin starts a passwordless login when personID is nil, and a
+	// second factor check for that person otherwise
+	BeginLogin(ctx context.Context, personID *uint) (*RequestOptions, error)
+	// FinishLogin verifies an assertion for a ceremony started by BeginLogin
+	// with the same personID and returns the person it authenticates
+	FinishLogin(ctx context.Context, resp *AssertionResponse, personID *uint) (uint, error)
+	HasCredentials(ctx context.Context, personID uint) (bool, error)
+	ListCredentials(ctx context.Context, personID uint) ([]Credential, error)
+	DeleteCredential(ctx context.Context, personID, id uint) error
+	Run(ctx context.Context)
+}
+
+type passkeyService struct {
+	credentialRepo CredentialRepository
+	ceremonyRepo   CeremonyRepository
+	events         security.EventPublisher
+	logger         *zap.Logger
+	settings       Settings
+}
+
+func NewPasskeyService(
+	credentialRepo CredentialRepository,
+	ceremonyRepo CeremonyRepository,
+	events security.EventPublisher,
+	logger *zap.Logger,
+	settings Settings,
+) PasskeyService {
+	return &passkeyService{
+		credentialRepo: credentialRepo,
+		ceremonyRepo:   ceremonyRepo,
+		events:         events,
+		logger:         logger,
+		settings:       settings,
+	}
+}
+
+// BeginRegistration creates the options for adding a passkey to the
+// person's account.
+func (s *passkeyService) BeginRegistration(ctx context.Context, user *person.Person) (*CreationOptions, error) {
+	existing, err := s.credentialRepo.ReadByPersonID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := s.startCeremony(ctx, CeremonyRegistration, &user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreationOptions{
+		RP: RelyingParty{ID: s.settings.RPID, Name: s.settings.RPName},
+		User: UserEntity{
+			ID:          encodeBase64URL(userHandle(user.ID)),
+			Name:        user.Email,
+			DisplayName: user.Email,
+		},
+		Challenge: challenge,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:     ceremonyTTL.Milliseconds(),
+		Attestation: "none",
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+		ExcludeCredentials: descriptors(existing),
+	}, nil
+}
+
+// FinishRegistration verifies the browser's answer to BeginRegistration
+// and stores the new credential.
+func (s *passkeyService) FinishRegistration(
+	ctx context.Context,
+	user *person.Person,
+	resp *AttestationResponse,
+	name string,
+) (*Credential, error) {
+	data, _, err := parseClientData(resp.Response.ClientDataJSON, clientDataCreate, s.settings.Origins)
+	if err != nil {
+		return nil, s.reject("client data", err)
+	}
+	if err := s.consumeCeremony(ctx, data.Challenge, CeremonyRegistration, &user.ID); err != nil {
+		return nil, err
+	}
+
+	authData, err := parseAttestationObject(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, s.reject("attestation object", err)
+	}
+	if err := authData.check(s.settings.RPID, false); err != nil {
+		return nil, s.reject("authenticator data", err)
+	}
+	rawID, err := decodeBase64URL(resp.RawID)
+	if err != nil || !bytes.Equal(rawID, authData.CredentialID) {
+		return nil, s.reject("credential id", ErrMalformedResponse)
+	}
+	_, alg, err := parseCOSEKey(authData.PublicKey)
+	if err != nil {
+		return nil, s.reject("credential public key", err)
+	}
+
+	credential := &Credential{
+		PersonID:     user.ID,
+		CredentialID: authData.CredentialID,
+		PublicKey:    authData.PublicKey,
+		Algorithm:    alg,
+		SignCount:    authData.SignCount,
+		Transports:   resp.Response.Transports,
+		Name:         name,
+	}
+	if err := s.credentialRepo.Create(ctx, credential); err != nil {
+		if !errors.Is(err, ErrCredentialAlreadyExists) {
+			s.logger.Error("failed to store passkey", zap.Error(err))
+		}
+		return nil, err
+	}
+	return credential, nil
+}
+
+func (s *passkeyService) BeginLogin(ctx context.Context, personID *uint) (*RequestOptions, error) {
+	kind, userVerification := CeremonyLogin, "required"
+	var allowed []CredentialDescriptor
+	if personID != nil {
+		// a second factor only needs to prove possession
+		kind, userVerification = CeremonySecondFactor, "preferred"
+		existing, err := s.credentialRepo.ReadByPersonID(ctx, *personID)
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) == 0 {
+			return nil, ErrNoCredentials
+		}
+		allowed = descriptors(existing)
+	}
+
+	challenge, err := s.startCeremony(ctx, kind, personID)
+	if err != nil {
+		return nil, err
+	}
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          ceremonyTTL.Milliseconds(),
+		RPID:             s.settings.RPID,
+		AllowCredentials: allowed,
+		UserVerification: userVerification,
+	}, nil
+}
+
+func (s *passkeyService) FinishLogin(ctx context.Context, resp *AssertionResponse, personID *uint) (uint, error) {
+	data, clientDataJSON, err := parseClientData(resp.Response.ClientDataJSON, clientDataGet, s.settings.Origins)
+	if err != nil {
+		return 0, s.reject("client data", err)
+	}
+	kind := CeremonyLogin
+	if personID != nil {
+		kind = CeremonySecondFactor
+	}
+	if err := s.consumeCeremony(ctx, data.Challenge, kind, personID); err != nil {
+		return 0, err
+	}
+
+	rawID, err := decodeBase64URL(resp.RawID)
+	if err != nil {
+		return 0, s.reject("credential id", ErrMalformedResponse)
+	}
+	credential, err := s.credentialRepo.ReadByCredentialID(ctx, rawID)
+	if errors.Is(err, ErrCredentialNotFound) {
+		return 0, ErrInvalidCredential
+	}
+	if err != nil {
+		return 0, err
+	}
+	if personID != nil && credential.PersonID != *personID {
+		return 0, s.reject("credential owner", ErrMalformedResponse)
+	}
+	if resp.Response.UserHandle != "" {
+		handle, err := decodeBase64URL(resp.Response.UserHandle)
+		if err != nil || !bytes.Equal(handle, userHandle(credential.PersonID)) {
+			return 0, s.reject("user handle", ErrMalformedResponse)
+		}
+	}
+
+	rawAuthData, err := decodeBase64URL(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, s.reject("authenticator data", ErrMalformedResponse)
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, s.reject("authenticator data", err)
+	}
+	// without a password, the authenticator has to verify the person
+	if err := authData.check(s.settings.RPID, personID == nil); err != nil {
+		return 0, s.reject("authenticator data", err)
+	}
+	signature, err := decodeBase64URL(resp.Response.Signature)
+	if err != nil {
+		return 0, s.reject("signature", ErrMalformedResponse)
+	}
+	if err := verifySignature(credential.PublicKey, rawAuthData, clientDataJSON, signature); err != nil {
+		return 0, s.reject("signature", err)
+	}
+
+	// authenticators without a counter always report 0
+	if (authData.SignCount != 0 || credential.SignCount != 0) && authData.SignCount <= credential.SignCount {
+		s.events.Publish(ctx, security.NewEvent(security.PasskeyCloneSuspected, credential.PersonID, map[string]string{
+			"credentialID": strconv.FormatUint(uint64(credential.ID), 10),
+		}))
+		return 0, ErrInvalidCredential
+	}
+	err = s.credentialRepo.UpdateSignCount(ctx, credential.ID, credential.SignCount, authData.SignCount, time.Now())
+	if errors.Is(err, ErrSignCountChanged) {
+		return 0, ErrInvalidCredential
+	}
+	if err != nil {
+		return 0, err
+	}
+	return credential.PersonID, nil
+}
+
+func (s *passkeyService) HasCredentials(ctx context.Context, personID uint) (bool, error) {
+	credentials, err := s.credentialRepo.ReadByPersonID(ctx, personID)
+	if err != nil {
+		return false, err
+	}
+	return len(credentials) > 0, nil
+}
+
+func (s *passkeyService) ListCredentials(ctx context.Context, personID uint) ([]Credential, error) {
+	return s.credentialRepo.ReadByPersonID(ctx, personID)
+}
+
+func (s *passkeyService) DeleteCredential(ctx context.Context, personID, id uint) error {
+	return s.credentialRepo.Delete(ctx, personID, id)
+}
+
+// Run purges unanswered ceremonies until ctx is cancelled.
+func (s *passkeyService) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.ceremonyRepo.DeleteExpired(ctx, time.Now()); err != nil {
+				s.logger.Error("failed to purge webauthn ceremonies", zap.Error(err))
+			}
+		}
+	}
+}
+
+// startCeremony stores a new challenge and returns it base64url encoded.
+func (s *passkeyService) startCeremony(ctx context.Context, kind CeremonyKind, personID *uint) (string, error) {
+	raw := make([]byte, challengeBytes)
+	if _, err := rand.Read(raw); err != nil {
+		s.logger.Error("failed to generate webauthn challenge", zap.Error(err))
+		return "", ErrGeneratingChallengeFailed
+	}
+	challenge := encodeBase64URL(raw)
+
+	err := s.ceremonyRepo.Create(ctx, &Ceremony{
+		Challenge: hashChallenge(challenge),
+		Kind:      kind,
+		PersonID:  personID,
+		ExpiresAt: time.Now().Add(ceremonyTTL),
+	})
+	if err != nil {
+		s.logger.Error("failed to store webauthn ceremony", zap.Error(err))
+		return "", err
+	}
+	return challenge, nil
+}
+
+// consumeCeremony redeems the challenge echoed in client data, which must
+// belong to an unexpired ceremony of the given kind and person.
+func (s *passkeyService) consumeCeremony(ctx context.Context, challenge string, kind CeremonyKind, personID *uint) error {
+	// browsers encode the challenge themselves; compare it in our encoding
+	raw, err := decodeBase64URL(challenge)
+	if err != nil {
+		return s.reject("challenge", ErrMalformedResponse)
+	}
+	ceremony, err := s.ceremonyRepo.Consume(ctx, hashChallenge(encodeBase64URL(raw)))
+	if errors.Is(err, ErrCeremonyNotFound) {
+		return ErrInvalidCredential
+	}
+	if err != nil {
+		return err
+	}
+	if ceremony.Kind != kind || time.Now().After(ceremony.ExpiresAt) {
+		return ErrInvalidCredential
+	}
+	if (ceremony.PersonID == nil) != (personID == nil) ||
+		(personID != nil && *ceremony.PersonID != *personID) {
+		return ErrInvalidCredential
+	}
+	return nil
+}
+
+func (s *passkeyService) reject(step string, err error) error {
+	s.logger.Warn("webauthn response rejected", zap.String("step", step), zap.Error(err))
+	return ErrInvalidCredential
+}
+
+// userHandle is the WebAuthn user id of a person; it carries no personal data.
+func userHandle(personID uint) []byte {
+	return []byte(strconv.FormatUint(uint64(personID), 10))
+}
+
+func descriptors(credentials []Credential) []CredentialDescriptor {
+	result := make([]CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		result = append(result, CredentialDescriptor{
+			Type:       "public-key",
+			ID:         encodeBase64URL(credential.CredentialID),
+			Transports: credential.Transports,
+		})
+	}
+	return result
+}
+
+func hashChallenge(challenge string) string {
+	sum := sha256.Sum256([]byte(challenge))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,237 @@
+package passkey
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+	"github.com/mehmetcc/definitive-authentication-service/internal/security"
+)
+
+var algorithms = []struct {
+	name string
+	alg  int
+}{
+	{"ES256", AlgES256},
+	{"EdDSA", AlgEdDSA},
+}
+
+type testFixture struct {
+	service     PasskeyService
+	credentials *memoryCredentials
+	events      *recordedEvents
+	user        *person.Person
+}
+
+func newTestFixture() *testFixture {
+	credentials := &memoryCredentials{}
+	events := &recordedEvents{}
+	user := &person.Person{Email: "ada@example.com"}
+	user.ID = 42
+	return &testFixture{
+		service: NewPasskeyService(credentials, &memoryCeremonies{}, events, zap.NewNop(), Settings{
+			RPID:    testRPID,
+			RPName:  "Example",
+			Origins: []string{testOrigin},
+		}),
+		credentials: credentials,
+		events:      events,
+		user:        user,
+	}
+}
+
+// register runs a registration ceremony with authenticator.
+func (f *testFixture) register(t *testing.T, authenticator *softAuthenticator) (*Credential, error) {
+	t.Helper()
+	ctx := context.Background()
+	options, err := f.service.BeginRegistration(ctx, f.user)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	return f.service.FinishRegistration(ctx, f.user, authenticator.register(t, options), "laptop")
+}
+
+// login runs a passwordless login, or a second factor check when
+// secondFactor is set.
+func (f *testFixture) login(t *testing.T, authenticator *softAuthenticator, secondFactor bool) (uint, error) {
+	t.Helper()
+	ctx := context.Background()
+	var personID *uint
+	if secondFactor {
+		personID = &f.user.ID
+	}
+	options, err := f.service.BeginLogin(ctx, personID)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	return f.service.FinishLogin(ctx, authenticator.assert(t, options, f.user.ID), personID)
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	for _, tc := range algorithms {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			authenticator := newSoftAuthenticator(t, tc.alg)
+
+			credential, err := f.register(t, authenticator)
+			if err != nil {
+				t.Fatalf("FinishRegistration: %v", err)
+			}
+			if credential.Algorithm != tc.alg || credential.PersonID != f.user.ID {
+				t.Fatalf("stored credential has algorithm %d and person %d", credential.Algorithm, credential.PersonID)
+			}
+
+			for _, secondFactor := range []bool{false, true} {
+				personID, err := f.login(t, authenticator, secondFactor)
+				if err != nil {
+					t.Fatalf("FinishLogin (second factor %v): %v", secondFactor, err)
+				}
+				if personID != f.user.ID {
+					t.Fatalf("FinishLogin returned person %d, want %d", personID, f.user.ID)
+				}
+			}
+			stored, _ := f.credentials.ReadByCredentialID(context.Background(), authenticator.credentialID)
+			if stored.SignCount != authenticator.signCount {
+				t.Fatalf("stored sign count %d, want %d", stored.SignCount, authenticator.signCount)
+			}
+		})
+	}
+}
+
+func TestFinishRegistrationRejectsWrongRPIDHash(t *testing.T) {
+	for _, tc := range algorithms {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			authenticator := newSoftAuthenticator(t, tc.alg)
+			authenticator.rpID = "evil.example.com"
+
+			if _, err := f.register(t, authenticator); !errors.Is(err, ErrInvalidCredential) {
+				t.Fatalf("FinishRegistration: got %v, want ErrInvalidCredential", err)
+			}
+		})
+	}
+}
+
+func TestFinishRegistrationRequiresUserPresence(t *testing.T) {
+	for _, tc := range algorithms {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			authenticator := newSoftAuthenticator(t, tc.alg)
+			authenticator.flags = flagUserVerified
+
+			if _, err := f.register(t, authenticator); !errors.Is(err, ErrInvalidCredential) {
+				t.Fatalf("FinishRegistration: got %v, want ErrInvalidCredential", err)
+			}
+		})
+	}
+}
+
+func TestFinishLoginRejectsWrongRPIDHash(t *testing.T) {
+	for _, tc := range algorithms {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			authenticator := newSoftAuthenticator(t, tc.alg)
+			if _, err := f.register(t, authenticator); err != nil {
+				t.Fatalf("FinishRegistration: %v", err)
+			}
+
+			authenticator.rpID = "evil.example.com"
+			if _, err := f.login(t, authenticator, false); !errors.Is(err, ErrInvalidCredential) {
+				t.Fatalf("FinishLogin: got %v, want ErrInvalidCredential", err)
+			}
+		})
+	}
+}
+
+func TestFinishLoginRequiresUserPresence(t *testing.T) {
+	for _, tc := range algorithms {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			authenticator := newSoftAuthenticator(t, tc.alg)
+			if _, err := f.register(t, authenticator); err != nil {
+				t.Fatalf("FinishRegistration: %v", err)
+			}
+
+			authenticator.flags = flagUserVerified
+			for _, secondFactor := range []bool{false, true} {
+				if _, err := f.login(t, authenticator, secondFactor); !errors.Is(err, ErrInvalidCredential) {
+					t.Fatalf("FinishLogin (second factor %v): got %v, want ErrInvalidCredential", secondFactor, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFinishLoginRequiresUserVerificationWithoutPassword(t *testing.T) {
+	for _, tc := range algorithms {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			authenticator := newSoftAuthenticator(t, tc.alg)
+			if _, err := f.register(t, authenticator); err != nil {
+				t.Fatalf("FinishRegistration: %v", err)
+			}
+
+			authenticator.flags = flagUserPresent
+			if _, err := f.login(t, authenticator, false); !errors.Is(err, ErrInvalidCredential) {
+				t.Fatalf("passwordless FinishLogin: got %v, want ErrInvalidCredential", err)
+			}
+			// after a password, presence is enough
+			if _, err := f.login(t, authenticator, true); err != nil {
+				t.Fatalf("second factor FinishLogin: %v", err)
+			}
+		})
+	}
+}
+
+func TestFinishLoginRejectsReplayedChallenge(t *testing.T) {
+	for _, tc := range algorithms {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			authenticator := newSoftAuthenticator(t, tc.alg)
+			if _, err := f.register(t, authenticator); err != nil {
+				t.Fatalf("FinishRegistration: %v", err)
+			}
+
+			ctx := context.Background()
+			options, err := f.service.BeginLogin(ctx, nil)
+			if err != nil {
+				t.Fatalf("BeginLogin: %v", err)
+			}
+			if _, err := f.service.FinishLogin(ctx, authenticator.assert(t, options, f.user.ID), nil); err != nil {
+				t.Fatalf("FinishLogin: %v", err)
+			}
+			// a fresh signature over the same challenge is still a replay
+			replayed := authenticator.assert(t, options, f.user.ID)
+			if _, err := f.service.FinishLogin(ctx, replayed, nil); !errors.Is(err, ErrInvalidCredential) {
+				t.Fatalf("replayed FinishLogin: got %v, want ErrInvalidCredential", err)
+			}
+		})
+	}
+}
+
+func TestFinishLoginRejectsSignCountThatDoesNotIncrease(t *testing.T) {
+	for _, tc := range algorithms {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			authenticator := newSoftAuthenticator(t, tc.alg)
+			if _, err := f.register(t, authenticator); err != nil {
+				t.Fatalf("FinishRegistration: %v", err)
+			}
+			if _, err := f.login(t, authenticator, false); err != nil {
+				t.Fatalf("FinishLogin: %v", err)
+			}
+
+			// a clone signs with the counter the original had
+			authenticator.signCount--
+			if _, err := f.login(t, authenticator, false); !errors.Is(err, ErrInvalidCredential) {
+				t.Fatalf("FinishLogin with repeated sign count: got %v, want ErrInvalidCredential", err)
+			}
+			if len(f.events.events) != 1 || f.events.events[0].Type != security.PasskeyCloneSuspected {
+				t.Fatalf("published %v, want one %s event", f.events.events, security.PasskeyCloneSuspected)
+			}
+		})
+	}
+}
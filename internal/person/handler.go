@@ -6,6 +6,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/rbac"
 )
 
 // ContextUserKey is the key under which the authenticated Person is stored in Gin context.
@@ -55,15 +57,21 @@ type PersonHandler struct {
 	logger  *zap.Logger
 }
 
-// NewPersonHandler registers person endpoints on the given router group.
-func NewPersonHandler(router *gin.RouterGroup, service PersonService, logger *zap.Logger) *PersonHandler {
+// NewPersonHandler registers person endpoints on the given router group;
+// authorize guards each of them with a permission.
+func NewPersonHandler(
+	router *gin.RouterGroup,
+	service PersonService,
+	logger *zap.Logger,
+	authorize func(permission string) gin.HandlerFunc,
+) *PersonHandler {
 	h := &PersonHandler{router: router, service: service, logger: logger}
-	h.router.POST("/persons", h.CreatePerson)
-	h.router.GET("/persons/:id", h.ReadPersonByID)
-	h.router.GET("/persons", h.ReadPersonByEmail)
-	h.router.PUT("/persons/:id/email", h.UpdateEmail)
-	h.router.PUT("/persons/:id/password", h.UpdatePassword)
-	h.router.DELETE("/persons/:id", h.DeletePerson)
+	h.router.POST("/persons", authorize(rbac.PersonsCreate), h.CreatePerson)
+	h.router.GET("/persons/:id", authorize(rbac.PersonsRead), h.ReadPersonByID)
+	h.router.GET("/persons", authorize(rbac.PersonsRead), h.ReadPersonByEmail)
+	h.router.PUT("/persons/:id/email", authorize(rbac.PersonsUpdate), h.UpdateEmail)
+	h.router.PUT("/persons/:id/password", authorize(rbac.PersonsUpdate), h.UpdatePassword)
+	h.router.DELETE("/persons/:id", authorize(rbac.PersonsDelete), h.DeletePerson)
 	return h
 }
 
@@ -184,12 +192,12 @@ func (h *PersonHandler) ReadPersonByEmail(c *gin.Context) {
 
 // UpdateEmail godoc
 // @Summary      Update Person Email
-// @Description  Change a person's email
+// @Description  Request a change of a person's email; it takes effect once the new address is confirmed
 // @Tags         persons
 // @Accept       json
 // @Param        id       path      int                 true  "Person ID"
 // @Param        payload  body      UpdateEmailRequest  true  "New email payload"
-// @Success      204
+// @Success      202
 // @Failure      400      {object}  map[string]string
 // @Failure      404      {object}  map[string]string
 // @Failure      409      {object}  map[string]string
@@ -200,6 +208,31 @@ func (h *PersonHandler) UpdateEmail(c *gin.Context) {
 	if !ok {
 		return
 	}
+	h.updateEmail(c, id)
+}
+
+// UpdateCurrentEmail godoc
+// @Summary      Update Current Email
+// @Description  Request a change of the authenticated user's email; it takes effect once the new address is confirmed
+// @Tags         persons
+// @Accept       json
+// @Param        payload  body      UpdateEmailRequest  true  "New email payload"
+// @Success      202
+// @Failure      400      {object}  map[string]string
+// @Failure      401      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /persons/me/email [put]
+func (h *PersonHandler) UpdateCurrentEmail(c *gin.Context) {
+	raw, exists := c.Get(ContextUserKey)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	h.updateEmail(c, raw.(*Person).ID)
+}
+
+func (h *PersonHandler) updateEmail(c *gin.Context, id uint) {
 	var req UpdateEmailRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		h.logger.Warn("invalid update email payload", zap.Error(err))
@@ -209,7 +242,7 @@ func (h *PersonHandler) UpdateEmail(c *gin.Context) {
 	err := h.service.UpdateEmail(c.Request.Context(), id, req.Email)
 	switch {
 	case err == nil:
-		c.Status(http.StatusNoContent)
+		c.Status(http.StatusAccepted)
 	case errors.Is(err, ErrInvalidEmailFormat):
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email format"})
 	case errors.Is(err, ErrPersonNotFound):
@@ -6,17 +6,6 @@ import (
 	"gorm.io/gorm"
 )
 
-// Role represents the set of possible user roles.
-// @Description user role type: "admin" or "user"
-type Role string
-
-const (
-	// Admin has full access
-	Admin Role = "admin"
-	// User has limited access
-	User Role = "user"
-)
-
 // Person represents a user in the system.
 // swagger:model PersonResponse
 // @Description person model
@@ -26,23 +15,29 @@ const (
 // @Property DeletedAt  body string  false "record deletion timestamp (soft delete)"
 // @Property email      body string  true  "unique email address"
 // @Property last_seen  body string  true  "last seen timestamp"
-// @Property role       body string  true  "user role"
+// @Property email_verified body boolean true "whether the email address has been confirmed"
+// @Property pending_email  body string  false "new email address awaiting confirmation"
 // Person represents a user in the system.
 // swagger:model PersonResponse
 type Person struct {
 	gorm.Model
 	// Email address (unique)
 	Email string `json:"email" gorm:"uniqueIndex;not null"`
+	// EmailVerified is set once the person confirmed they receive mail at Email
+	EmailVerified bool `json:"email_verified" gorm:"not null;default:false"`
+	// PendingEmail replaces Email once confirmed
+	PendingEmail string `json:"pending_email,omitempty" gorm:"not null;default:''"`
 	// Password hash (hidden from JSON)
 	Password string `json:"-"`
 	// LastSeen indicates last activity time
 	LastSeen time.Time `json:"last_seen"`
-	// Role of the person
-	Role Role `json:"role" gorm:"type:text;default:'user'"`
+	// TokenVersion is carried by access tokens, which stop being accepted
+	// once it is incremented; only IncrementTokenVersion writes it
+	TokenVersion uint `json:"-" gorm:"<-:create;not null;default:0"`
 }
 
-// NewPerson initializes a new Person with default role.
-// @Description factory to create Person with default User role
+// NewPerson initializes a new Person.
+// @Description factory to create Person
 // @Param email path string true "email address"
 // @Param password path string true "plaintext password"
 // @Success 200 {object} Person
@@ -51,6 +46,22 @@ func NewPerson(email, password string) *Person {
 		Email:    email,
 		Password: password,
 		LastSeen: time.Now().UTC(),
-		Role:     User,
 	}
 }
+
+// ConfirmEmail applies a confirmation of email: it verifies the current
+// address, or makes the pending one current. It reports false if email is
+// neither, e.g. because another change was requested since.
+func (p *Person) ConfirmEmail(email string) bool {
+	switch {
+	case p.PendingEmail != "" && p.PendingEmail == email:
+		p.Email = email
+		p.PendingEmail = ""
+		p.EmailVerified = true
+	case p.Email == email:
+		p.EmailVerified = true
+	default:
+		return false
+	}
+	return true
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/jackc/pgconn"
 	"gorm.io/gorm"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/tenant"
 )
 
 var (
@@ -18,12 +20,15 @@ var (
 	ErrUnresponsiveDatabase = errors.New("error occured during writing to persons table")
 )
 
+// PersonRepository reads and writes persons. Reads and deletes made in the
+// context of an organization only see its members.
 type PersonRepository interface {
 	Create(ctx context.Context, person *Person) error
 	ReadByEmail(ctx context.Context, email string) (*Person, error)
 	ReadByID(ctx context.Context, id uint) (*Person, error)
 	Update(ctx context.Context, person *Person) error
 	Delete(ctx context.Context, id uint) error
+	IncrementTokenVersion(ctx context.Context, id uint) error
 }
 
 type personRepository struct {
@@ -37,6 +42,7 @@ func NewPersonRepository(db *gorm.DB) PersonRepository {
 func (p *personRepository) ReadByID(ctx context.Context, id uint) (*Person, error) {
 	var person Person
 	err := p.db.WithContext(ctx).
+		Scopes(tenant.Members(ctx, "id")).
 		Where("deleted_at IS NULL").
 		First(&person, id).
 		Error
@@ -66,6 +72,7 @@ func (p *personRepository) Create(ctx context.Context, person *Person) error {
 func (p *personRepository) ReadByEmail(ctx context.Context, email string) (*Person, error) {
 	var person Person
 	err := p.db.WithContext(ctx).
+		Scopes(tenant.Members(ctx, "id")).
 		Where("email = ?", email).
 		Where("deleted_at IS NULL").
 		First(&person).
@@ -94,10 +101,24 @@ func (p *personRepository) Update(ctx context.Context, person *Person) error {
 }
 
 func (p *personRepository) Delete(ctx context.Context, id uint) error {
+	res := p.db.WithContext(ctx).
+		Scopes(tenant.Members(ctx, "id")).
+		Delete(&Person{}, id)
+	if res.Error != nil {
+		return ErrPersonNotDeleted
+	}
+	// also when the person is outside the organization in ctx
+	if res.RowsAffected == 0 {
+		return ErrPersonNotFound
+	}
+	return nil
+}
+
+func (p *personRepository) IncrementTokenVersion(ctx context.Context, id uint) error {
 	if err := p.db.WithContext(ctx).
-		Delete(&Person{}, id).
+		Exec("UPDATE persons SET token_version = token_version + 1 WHERE id = ?", id).
 		Error; err != nil {
-		return ErrPersonNotDeleted
+		return ErrPersonNotUpdated
 	}
 	return nil
 }
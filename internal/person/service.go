@@ -20,6 +20,21 @@ type validationTarget struct {
 	password string
 }
 
+// EmailVerifier asks a person to confirm they receive mail at an address.
+type EmailVerifier interface {
+	SendVerification(ctx context.Context, person *Person, email string) error
+}
+
+// SessionRevoker ends every session of a person.
+type SessionRevoker interface {
+	RevokeSessions(ctx context.Context, personID uint) error
+}
+
+// RoleAssigner gives new persons their default roles.
+type RoleAssigner interface {
+	AssignDefaultRoles(ctx context.Context, personID uint) error
+}
+
 type PersonService interface {
 	CreatePerson(ctx context.Context, email, password string) (*Person, error)
 	ReadPersonByEmail(ctx context.Context, email string) (*Person, error)
@@ -31,14 +46,26 @@ type PersonService interface {
 }
 
 type personService struct {
-	repo   PersonRepository
-	logger *zap.Logger
+	repo     PersonRepository
+	verifier EmailVerifier
+	sessions SessionRevoker
+	roles    RoleAssigner
+	logger   *zap.Logger
 }
 
-func NewPersonService(repo PersonRepository, logger *zap.Logger) PersonService {
+func NewPersonService(
+	repo PersonRepository,
+	verifier EmailVerifier,
+	sessions SessionRevoker,
+	roles RoleAssigner,
+	logger *zap.Logger,
+) PersonService {
 	return &personService{
-		repo:   repo,
-		logger: logger,
+		repo:     repo,
+		verifier: verifier,
+		sessions: sessions,
+		roles:    roles,
+		logger:   logger,
 	}
 }
 
@@ -62,6 +89,14 @@ func (s *personService) CreatePerson(ctx context.Context, email, password string
 		s.logger.Error("failed to create person in repository", zap.Error(err))
 		return nil, err
 	}
+	if err := s.roles.AssignDefaultRoles(ctx, person.ID); err != nil {
+		s.logger.Error("failed to assign default roles", zap.Uint("id", person.ID), zap.Error(err))
+		return nil, err
+	}
+	// the account exists either way; the person can ask for another link
+	if err := s.verifier.SendVerification(ctx, person, person.Email); err != nil {
+		s.logger.Error("failed to send email verification", zap.Uint("id", person.ID), zap.Error(err))
+	}
 	return person, nil
 }
 
@@ -113,6 +148,9 @@ func (s *personService) ReadPersonByID(ctx context.Context, id uint) (*Person, e
 }
 
 /** UPDATE */
+
+// UpdateEmail records email as the person's pending address and sends it a
+// confirmation; the address only replaces the current one once confirmed.
 func (s *personService) UpdateEmail(ctx context.Context, id uint, email string) error {
 	if err := s.validateEmail(email); err != nil {
 		s.logger.Error("invalid email format", zap.Uint("id", id), zap.String("email", email), zap.Error(err))
@@ -124,12 +162,30 @@ func (s *personService) UpdateEmail(ctx context.Context, id uint, email string)
 		s.logger.Error("failed to update email, person not found", zap.Uint("id", id), zap.Error(err))
 		return err
 	}
+	if person.Email == email {
+		return nil
+	}
+	taken, err := s.repo.ReadByEmail(ctx, email)
+	if err == nil && taken.ID != id {
+		return ErrEmailAlreadyExists
+	}
+	if err != nil && !errors.Is(err, ErrPersonNotFound) {
+		s.logger.Error("failed to check email availability", zap.Uint("id", id), zap.Error(err))
+		return err
+	}
 
-	person.Email = email
+	person.PendingEmail = email
 	if err := s.repo.Update(ctx, person); err != nil {
 		s.logger.Error("failed to update email in repository", zap.Uint("id", id), zap.String("email", email), zap.Error(err))
 		return err
 	}
+	if err := s.endSessions(ctx, id); err != nil {
+		return err
+	}
+	if err := s.verifier.SendVerification(ctx, person, email); err != nil {
+		s.logger.Error("failed to send email verification", zap.Uint("id", id), zap.Error(err))
+		return err
+	}
 	return nil
 }
 
@@ -156,7 +212,7 @@ func (s *personService) UpdatePassword(ctx context.Context, id uint, password st
 		s.logger.Error("failed to update password in repository", zap.Uint("id", id), zap.Error(err))
 		return err
 	}
-	return nil
+	return s.endSessions(ctx, id)
 }
 
 func (s *personService) UpdateLastSeen(ctx context.Context, id uint) error {
@@ -180,5 +236,19 @@ func (s *personService) DeletePerson(ctx context.Context, id uint) error {
 		s.logger.Error("failed to delete person", zap.Uint("id", id), zap.Error(err))
 		return err
 	}
+	return s.endSessions(ctx, id)
+}
+
+// endSessions revokes the person's refresh tokens and, by incrementing the
+// token version, the access tokens issued with them.
+func (s *personService) endSessions(ctx context.Context, id uint) error {
+	if err := s.repo.IncrementTokenVersion(ctx, id); err != nil {
+		s.logger.Error("failed to increment token version", zap.Uint("id", id), zap.Error(err))
+		return err
+	}
+	if err := s.sessions.RevokeSessions(ctx, id); err != nil {
+		s.logger.Error("failed to revoke sessions", zap.Uint("id", id), zap.Error(err))
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,97 @@
+package rbac
+
+// roleGraph holds the effective permissions of every role at one role graph
+// version: its own, and those of the roles it inherits, transitively.
+type roleGraph struct {
+	version  uint64
+	inherits map[string][]string
+	// ancestors of a role are itself and every role it inherits
+	ancestors   map[string]map[string]bool
+	permissions map[string]map[string]bool
+}
+
+func newRoleGraph(version uint64, roles []Role) *roleGraph {
+	g := &roleGraph{
+		version:     version,
+		inherits:    make(map[string][]string, len(roles)),
+		ancestors:   make(map[string]map[string]bool, len(roles)),
+		permissions: make(map[string]map[string]bool, len(roles)),
+	}
+	own := make(map[string][]Permission, len(roles))
+	for _, role := range roles {
+		g.inherits[role.Name] = role.Inherits
+		own[role.Name] = role.Permissions
+	}
+	for _, role := range roles {
+		ancestors := g.collect(role.Name)
+		permissions := make(map[string]bool)
+		for ancestor := range ancestors {
+			for _, permission := range own[ancestor] {
+				permissions[permission.Name] = true
+			}
+		}
+		g.ancestors[role.Name] = ancestors
+		g.permissions[role.Name] = permissions
+	}
+	return g
+}
+
+// collect returns role and every role it inherits. The set doubles as the
+// visited set, so a cycle, which updates refuse to create, cannot loop.
+func (g *roleGraph) collect(role string) map[string]bool {
+	seen := map[string]bool{role: true}
+	stack := []string{role}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, inherited := range g.inherits[current] {
+			if !seen[inherited] {
+				seen[inherited] = true
+				stack = append(stack, inherited)
+			}
+		}
+	}
+	return seen
+}
+
+// allows reports whether any of roles has permission.
+func (g *roleGraph) allows(roles []string, permission string) bool {
+	for _, role := range roles {
+		if g.permissions[role][permission] {
+			return true
+		}
+	}
+	return false
+}
+
+// atLeast reports whether any of roles is required or inherits it.
+func (g *roleGraph) atLeast(roles []string, required string) bool {
+	for _, role := range roles {
+		if g.ancestors[role][required] {
+			return true
+		}
+	}
+	return false
+}
+
+// effective returns the names of the permissions roles have between them.
+func (g *roleGraph) effective(roles []string) map[string]bool {
+	permissions := make(map[string]bool)
+	for _, role := range roles {
+		for permission := range g.permissions[role] {
+			permissions[permission] = true
+		}
+	}
+	return permissions
+}
+
+// createsCycle reports whether letting role inherit inherits would make it
+// inherit itself.
+func (g *roleGraph) createsCycle(role string, inherits []string) bool {
+	for _, inherited := range inherits {
+		if g.collect(inherited)[role] {
+			return true
+		}
+	}
+	return false
+}
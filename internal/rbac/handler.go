@@ -0,0 +1,344 @@
+package rbac
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// RoleRequest represents the payload for creating a role.
+// @Description payload to create a role
+// @Property name        body string   true  "unique role name"
+// @Property description body string   false "what the role is for"
+// @Property permissions body []string false "names of the granted permissions"
+// @Property inherits    body []string false "names of the roles it inherits"
+type RoleRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description" binding:"max=255"`
+	Permissions []string `json:"permissions"`
+	Inherits    []string `json:"inherits"`
+}
+
+// UpdateRoleRequest represents the payload for changing a role.
+// @Description payload to replace the description, permissions and inherited roles of a role
+// @Property description body string   false "what the role is for"
+// @Property permissions body []string false "names of the granted permissions"
+// @Property inherits    body []string false "names of the roles it inherits"
+type UpdateRoleRequest struct {
+	Description string   `json:"description" binding:"max=255"`
+	Permissions []string `json:"permissions"`
+	Inherits    []string `json:"inherits"`
+}
+
+// PersonRolesRequest represents the payload for assigning roles.
+// @Description payload to replace the roles of a person
+// @Property roles body []string true "names of the assigned roles"
+type PersonRolesRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// IDRequest represents a URI id parameter.
+type IDRequest struct {
+	ID uint `uri:"id" binding:"required"`
+}
+
+// RBACHandler handles HTTP requests for role administration.
+type RBACHandler struct {
+	router  *gin.RouterGroup
+	service RBACService
+	logger  *zap.Logger
+}
+
+// NewRBACHandler registers role endpoints on the given router group;
+// authorize guards each of them with a permission.
+func NewRBACHandler(
+	router *gin.RouterGroup,
+	service RBACService,
+	logger *zap.Logger,
+	authorize func(permission string) gin.HandlerFunc,
+) *RBACHandler {
+	h := &RBACHandler{router: router, service: service, logger: logger}
+	h.router.GET("/permissions", authorize(RolesRead), h.ListPermissions)
+	h.router.GET("/roles", authorize(RolesRead), h.ListRoles)
+	h.router.POST("/roles", authorize(RolesManage), h.CreateRole)
+	h.router.GET("/roles/:id", authorize(RolesRead), h.ReadRole)
+	h.router.PUT("/roles/:id", authorize(RolesManage), h.UpdateRole)
+	h.router.DELETE("/roles/:id", authorize(RolesManage), h.DeleteRole)
+	h.router.GET("/persons/:id/roles", authorize(RolesRead), h.ReadPersonRoles)
+	h.router.PUT("/persons/:id/roles", authorize(RolesManage), h.SetPersonRoles)
+	h.router.GET("/persons/:id/permissions", authorize(RolesRead), h.ReadPersonPermissions)
+	return h
+}
+
+func (h *RBACHandler) bindID(c *gin.Context) (uint, bool) {
+	var uri IDRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id"})
+		return 0, false
+	}
+	return uri.ID, true
+}
+
+// ListPermissions godoc
+// @Summary      List Permissions
+// @Description  List every permission roles can grant
+// @Tags         roles
+// @Produce      json
+// @Success      200      {array}   Permission
+// @Failure      500      {object}  map[string]string
+// @Router       /permissions [get]
+func (h *RBACHandler) ListPermissions(c *gin.Context) {
+	permissions, err := h.service.ListPermissions(c.Request.Context())
+	if err != nil {
+		h.logger.Error("service.ListPermissions failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list permissions"})
+		return
+	}
+	c.JSON(http.StatusOK, permissions)
+}
+
+// ListRoles godoc
+// @Summary      List Roles
+// @Description  List all roles with their permissions
+// @Tags         roles
+// @Produce      json
+// @Success      200      {array}   Role
+// @Failure      500      {object}  map[string]string
+// @Router       /roles [get]
+func (h *RBACHandler) ListRoles(c *gin.Context) {
+	roles, err := h.service.ListRoles(c.Request.Context())
+	if err != nil {
+		h.logger.Error("service.ListRoles failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list roles"})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// CreateRole godoc
+// @Summary      Create Role
+// @Description  Create a role granting the given permissions and those of the roles it inherits
+// @Tags         roles
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      RoleRequest  true  "Role payload"
+// @Success      201      {object}  Role
+// @Failure      400      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /roles [post]
+func (h *RBACHandler) CreateRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid create role payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name required"})
+		return
+	}
+	role, err := h.service.CreateRole(c.Request.Context(), req.Name, req.Description, req.Permissions, req.Inherits)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, role)
+	case errors.Is(err, ErrInvalidRoleName), errors.Is(err, ErrUnknownPermission), errors.Is(err, ErrUnknownRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrRoleAlreadyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "role already exists"})
+	default:
+		h.logger.Error("service.CreateRole failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create role"})
+	}
+}
+
+// ReadRole godoc
+// @Summary      Get Role
+// @Description  Fetch a role by ID
+// @Tags         roles
+// @Produce      json
+// @Param        id       path      int  true  "Role ID"
+// @Success      200      {object}  Role
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /roles/{id} [get]
+func (h *RBACHandler) ReadRole(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	role, err := h.service.ReadRole(c.Request.Context(), id)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, role)
+	case errors.Is(err, ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
+	default:
+		h.logger.Error("service.ReadRole failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch role"})
+	}
+}
+
+// UpdateRole godoc
+// @Summary      Update Role
+// @Description  Replace the description, permissions and inherited roles of a role
+// @Tags         roles
+// @Accept       json
+// @Produce      json
+// @Param        id       path      int                true  "Role ID"
+// @Param        payload  body      UpdateRoleRequest  true  "Role payload"
+// @Success      200      {object}  Role
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /roles/{id} [put]
+func (h *RBACHandler) UpdateRole(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid update role payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role payload"})
+		return
+	}
+	role, err := h.service.UpdateRole(c.Request.Context(), id, req.Description, req.Permissions, req.Inherits)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, role)
+	case errors.Is(err, ErrUnknownPermission), errors.Is(err, ErrUnknownRole), errors.Is(err, ErrRoleCycle):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
+	case errors.Is(err, ErrGraphChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": "roles changed concurrently, try again"})
+	default:
+		h.logger.Error("service.UpdateRole failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not update role"})
+	}
+}
+
+// DeleteRole godoc
+// @Summary      Delete Role
+// @Description  Delete a role, removing it from every person
+// @Tags         roles
+// @Param        id       path      int  true  "Role ID"
+// @Success      204      "No Content"
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /roles/{id} [delete]
+func (h *RBACHandler) DeleteRole(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	err := h.service.DeleteRole(c.Request.Context(), id)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
+	case errors.Is(err, ErrBuiltinRole):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("service.DeleteRole failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete role"})
+	}
+}
+
+// ReadPersonRoles godoc
+// @Summary      Get Person Roles
+// @Description  List the roles assigned to a person
+// @Tags         roles
+// @Produce      json
+// @Param        id       path      int  true  "Person ID"
+// @Success      200      {array}   Role
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /persons/{id}/roles [get]
+func (h *RBACHandler) ReadPersonRoles(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	roles, err := h.service.PersonRoles(c.Request.Context(), id)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, roles)
+	case errors.Is(err, ErrPersonNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "person not found"})
+	default:
+		h.logger.Error("service.PersonRoles failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch roles"})
+	}
+}
+
+// SetPersonRoles godoc
+// @Summary      Set Person Roles
+// @Description  Replace the roles of a person; if they lose a role, their sessions end so no token carries it
+// @Tags         roles
+// @Accept       json
+// @Produce      json
+// @Param        id       path      int                 true  "Person ID"
+// @Param        payload  body      PersonRolesRequest  true  "Roles payload"
+// @Success      200      {array}   Role
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /persons/{id}/roles [put]
+func (h *RBACHandler) SetPersonRoles(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	var req PersonRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid person roles payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "roles required"})
+		return
+	}
+	roles, err := h.service.SetPersonRoles(c.Request.Context(), id, req.Roles)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, roles)
+	case errors.Is(err, ErrUnknownRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrPersonNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "person not found"})
+	default:
+		h.logger.Error("service.SetPersonRoles failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not assign roles"})
+	}
+}
+
+// ReadPersonPermissions godoc
+// @Summary      Get Person Permissions
+// @Description  List the effective permissions of a person, including those their roles inherit
+// @Tags         roles
+// @Produce      json
+// @Param        id       path      int  true  "Person ID"
+// @Success      200      {array}   string
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /persons/{id}/permissions [get]
+func (h *RBACHandler) ReadPersonPermissions(c *gin.Context) {
+	id, ok := h.bindID(c)
+	if !ok {
+		return
+	}
+	permissions, err := h.service.PersonPermissions(c.Request.Context(), id)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, permissions)
+	case errors.Is(err, ErrPersonNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "person not found"})
+	default:
+		h.logger.Error("service.PersonPermissions failed", zap.Uint("id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch permissions"})
+	}
+}
@@ -0,0 +1,98 @@
+package rbac
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Permission allows one kind of operation, such as "persons:delete". The
+// set of permissions is fixed by the code checking them.
+// swagger:model PermissionResponse
+// @Description permission that roles can grant
+// @Property name        body string true "permission name"
+// @Property description body string true "what the permission allows"
+type Permission struct {
+	ID          uint   `json:"-" gorm:"primaryKey"`
+	Name        string `json:"name" gorm:"uniqueIndex;not null"`
+	Description string `json:"description" gorm:"not null;default:''"`
+}
+
+// Role is a named set of permissions assigned to persons. A role also has
+// the permissions of the roles it inherits, transitively.
+// swagger:model RoleResponse
+// @Description role granting a set of permissions
+// @Property ID          body integer  true "unique identifier"
+// @Property name        body string   true "unique role name"
+// @Property description body string   true "what the role is for"
+// @Property builtin     body boolean  true "built-in roles cannot be renamed or deleted"
+// @Property permissions body []PermissionResponse true "granted permissions"
+// @Property inherits    body []string true "names of the roles whose permissions it also has"
+type Role struct {
+	gorm.Model
+	Name        string `json:"name" gorm:"uniqueIndex;not null"`
+	Description string `json:"description" gorm:"not null;default:''"`
+	// Builtin roles are seeded at startup and cannot be deleted
+	Builtin     bool         `json:"builtin" gorm:"not null;default:false"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+	// Inherits names the roles this one inherits, as stored in
+	// role_inheritances
+	Inherits []string `json:"inherits" gorm:"-"`
+}
+
+// OrganizationAssignment gives a member of an organization a role within
+// it. Only the permissions in tenantPermissions are granted this way.
+type OrganizationAssignment struct {
+	OrganizationID uint `gorm:"primaryKey"`
+	PersonID       uint `gorm:"primaryKey"`
+	RoleID         uint `gorm:"primaryKey;index"`
+	CreatedAt      time.Time
+}
+
+func (OrganizationAssignment) TableName() string {
+	return "organization_person_roles"
+}
+
+// GroupAssignment grants a role to every member of a group.
+type GroupAssignment struct {
+	GroupID   uint `gorm:"primaryKey"`
+	RoleID    uint `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+func (GroupAssignment) TableName() string {
+	return "group_roles"
+}
+
+// Inheritance makes a role inherit another.
+type Inheritance struct {
+	RoleID          uint `gorm:"primaryKey"`
+	InheritedRoleID uint `gorm:"primaryKey;index"`
+}
+
+func (Inheritance) TableName() string {
+	return "role_inheritances"
+}
+
+// GraphVersion counts the changes to roles, their permissions and their
+// inheritance, so that instances know when to recompute effective
+// permissions. It has a single row.
+type GraphVersion struct {
+	ID      uint `gorm:"primaryKey"`
+	Version uint64
+}
+
+func (GraphVersion) TableName() string {
+	return "role_graph_versions"
+}
+
+// Assignment gives a person a role.
+type Assignment struct {
+	PersonID  uint `gorm:"primaryKey"`
+	RoleID    uint `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+func (Assignment) TableName() string {
+	return "person_roles"
+}
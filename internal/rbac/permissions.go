@@ -0,0 +1,86 @@
+package rbac
+
+import "slices"
+
+// Permissions checked by the admin endpoints.
+const (
+	PersonsRead    = "persons:read"
+	PersonsCreate  = "persons:create"
+	PersonsUpdate  = "persons:update"
+	PersonsDelete  = "persons:delete"
+	SessionsRead   = "sessions:read"
+	SessionsRevoke = "sessions:revoke"
+	LockoutsRead   = "lockouts:read"
+	LockoutsClear  = "lockouts:clear"
+	ClientsRead    = "clients:read"
+	ClientsManage  = "clients:manage"
+	KeysRead       = "keys:read"
+	KeysRotate     = "keys:rotate"
+	InvitesManage  = "invites:manage"
+	RolesRead      = "roles:read"
+	RolesManage    = "roles:manage"
+
+	OrganizationsRead   = "organizations:read"
+	OrganizationsManage = "organizations:manage"
+	MembersRead         = "members:read"
+	MembersManage       = "members:manage"
+
+	GroupsRead   = "groups:read"
+	GroupsManage = "groups:manage"
+)
+
+// Built-in roles, seeded at startup.
+const (
+	// AdminRole is granted every permission
+	AdminRole = "admin"
+	// UserRole is assigned to every new person and grants nothing beyond
+	// managing one's own account
+	UserRole = "user"
+)
+
+// catalog describes every permission, as seeded into the database.
+var catalog = []Permission{
+	{Name: PersonsRead, Description: "Read persons"},
+	{Name: PersonsCreate, Description: "Create persons"},
+	{Name: PersonsUpdate, Description: "Change the email address and password of persons"},
+	{Name: PersonsDelete, Description: "Delete persons"},
+	{Name: SessionsRead, Description: "List the sessions of persons"},
+	{Name: SessionsRevoke, Description: "Log persons out"},
+	{Name: LockoutsRead, Description: "Read the failed logins of persons"},
+	{Name: LockoutsClear, Description: "Unlock accounts"},
+	{Name: ClientsRead, Description: "Read OAuth clients"},
+	{Name: ClientsManage, Description: "Register and delete OAuth clients"},
+	{Name: KeysRead, Description: "List signing keys"},
+	{Name: KeysRotate, Description: "Rotate signing keys"},
+	{Name: InvitesManage, Description: "Create and delete registration invites"},
+	{Name: RolesRead, Description: "Read roles and the roles of persons"},
+	{Name: RolesManage, Description: "Create, change and delete roles and assign them to persons"},
+	{Name: OrganizationsRead, Description: "Read organizations"},
+	{Name: OrganizationsManage, Description: "Create, change and delete organizations"},
+	{Name: MembersRead, Description: "List the members of an organization and their roles"},
+	{Name: MembersManage, Description: "Add and remove members of an organization and assign their roles"},
+	{Name: GroupsRead, Description: "Read groups and their members"},
+	{Name: GroupsManage, Description: "Create, change and delete groups and add and remove their members"},
+}
+
+// IsPermission reports whether name is a permission of the catalog.
+func IsPermission(name string) bool {
+	return slices.ContainsFunc(catalog, func(permission Permission) bool {
+		return permission.Name == name
+	})
+}
+
+// tenantPermissions are the permissions roles grant within an organization.
+// The others act on every tenant, such as deleting a person who may belong
+// to other organizations too, and are only granted by roles assigned
+// outside of organizations.
+var tenantPermissions = map[string]bool{
+	PersonsRead:    true,
+	SessionsRead:   true,
+	SessionsRevoke: true,
+	LockoutsRead:   true,
+	LockoutsClear:  true,
+	RolesRead:      true,
+	MembersRead:    true,
+	MembersManage:  true,
+}
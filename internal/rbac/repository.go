@@ -0,0 +1,574 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/tenant"
+)
+
+var (
+	ErrRoleNotFound         = errors.New("role not found")
+	ErrRoleAlreadyExists    = errors.New("role already exists")
+	ErrRoleNotCreated       = errors.New("role not created")
+	ErrPersonNotFound       = errors.New("person not found")
+	ErrGraphChanged         = errors.New("roles changed concurrently")
+	ErrUnresponsiveDatabase = errors.New("error occurred during writing to roles tables")
+)
+
+type RoleRepository interface {
+	// SyncPermissions stores the given permissions, updating descriptions
+	// of existing ones
+	SyncPermissions(ctx context.Context, permissions []Permission) error
+	ListPermissions(ctx context.Context) ([]Permission, error)
+	ReadPermissionsByName(ctx context.Context, names []string) ([]Permission, error)
+	// EnsureRole creates the role unless one with its name exists, and
+	// loads the stored one into role
+	EnsureRole(ctx context.Context, role *Role) error
+	// Create stores a role inheriting the roles with the given IDs
+	Create(ctx context.Context, role *Role, inherits []uint) error
+	ReadByID(ctx context.Context, id uint) (*Role, error)
+	ReadByNames(ctx context.Context, names []string) ([]Role, error)
+	ReadAll(ctx context.Context) ([]Role, error)
+	// Update saves the role's description and replaces its permissions and
+	// inherited roles, unless the graph version is no longer version
+	Update(ctx context.Context, role *Role, inherits []uint, version uint64) error
+	// GrantPermissions adds permissions to a role
+	GrantPermissions(ctx context.Context, roleID uint, permissions []Permission) error
+	// AddInheritance makes a role inherit another
+	AddInheritance(ctx context.Context, roleID, inheritedRoleID uint) error
+	Delete(ctx context.Context, id uint) error
+	ReadGraphVersion(ctx context.Context) (uint64, error)
+	// ReadGraph returns every role with its permissions and inherited roles,
+	// and the graph version they are at least as new as
+	ReadGraph(ctx context.Context) (uint64, []Role, error)
+	ReadByPersonID(ctx context.Context, personID uint) ([]Role, error)
+	AddAssignment(ctx context.Context, personID, roleID uint) error
+	ReplaceAssignments(ctx context.Context, personID uint, roleIDs []uint) error
+	ReadByOrganizationMember(ctx context.Context, organizationID, personID uint) ([]Role, error)
+	ReplaceOrganizationAssignments(ctx context.Context, organizationID, personID uint, roleIDs []uint) error
+	// DeleteOrganizationAssignments removes the roles of a member, or of
+	// every member if personID is 0
+	DeleteOrganizationAssignments(ctx context.Context, organizationID, personID uint) error
+	ReadByGroupID(ctx context.Context, groupID uint) ([]Role, error)
+	ReplaceGroupAssignments(ctx context.Context, groupID uint, roleIDs []uint) error
+	DeleteGroupAssignments(ctx context.Context, groupID uint) error
+	// ReadByGroupMember returns the roles granted to the groups a person is
+	// a member of
+	ReadByGroupMember(ctx context.Context, personID uint) ([]Role, error)
+	// ReadGroupMemberIDs returns the IDs of the members of a group
+	ReadGroupMemberIDs(ctx context.Context, groupID uint) ([]uint, error)
+	// PersonExists reports whether the person exists, and is a member of
+	// the organization in ctx if there is one
+	PersonExists(ctx context.Context, personID uint) (bool, error)
+	// MigrateLegacyRoles turns the role column persons had before roles
+	// were entities into assignments, then drops the column
+	MigrateLegacyRoles(ctx context.Context) error
+}
+
+type roleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) RoleRepository {
+	return &roleRepository{db: db}
+}
+
+func (r *roleRepository) SyncPermissions(ctx context.Context, permissions []Permission) error {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description"}),
+		}).
+		Create(&permissions).
+		Error
+	if err != nil {
+		return ErrUnresponsiveDatabase
+	}
+	return nil
+}
+
+func (r *roleRepository) ListPermissions(ctx context.Context) ([]Permission, error) {
+	var permissions []Permission
+	if err := r.db.WithContext(ctx).Order("name").Find(&permissions).Error; err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return permissions, nil
+}
+
+func (r *roleRepository) ReadPermissionsByName(ctx context.Context, names []string) ([]Permission, error) {
+	var permissions []Permission
+	if len(names) == 0 {
+		return permissions, nil
+	}
+	if err := r.db.WithContext(ctx).Where("name IN ?", names).Find(&permissions).Error; err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return permissions, nil
+}
+
+func (r *roleRepository) EnsureRole(ctx context.Context, role *Role) error {
+	err := r.db.WithContext(ctx).
+		Where(Role{Name: role.Name}).
+		Attrs(Role{Description: role.Description, Builtin: role.Builtin}).
+		FirstOrCreate(role).
+		Error
+	if err != nil {
+		return ErrRoleNotCreated
+	}
+	return nil
+}
+
+func (r *roleRepository) Create(ctx context.Context, role *Role, inherits []uint) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(role).Error; err != nil {
+			return err
+		}
+		if err := replaceInheritances(tx, role.ID, inherits); err != nil {
+			return err
+		}
+		return bumpGraphVersion(tx)
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" &&
+			strings.Contains(pgErr.ConstraintName, "name") {
+			return ErrRoleAlreadyExists
+		}
+		return ErrRoleNotCreated
+	}
+	return nil
+}
+
+func (r *roleRepository) ReadByID(ctx context.Context, id uint) (*Role, error) {
+	var role Role
+	err := r.db.WithContext(ctx).
+		Preload("Permissions", func(db *gorm.DB) *gorm.DB { return db.Order("name") }).
+		First(&role, id).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	roles := []Role{role}
+	if err := loadInherits(r.db.WithContext(ctx), roles); err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &roles[0], nil
+}
+
+func (r *roleRepository) ReadByNames(ctx context.Context, names []string) ([]Role, error) {
+	var roles []Role
+	if len(names) == 0 {
+		return roles, nil
+	}
+	if err := r.db.WithContext(ctx).Where("name IN ?", names).Find(&roles).Error; err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) ReadAll(ctx context.Context) ([]Role, error) {
+	var roles []Role
+	err := r.db.WithContext(ctx).
+		Preload("Permissions", func(db *gorm.DB) *gorm.DB { return db.Order("name") }).
+		Order("name").
+		Find(&roles).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	if err := loadInherits(r.db.WithContext(ctx), roles); err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) Update(ctx context.Context, role *Role, inherits []uint, version uint64) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// claiming the next version first serializes updates, so that the
+		// graph the caller checked for cycles is the one being changed
+		res := tx.Model(&GraphVersion{}).
+			Where("id = ? AND version = ?", graphVersionID, version).
+			Update("version", gorm.Expr("version + 1"))
+		if res.Error != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if res.RowsAffected == 0 {
+			return ErrGraphChanged
+		}
+		if err := tx.Model(role).Update("description", role.Description).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if err := tx.Model(role).Association("Permissions").Replace(role.Permissions); err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if err := replaceInheritances(tx, role.ID, inherits); err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		return nil
+	})
+}
+
+func (r *roleRepository) GrantPermissions(ctx context.Context, roleID uint, permissions []Permission) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		role := Role{Model: gorm.Model{ID: roleID}}
+		if err := tx.Model(&role).Association("Permissions").Append(permissions); err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if err := bumpGraphVersion(tx); err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		return nil
+	})
+}
+
+func (r *roleRepository) AddInheritance(ctx context.Context, roleID, inheritedRoleID uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&Inheritance{RoleID: roleID, InheritedRoleID: inheritedRoleID}).
+			Error
+		if err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if err := bumpGraphVersion(tx); err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		return nil
+	})
+}
+
+func (r *roleRepository) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		role := Role{Model: gorm.Model{ID: id}}
+		if err := tx.Model(&role).Association("Permissions").Clear(); err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if err := tx.Where("role_id = ?", id).Delete(&Assignment{}).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if err := tx.Where("role_id = ?", id).Delete(&OrganizationAssignment{}).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if err := tx.Where("role_id = ?", id).Delete(&GroupAssignment{}).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		err := tx.Where("role_id = ? OR inherited_role_id = ?", id, id).Delete(&Inheritance{}).Error
+		if err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		res := tx.Unscoped().Delete(&Role{}, id)
+		if res.Error != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if res.RowsAffected == 0 {
+			return ErrRoleNotFound
+		}
+		if err := bumpGraphVersion(tx); err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		return nil
+	})
+}
+
+func (r *roleRepository) ReadGraphVersion(ctx context.Context) (uint64, error) {
+	var versions []GraphVersion
+	err := r.db.WithContext(ctx).Where("id = ?", graphVersionID).Limit(1).Find(&versions).Error
+	if err != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	return versions[0].Version, nil
+}
+
+func (r *roleRepository) ReadGraph(ctx context.Context) (uint64, []Role, error) {
+	// read the version first: roles changed in between are only newer
+	version, err := r.ReadGraphVersion(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	roles, err := r.ReadAll(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, roles, nil
+}
+
+func (r *roleRepository) ReadByPersonID(ctx context.Context, personID uint) ([]Role, error) {
+	var roles []Role
+	err := r.db.WithContext(ctx).
+		Joins("JOIN person_roles ON person_roles.role_id = roles.id").
+		Where("person_roles.person_id = ?", personID).
+		Order("roles.name").
+		Find(&roles).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) AddAssignment(ctx context.Context, personID, roleID uint) error {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Assignment{PersonID: personID, RoleID: roleID}).
+		Error
+	if err != nil {
+		return ErrUnresponsiveDatabase
+	}
+	return nil
+}
+
+func (r *roleRepository) ReplaceAssignments(ctx context.Context, personID uint, roleIDs []uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("person_id = ?", personID).Delete(&Assignment{}).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if len(roleIDs) == 0 {
+			return nil
+		}
+		assignments := make([]Assignment, 0, len(roleIDs))
+		for _, roleID := range roleIDs {
+			assignments = append(assignments, Assignment{PersonID: personID, RoleID: roleID})
+		}
+		if err := tx.Create(&assignments).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		return nil
+	})
+}
+
+func (r *roleRepository) ReadByOrganizationMember(ctx context.Context, organizationID, personID uint) ([]Role, error) {
+	var roles []Role
+	err := r.db.WithContext(ctx).
+		Joins("JOIN organization_person_roles ON organization_person_roles.role_id = roles.id").
+		Where("organization_person_roles.organization_id = ?", organizationID).
+		Where("organization_person_roles.person_id = ?", personID).
+		Order("roles.name").
+		Find(&roles).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) ReplaceOrganizationAssignments(ctx context.Context, organizationID, personID uint, roleIDs []uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Where("organization_id = ? AND person_id = ?", organizationID, personID).
+			Delete(&OrganizationAssignment{}).
+			Error
+		if err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if len(roleIDs) == 0 {
+			return nil
+		}
+		assignments := make([]OrganizationAssignment, 0, len(roleIDs))
+		for _, roleID := range roleIDs {
+			assignments = append(assignments, OrganizationAssignment{
+				OrganizationID: organizationID,
+				PersonID:       personID,
+				RoleID:         roleID,
+			})
+		}
+		if err := tx.Create(&assignments).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		return nil
+	})
+}
+
+func (r *roleRepository) DeleteOrganizationAssignments(ctx context.Context, organizationID, personID uint) error {
+	query := r.db.WithContext(ctx).Where("organization_id = ?", organizationID)
+	if personID != 0 {
+		query = query.Where("person_id = ?", personID)
+	}
+	if err := query.Delete(&OrganizationAssignment{}).Error; err != nil {
+		return ErrUnresponsiveDatabase
+	}
+	return nil
+}
+
+func (r *roleRepository) ReadByGroupID(ctx context.Context, groupID uint) ([]Role, error) {
+	var roles []Role
+	err := r.db.WithContext(ctx).
+		Joins("JOIN group_roles ON group_roles.role_id = roles.id").
+		Where("group_roles.group_id = ?", groupID).
+		Order("roles.name").
+		Find(&roles).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) ReplaceGroupAssignments(ctx context.Context, groupID uint, roleIDs []uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", groupID).Delete(&GroupAssignment{}).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if len(roleIDs) == 0 {
+			return nil
+		}
+		assignments := make([]GroupAssignment, 0, len(roleIDs))
+		for _, roleID := range roleIDs {
+			assignments = append(assignments, GroupAssignment{GroupID: groupID, RoleID: roleID})
+		}
+		if err := tx.Create(&assignments).Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		return nil
+	})
+}
+
+func (r *roleRepository) DeleteGroupAssignments(ctx context.Context, groupID uint) error {
+	if err := r.db.WithContext(ctx).Where("group_id = ?", groupID).Delete(&GroupAssignment{}).Error; err != nil {
+		return ErrUnresponsiveDatabase
+	}
+	return nil
+}
+
+func (r *roleRepository) ReadByGroupMember(ctx context.Context, personID uint) ([]Role, error) {
+	// a role granted by several of the person's groups is read once
+	granted := r.db.
+		Table("group_roles").
+		Select("group_roles.role_id").
+		Joins("JOIN group_members ON group_members.group_id = group_roles.group_id").
+		Where("group_members.person_id = ?", personID)
+	var roles []Role
+	err := r.db.WithContext(ctx).
+		Where("roles.id IN (?)", granted).
+		Order("roles.name").
+		Find(&roles).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) ReadGroupMemberIDs(ctx context.Context, groupID uint) ([]uint, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).
+		Table("group_members").
+		Where("group_id = ?", groupID).
+		Pluck("person_id", &ids).
+		Error
+	if err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return ids, nil
+}
+
+func (r *roleRepository) PersonExists(ctx context.Context, personID uint) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Table("persons").
+		Scopes(tenant.Members(ctx, "id")).
+		Where("id = ?", personID).
+		Where("deleted_at IS NULL").
+		Count(&count).
+		Error
+	if err != nil {
+		return false, ErrUnresponsiveDatabase
+	}
+	return count > 0, nil
+}
+
+func (r *roleRepository) MigrateLegacyRoles(ctx context.Context) error {
+	if !r.db.Migrator().HasColumn("persons", "role") {
+		return nil
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`
+			INSERT INTO person_roles (person_id, role_id, created_at)
+			SELECT persons.id, roles.id, NOW()
+			FROM persons
+			JOIN roles ON roles.name = COALESCE(NULLIF(persons.role, ''), ?)
+			ON CONFLICT DO NOTHING`, UserRole).
+			Error
+		if err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		if err := tx.Exec("ALTER TABLE persons DROP COLUMN role").Error; err != nil {
+			return ErrUnresponsiveDatabase
+		}
+		return nil
+	})
+}
+
+// graphVersionID is the ID of the single role_graph_versions row.
+const graphVersionID = 1
+
+// bumpGraphVersion increments the role graph version, creating its row on
+// first use.
+func bumpGraphVersion(tx *gorm.DB) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.Assignments(map[string]any{"version": gorm.Expr("role_graph_versions.version + 1")}),
+	}).
+		Create(&GraphVersion{ID: graphVersionID, Version: 1}).
+		Error
+}
+
+func replaceInheritances(tx *gorm.DB, roleID uint, inherits []uint) error {
+	if err := tx.Where("role_id = ?", roleID).Delete(&Inheritance{}).Error; err != nil {
+		return err
+	}
+	if len(inherits) == 0 {
+		return nil
+	}
+	rows := make([]Inheritance, 0, len(inherits))
+	for _, inheritedRoleID := range inherits {
+		rows = append(rows, Inheritance{RoleID: roleID, InheritedRoleID: inheritedRoleID})
+	}
+	return tx.Create(&rows).Error
+}
+
+// loadInherits sets the names of the roles each of roles inherits.
+func loadInherits(db *gorm.DB, roles []Role) error {
+	if len(roles) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(roles))
+	for _, role := range roles {
+		ids = append(ids, role.ID)
+	}
+	var rows []struct {
+		RoleID uint
+		Name   string
+	}
+	err := db.Table("role_inheritances").
+		Select("role_inheritances.role_id, roles.name").
+		Joins("JOIN roles ON roles.id = role_inheritances.inherited_role_id").
+		Where("role_inheritances.role_id IN ?", ids).
+		Order("roles.name").
+		Scan(&rows).
+		Error
+	if err != nil {
+		return err
+	}
+	names := make(map[uint][]string, len(roles))
+	for _, row := range rows {
+		names[row.RoleID] = append(names[row.RoleID], row.Name)
+	}
+	for i := range roles {
+		roles[i].Inherits = names[roles[i].ID]
+		if roles[i].Inherits == nil {
+			roles[i].Inherits = []string{}
+		}
+	}
+	return nil
+}
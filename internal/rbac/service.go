@@ -0,0 +1,607 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/tenant"
+)
+
+var (
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrUnknownRole       = errors.New("unknown role")
+	ErrBuiltinRole       = errors.New("built-in roles cannot be deleted")
+	ErrInvalidRoleName   = errors.New("role names are 1 to 64 lowercase letters, digits, '-' or '_'")
+	ErrRoleCycle         = errors.New("a role cannot inherit itself, directly or through other roles")
+)
+
+// updateAttempts bounds the retries of a role update racing other changes.
+const updateAttempts = 3
+
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// TokenVersioner invalidates the access tokens of a person, so that tokens
+// carrying their old roles stop being accepted.
+type TokenVersioner interface {
+	IncrementTokenVersion(ctx context.Context, id uint) error
+}
+
+// SessionRevoker ends every session of a person.
+type SessionRevoker interface {
+	RevokeSessions(ctx context.Context, personID uint) error
+}
+
+type RBACService interface {
+	// Seed stores the permission catalog and the built-in roles, and moves
+	// persons from the former role column to role assignments
+	Seed(ctx context.Context) error
+	ListPermissions(ctx context.Context) ([]Permission, error)
+	ListRoles(ctx context.Context) ([]Role, error)
+	ReadRole(ctx context.Context, id uint) (*Role, error)
+	CreateRole(ctx context.Context, name, description string, permissions, inherits []string) (*Role, error)
+	// UpdateRole replaces the description, permissions and inherited roles
+	// of a role
+	UpdateRole(ctx context.Context, id uint, description string, permissions, inherits []string) (*Role, error)
+	DeleteRole(ctx context.Context, id uint) error
+	// PersonRoles returns the roles assigned to the person directly, not
+	// through groups
+	PersonRoles(ctx context.Context, personID uint) ([]Role, error)
+	// SetPersonRoles replaces the roles of a person, ending their sessions if
+	// they lose a role
+	SetPersonRoles(ctx context.Context, personID uint, roles []string) ([]Role, error)
+	// AssignDefaultRoles gives a new person the user role
+	AssignDefaultRoles(ctx context.Context, personID uint) error
+	// OrganizationRoles returns the roles of a member within an organization
+	OrganizationRoles(ctx context.Context, organizationID, personID uint) ([]Role, error)
+	// SetOrganizationRoles replaces the roles of a member within an
+	// organization, ending their sessions if they lose a role
+	SetOrganizationRoles(ctx context.Context, organizationID, personID uint, roles []string) ([]Role, error)
+	// RemoveOrganizationRoles removes the roles of a member within an
+	// organization, or of every member if personID is 0
+	RemoveOrganizationRoles(ctx context.Context, organizationID, personID uint) error
+	// GroupRoles returns the roles granted to the members of a group
+	GroupRoles(ctx context.Context, groupID uint) ([]Role, error)
+	// SetGroupRoles replaces the roles granted to a group, ending the
+	// sessions of its members if it loses a role
+	SetGroupRoles(ctx context.Context, groupID uint, roles []string) ([]Role, error)
+	// RemoveGroupRoles removes the roles of a deleted group, ending the
+	// sessions of memberIDs, its former members, if it granted any
+	RemoveGroupRoles(ctx context.Context, groupID uint, memberIDs []uint) error
+	// LeaveGroup ends the sessions of a person removed from a group, if
+	// the group grants roles
+	LeaveGroup(ctx context.Context, groupID, personID uint) error
+	// RoleNames returns the names of the person's roles, both their own and
+	// those of their groups, including their roles in organizationID unless
+	// it is 0
+	RoleNames(ctx context.Context, personID, organizationID uint) ([]string, error)
+	// PersonPermissions returns the effective permissions of a person: those
+	// of their roles, of their groups' roles and of the roles these
+	// inherit. In an organization, their roles there add the permissions in
+	// tenantPermissions.
+	PersonPermissions(ctx context.Context, personID uint) ([]string, error)
+	// PermissionNames returns the effective permissions of a person acting
+	// in organizationID, or in none if it is 0
+	PermissionNames(ctx context.Context, personID, organizationID uint) ([]string, error)
+	HasPermission(ctx context.Context, personID uint, permission string) (bool, error)
+	// HasRole reports whether the person has role, or a role inheriting it,
+	// outside any organization; roles held in an organization never count
+	HasRole(ctx context.Context, personID uint, role string) (bool, error)
+}
+
+type rbacService struct {
+	repo     RoleRepository
+	versions TokenVersioner
+	sessions SessionRevoker
+	logger   *zap.Logger
+
+	// graph caches effective permissions until the role graph version moves
+	mu    sync.Mutex
+	graph *roleGraph
+}
+
+func NewRBACService(repo RoleRepository, versions TokenVersioner, sessions SessionRevoker, logger *zap.Logger) RBACService {
+	return &rbacService{
+		repo:     repo,
+		versions: versions,
+		sessions: sessions,
+		logger:   logger,
+	}
+}
+
+func (s *rbacService) Seed(ctx context.Context) error {
+	if err := s.repo.SyncPermissions(ctx, catalog); err != nil {
+		s.logger.Error("failed to seed permissions", zap.Error(err))
+		return err
+	}
+	all, err := s.repo.ListPermissions(ctx)
+	if err != nil {
+		return err
+	}
+
+	admin := &Role{Name: AdminRole, Description: "Full access", Builtin: true}
+	if err := s.repo.EnsureRole(ctx, admin); err != nil {
+		s.logger.Error("failed to seed role", zap.String("role", AdminRole), zap.Error(err))
+		return err
+	}
+	// admin keeps every permission, including ones added since the last start
+	if err := s.repo.GrantPermissions(ctx, admin.ID, all); err != nil {
+		s.logger.Error("failed to grant permissions to role", zap.String("role", AdminRole), zap.Error(err))
+		return err
+	}
+
+	user := &Role{Name: UserRole, Description: "Manages their own account", Builtin: true}
+	if err := s.repo.EnsureRole(ctx, user); err != nil {
+		s.logger.Error("failed to seed role", zap.String("role", UserRole), zap.Error(err))
+		return err
+	}
+
+	// admins pass every check for at least the user role
+	graph, err := s.loadGraph(ctx)
+	if err != nil {
+		return err
+	}
+	if graph.createsCycle(AdminRole, []string{UserRole}) {
+		s.logger.Warn("not letting admin inherit user, user inherits admin", zap.String("role", AdminRole))
+	} else if err := s.repo.AddInheritance(ctx, admin.ID, user.ID); err != nil {
+		s.logger.Error("failed to seed role inheritance", zap.String("role", AdminRole), zap.Error(err))
+		return err
+	}
+
+	if err := s.repo.MigrateLegacyRoles(ctx); err != nil {
+		s.logger.Error("failed to migrate person roles", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *rbacService) ListPermissions(ctx context.Context) ([]Permission, error) {
+	return s.repo.ListPermissions(ctx)
+}
+
+func (s *rbacService) ListRoles(ctx context.Context) ([]Role, error) {
+	return s.repo.ReadAll(ctx)
+}
+
+func (s *rbacService) ReadRole(ctx context.Context, id uint) (*Role, error) {
+	return s.repo.ReadByID(ctx, id)
+}
+
+func (s *rbacService) CreateRole(ctx context.Context, name, description string, permissions, inherits []string) (*Role, error) {
+	if !roleNamePattern.MatchString(name) {
+		return nil, ErrInvalidRoleName
+	}
+	granted, err := s.resolvePermissions(ctx, permissions)
+	if err != nil {
+		return nil, err
+	}
+	// a new role cannot be inherited yet, so it cannot close a cycle
+	inheritIDs, err := s.resolveRoles(ctx, inherits)
+	if err != nil {
+		return nil, err
+	}
+
+	role := &Role{Name: name, Description: description, Permissions: granted}
+	if err := s.repo.Create(ctx, role, inheritIDs); err != nil {
+		s.logger.Error("failed to create role", zap.String("role", name), zap.Error(err))
+		return nil, err
+	}
+	return s.repo.ReadByID(ctx, role.ID)
+}
+
+func (s *rbacService) UpdateRole(ctx context.Context, id uint, description string, permissions, inherits []string) (*Role, error) {
+	role, err := s.repo.ReadByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	granted, err := s.resolvePermissions(ctx, permissions)
+	if err != nil {
+		return nil, err
+	}
+	inheritIDs, err := s.resolveRoles(ctx, inherits)
+	if err != nil {
+		return nil, err
+	}
+	role.Description = description
+	role.Permissions = granted
+
+	for attempt := 1; ; attempt++ {
+		graph, err := s.loadGraph(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if graph.createsCycle(role.Name, inherits) {
+			return nil, ErrRoleCycle
+		}
+		// the update only applies to the graph checked above
+		err = s.repo.Update(ctx, role, inheritIDs, graph.version)
+		if errors.Is(err, ErrGraphChanged) && attempt < updateAttempts {
+			continue
+		}
+		if err != nil {
+			s.logger.Error("failed to update role", zap.Uint("id", id), zap.Error(err))
+			return nil, err
+		}
+		return s.repo.ReadByID(ctx, id)
+	}
+}
+
+func (s *rbacService) DeleteRole(ctx context.Context, id uint) error {
+	role, err := s.repo.ReadByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if role.Builtin {
+		return ErrBuiltinRole
+	}
+	if err := s.repo.Delete(ctx, id); err != nil {
+		s.logger.Error("failed to delete role", zap.Uint("id", id), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *rbacService) PersonRoles(ctx context.Context, personID uint) ([]Role, error) {
+	if err := s.checkPerson(ctx, personID); err != nil {
+		return nil, err
+	}
+	return s.repo.ReadByPersonID(ctx, personID)
+}
+
+func (s *rbacService) SetPersonRoles(ctx context.Context, personID uint, names []string) ([]Role, error) {
+	if err := s.checkPerson(ctx, personID); err != nil {
+		return nil, err
+	}
+	ids, err := s.resolveRoles(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	before, err := s.repo.ReadByPersonID(ctx, personID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceAssignments(ctx, personID, ids); err != nil {
+		s.logger.Error("failed to assign roles", zap.Uint("personID", personID), zap.Error(err))
+		return nil, err
+	}
+	if lost(before, names) {
+		if err := s.endSessions(ctx, personID); err != nil {
+			return nil, err
+		}
+	}
+	return s.repo.ReadByPersonID(ctx, personID)
+}
+
+func (s *rbacService) OrganizationRoles(ctx context.Context, organizationID, personID uint) ([]Role, error) {
+	return s.repo.ReadByOrganizationMember(ctx, organizationID, personID)
+}
+
+func (s *rbacService) SetOrganizationRoles(ctx context.Context, organizationID, personID uint, names []string) ([]Role, error) {
+	ids, err := s.resolveRoles(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	before, err := s.repo.ReadByOrganizationMember(ctx, organizationID, personID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceOrganizationAssignments(ctx, organizationID, personID, ids); err != nil {
+		s.logger.Error("failed to assign organization roles",
+			zap.Uint("organizationID", organizationID), zap.Uint("personID", personID), zap.Error(err))
+		return nil, err
+	}
+	if lost(before, names) {
+		if err := s.endSessions(ctx, personID); err != nil {
+			return nil, err
+		}
+	}
+	return s.repo.ReadByOrganizationMember(ctx, organizationID, personID)
+}
+
+func (s *rbacService) RemoveOrganizationRoles(ctx context.Context, organizationID, personID uint) error {
+	if err := s.repo.DeleteOrganizationAssignments(ctx, organizationID, personID); err != nil {
+		s.logger.Error("failed to remove organization roles",
+			zap.Uint("organizationID", organizationID), zap.Uint("personID", personID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *rbacService) GroupRoles(ctx context.Context, groupID uint) ([]Role, error) {
+	return s.repo.ReadByGroupID(ctx, groupID)
+}
+
+func (s *rbacService) SetGroupRoles(ctx context.Context, groupID uint, names []string) ([]Role, error) {
+	ids, err := s.resolveRoles(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	before, err := s.repo.ReadByGroupID(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceGroupAssignments(ctx, groupID, ids); err != nil {
+		s.logger.Error("failed to assign group roles", zap.Uint("groupID", groupID), zap.Error(err))
+		return nil, err
+	}
+	if lost(before, names) {
+		memberIDs, err := s.repo.ReadGroupMemberIDs(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+		for _, personID := range memberIDs {
+			if err := s.endSessions(ctx, personID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s.repo.ReadByGroupID(ctx, groupID)
+}
+
+func (s *rbacService) RemoveGroupRoles(ctx context.Context, groupID uint, memberIDs []uint) error {
+	before, err := s.repo.ReadByGroupID(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteGroupAssignments(ctx, groupID); err != nil {
+		s.logger.Error("failed to remove group roles", zap.Uint("groupID", groupID), zap.Error(err))
+		return err
+	}
+	if len(before) == 0 {
+		return nil
+	}
+	for _, personID := range memberIDs {
+		if err := s.endSessions(ctx, personID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *rbacService) LeaveGroup(ctx context.Context, groupID, personID uint) error {
+	roles, err := s.repo.ReadByGroupID(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+	return s.endSessions(ctx, personID)
+}
+
+func (s *rbacService) AssignDefaultRoles(ctx context.Context, personID uint) error {
+	roles, err := s.repo.ReadByNames(ctx, []string{UserRole})
+	if err != nil {
+		return err
+	}
+	if len(roles) == 0 {
+		return ErrRoleNotFound
+	}
+	if err := s.repo.AddAssignment(ctx, personID, roles[0].ID); err != nil {
+		s.logger.Error("failed to assign default role", zap.Uint("personID", personID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *rbacService) RoleNames(ctx context.Context, personID, organizationID uint) ([]string, error) {
+	global, member, err := s.roleNames(ctx, personID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	return unique(append(global, member...)), nil
+}
+
+func (s *rbacService) PersonPermissions(ctx context.Context, personID uint) ([]string, error) {
+	if err := s.checkPerson(ctx, personID); err != nil {
+		return nil, err
+	}
+	organizationID, _ := tenant.OrganizationFrom(ctx)
+	return s.PermissionNames(ctx, personID, organizationID)
+}
+
+func (s *rbacService) PermissionNames(ctx context.Context, personID, organizationID uint) ([]string, error) {
+	global, member, err := s.roleNames(ctx, personID, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := s.currentGraph(ctx)
+	if err != nil {
+		return nil, err
+	}
+	effective := graph.effective(global)
+	for permission := range graph.effective(member) {
+		if tenantPermissions[permission] {
+			effective[permission] = true
+		}
+	}
+	permissions := make([]string, 0, len(effective))
+	for permission := range effective {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+func (s *rbacService) HasPermission(ctx context.Context, personID uint, permission string) (bool, error) {
+	global, member, graph, err := s.grants(ctx, personID)
+	if err != nil {
+		return false, err
+	}
+	if graph.allows(global, permission) {
+		return true, nil
+	}
+	return tenantPermissions[permission] && graph.allows(member, permission), nil
+}
+
+func (s *rbacService) HasRole(ctx context.Context, personID uint, role string) (bool, error) {
+	global, _, graph, err := s.grants(ctx, personID)
+	if err != nil {
+		return false, err
+	}
+	return graph.atLeast(global, role), nil
+}
+
+// grants returns the person's roles, their roles in the organization ctx
+// acts in, and the role graph to evaluate them with.
+func (s *rbacService) grants(ctx context.Context, personID uint) ([]string, []string, *roleGraph, error) {
+	organizationID, _ := tenant.OrganizationFrom(ctx)
+	global, member, err := s.roleNames(ctx, personID, organizationID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	graph, err := s.currentGraph(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return global, member, graph, nil
+}
+
+// roleNames returns the names of the person's roles, their own and those
+// of their groups, and of their roles in the organization unless
+// organizationID is 0.
+func (s *rbacService) roleNames(ctx context.Context, personID, organizationID uint) ([]string, []string, error) {
+	roles, err := s.repo.ReadByPersonID(ctx, personID)
+	if err != nil {
+		return nil, nil, err
+	}
+	groupRoles, err := s.repo.ReadByGroupMember(ctx, personID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var memberRoles []Role
+	if organizationID != 0 {
+		memberRoles, err = s.repo.ReadByOrganizationMember(ctx, organizationID, personID)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return unique(append(names(roles), names(groupRoles)...)), names(memberRoles), nil
+}
+
+// endSessions makes the person log in again, so that no token carries a
+// role they lost.
+func (s *rbacService) endSessions(ctx context.Context, personID uint) error {
+	if err := s.versions.IncrementTokenVersion(ctx, personID); err != nil {
+		s.logger.Error("failed to increment token version", zap.Uint("personID", personID), zap.Error(err))
+		return err
+	}
+	if err := s.sessions.RevokeSessions(ctx, personID); err != nil {
+		s.logger.Error("failed to revoke sessions", zap.Uint("personID", personID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// currentGraph returns the cached role graph, reloading it once another
+// change to roles moved the graph version.
+func (s *rbacService) currentGraph(ctx context.Context) (*roleGraph, error) {
+	version, err := s.repo.ReadGraphVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	graph := s.graph
+	s.mu.Unlock()
+	if graph != nil && graph.version == version {
+		return graph, nil
+	}
+	return s.loadGraph(ctx)
+}
+
+// loadGraph reads the role graph and caches it.
+func (s *rbacService) loadGraph(ctx context.Context) (*roleGraph, error) {
+	version, roles, err := s.repo.ReadGraph(ctx)
+	if err != nil {
+		s.logger.Error("failed to read roles", zap.Error(err))
+		return nil, err
+	}
+	graph := newRoleGraph(version, roles)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a slower concurrent load must not replace a newer graph
+	if s.graph == nil || s.graph.version <= graph.version {
+		s.graph = graph
+	}
+	return graph, nil
+}
+
+// resolveRoles looks up the IDs of the named roles, failing if any does
+// not exist.
+func (s *rbacService) resolveRoles(ctx context.Context, names []string) ([]uint, error) {
+	roles, err := s.repo.ReadByNames(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) != len(unique(names)) {
+		return nil, ErrUnknownRole
+	}
+	ids := make([]uint, 0, len(roles))
+	for _, role := range roles {
+		ids = append(ids, role.ID)
+	}
+	return ids, nil
+}
+
+// resolvePermissions looks up the named permissions, failing if any is not
+// in the catalog.
+func (s *rbacService) resolvePermissions(ctx context.Context, names []string) ([]Permission, error) {
+	permissions, err := s.repo.ReadPermissionsByName(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	if len(permissions) != len(unique(names)) {
+		return nil, ErrUnknownPermission
+	}
+	return permissions, nil
+}
+
+func (s *rbacService) checkPerson(ctx context.Context, personID uint) error {
+	exists, err := s.repo.PersonExists(ctx, personID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrPersonNotFound
+	}
+	return nil
+}
+
+// lost reports whether any of before is missing from after.
+func lost(before []Role, after []string) bool {
+	kept := make(map[string]bool, len(after))
+	for _, name := range after {
+		kept[name] = true
+	}
+	for _, role := range before {
+		if !kept[role.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+func names(roles []Role) []string {
+	out := make([]string, 0, len(roles))
+	for _, role := range roles {
+		out = append(out, role.Name)
+	}
+	return out
+}
+
+func unique(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+package registration
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	tollbooth "github.com/didip/tollbooth/v7"
+	limiter "github.com/didip/tollbooth/v7/limiter"
+	tollbooth_gin "github.com/didip/tollbooth_gin"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+)
+
+// RegisterRequest is the payload for signing up.
+// @Description payload to register oneself
+// @Property email       body string true  "unique email address"
+// @Property password    body string true  "password (min 8, letters, digits and a special character)"
+// @Property invite_code body string false "invite code, when registration requires one"
+type RegisterRequest struct {
+	Email      string `json:"email" binding:"required,email"`
+	Password   string `json:"password" binding:"required,min=8"`
+	InviteCode string `json:"invite_code"`
+}
+
+// InviteIDRequest represents a URI invite id parameter.
+type InviteIDRequest struct {
+	ID uint `uri:"id" binding:"required,min=1"`
+}
+
+// InviteResponse returns a new invite with its code.
+// @Description the code is only shown once
+// @Property id         body integer true "invite ID"
+// @Property code       body string  true "invite code to hand out"
+// @Property expires_at body string  true "invite expiry"
+type InviteResponse struct {
+	ID        uint      `json:"id"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RegistrationHandler handles self-service registration and invites.
+type RegistrationHandler struct {
+	router  *gin.RouterGroup
+	service RegistrationService
+	logger  *zap.Logger
+}
+
+// NewRegistrationHandler registers the public, rate limited registration
+// endpoint on the given router group. The invite endpoints are mounted by
+// the caller on an admin group.
+func NewRegistrationHandler(router *gin.RouterGroup, service RegistrationService, logger *zap.Logger) *RegistrationHandler {
+	h := &RegistrationHandler{router: router, service: service, logger: logger}
+
+	// 5 requests per minute limiter
+	registerLimiter := tollbooth.NewLimiter(5, &limiter.ExpirableOptions{
+		DefaultExpirationTTL: time.Minute,
+	})
+
+	h.router.POST(
+		"/auth/register",
+		tollbooth_gin.LimitHandler(registerLimiter),
+		h.Register,
+	)
+	return h
+}
+
+// Register godoc
+// @Summary      Register
+// @Description  Create an account with the user role, if self-service registration is enabled
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      RegisterRequest  true  "Registration payload"
+// @Success      201      {object}  person.IDResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      403      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /auth/register [post]
+func (h *RegistrationHandler) Register(c *gin.Context) {
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Warn("invalid register payload", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email or password format"})
+		return
+	}
+	p, err := h.service.Register(c.Request.Context(), req.Email, req.Password, req.InviteCode)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, person.IDResponse{ID: p.ID})
+	case errors.Is(err, ErrRegistrationClosed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "registration is disabled"})
+	case errors.Is(err, ErrEmailDomainNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "email domain not allowed"})
+	case errors.Is(err, ErrInviteRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": "invite code required"})
+	case errors.Is(err, ErrInvalidInvite):
+		c.JSON(http.StatusForbidden, gin.H{"error": "invalid, used or expired invite code"})
+	case errors.Is(err, person.ErrInvalidEmailFormat),
+		errors.Is(err, person.ErrPasswordShouldBeNCharacters),
+		errors.Is(err, person.ErrPasswordNotAlphanumeric),
+		errors.Is(err, person.ErrPasswordDoesNotHaveSpecialCharacter):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, person.ErrEmailAlreadyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "email already registered"})
+	default:
+		h.logger.Error("Register service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not register"})
+	}
+}
+
+// CreateInvite godoc
+// @Summary      Create Invite
+// @Description  Create a single use registration invite
+// @Tags         invites
+// @Produce      json
+// @Success      201      {object}  InviteResponse
+// @Failure      500      {object}  map[string]string
+// @Router       /invites [post]
+func (h *RegistrationHandler) CreateInvite(c *gin.Context) {
+	invite, code, err := h.service.CreateInvite(c.Request.Context())
+	if err != nil {
+		h.logger.Error("CreateInvite service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create invite"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, InviteResponse{ID: invite.ID, Code: code, ExpiresAt: invite.ExpiresAt})
+}
+
+// DeleteInvite godoc
+// @Summary      Delete Invite
+// @Description  Withdraw a registration invite
+// @Tags         invites
+// @Param        id   path      int  true  "Invite ID"
+// @Success      204
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /invites/{id} [delete]
+func (h *RegistrationHandler) DeleteInvite(c *gin.Context) {
+	var uri InviteIDRequest
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing id"})
+		return
+	}
+	err := h.service.DeleteInvite(c.Request.Context(), uri.ID)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrInviteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "invite not found"})
+	default:
+		h.logger.Error("DeleteInvite service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete invite"})
+	}
+}
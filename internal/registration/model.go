@@ -0,0 +1,21 @@
+package registration
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Invite lets one person register while registration requires an invite.
+// swagger:model InviteResponse
+// @Description registration invite
+// @Property ID         body integer true  "unique identifier"
+// @Property expires_at body string  true  "invite expiry"
+// @Property used_at    body string  false "when the invite was redeemed"
+type Invite struct {
+	gorm.Model
+	// Code is the SHA-256 hash of the invite code (hidden from JSON)
+	Code      string     `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+}
@@ -0,0 +1,80 @@
+package registration
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInviteNotFound       = errors.New("invite not found")
+	ErrInviteNotCreated     = errors.New("invite not created")
+	ErrUnresponsiveDatabase = errors.New("error occurred during writing to invites table")
+)
+
+type InviteRepository interface {
+	Create(ctx context.Context, invite *Invite) error
+	// Redeem marks an unused, unexpired invite as used, failing with
+	// ErrInviteNotFound otherwise
+	Redeem(ctx context.Context, code string, now time.Time) (*Invite, error)
+	// Release makes a redeemed invite usable again
+	Release(ctx context.Context, id uint) error
+	Delete(ctx context.Context, id uint) error
+}
+
+type inviteRepository struct {
+	db *gorm.DB
+}
+
+func NewInviteRepository(db *gorm.DB) InviteRepository {
+	return &inviteRepository{db: db}
+}
+
+func (r *inviteRepository) Create(ctx context.Context, invite *Invite) error {
+	if err := r.db.WithContext(ctx).Create(invite).Error; err != nil {
+		return ErrInviteNotCreated
+	}
+	return nil
+}
+
+func (r *inviteRepository) Redeem(ctx context.Context, code string, now time.Time) (*Invite, error) {
+	var invite Invite
+	res := r.db.WithContext(ctx).
+		Model(&invite).
+		Where("code = ? AND used_at IS NULL AND expires_at > ?", code, now).
+		Update("used_at", now)
+	if res.Error != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrInviteNotFound
+	}
+	if err := r.db.WithContext(ctx).Where("code = ?", code).First(&invite).Error; err != nil {
+		return nil, ErrUnresponsiveDatabase
+	}
+	return &invite, nil
+}
+
+func (r *inviteRepository) Release(ctx context.Context, id uint) error {
+	res := r.db.WithContext(ctx).
+		Model(&Invite{}).
+		Where("id = ?", id).
+		Update("used_at", nil)
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	return nil
+}
+
+func (r *inviteRepository) Delete(ctx context.Context, id uint) error {
+	res := r.db.WithContext(ctx).Unscoped().Delete(&Invite{}, id)
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	if res.RowsAffected == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package registration
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+)
+
+const inviteCodeBytes = 18
+
+var (
+	ErrRegistrationClosed     = errors.New("self-service registration is disabled")
+	ErrEmailDomainNotAllowed  = errors.New("email domain not allowed to register")
+	ErrInviteRequired         = errors.New("invite code required")
+	ErrInvalidInvite          = errors.New("invalid, used or expired invite code")
+	ErrGeneratingInviteFailed = errors.New("generating invite code failed")
+)
+
+// Settings decide who may register themselves.
+type Settings struct {
+	Enabled bool
+	// AllowedDomains limits registration to these email domains, if set
+	AllowedDomains []string
+	InviteRequired bool
+	InviteTTL      time.Duration
+}
+
+type RegistrationService interface {
+	Register(ctx context.Context, email, password, inviteCode string) (*person.Person, error)
+	// CreateInvite returns a new invite and its code; the code cannot be
+	// shown again
+	CreateInvite(ctx context.Context) (*Invite, string, error)
+	DeleteInvite(ctx context.Context, id uint) error
+}
+
+type registrationService struct {
+	inviteRepo    InviteRepository
+	personService person.PersonService
+	logger        *zap.Logger
+	settings      Settings
+}
+
+func NewRegistrationService(
+	inviteRepo InviteRepository,
+	personService person.PersonService,
+	logger *zap.Logger,
+	settings Settings,
+) RegistrationService {
+	return &registrationService{
+		inviteRepo:    inviteRepo,
+		personService: personService,
+		logger:        logger,
+		settings:      settings,
+	}
+}
+
+// Register creates a person with the user role, if the settings allow
+// this email and invite code.
+func (s *registrationService) Register(ctx context.Context, email, password, inviteCode string) (*person.Person, error) {
+	if !s.settings.Enabled {
+		return nil, ErrRegistrationClosed
+	}
+	if !s.domainAllowed(email) {
+		return nil, ErrEmailDomainNotAllowed
+	}
+
+	var invite *Invite
+	if s.settings.InviteRequired {
+		if inviteCode == "" {
+			return nil, ErrInviteRequired
+		}
+		var err error
+		invite, err = s.inviteRepo.Redeem(ctx, hashCode(inviteCode), time.Now())
+		if errors.Is(err, ErrInviteNotFound) {
+			return nil, ErrInvalidInvite
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	created, err := s.personService.CreatePerson(ctx, email, password)
+	if err != nil {
+		// a failed registration must not use up the invite
+		if invite != nil {
+			if releaseErr := s.inviteRepo.Release(ctx, invite.ID); releaseErr != nil {
+				s.logger.Error("failed to release invite", zap.Uint("inviteID", invite.ID), zap.Error(releaseErr))
+			}
+		}
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *registrationService) domainAllowed(email string) bool {
+	if len(s.settings.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	return slices.Contains(s.settings.AllowedDomains, strings.ToLower(email[at+1:]))
+}
+
+func (s *registrationService) CreateInvite(ctx context.Context) (*Invite, string, error) {
+	raw := make([]byte, inviteCodeBytes)
+	if _, err := rand.Read(raw); err != nil {
+		s.logger.Error("failed to generate invite code", zap.Error(err))
+		return nil, "", ErrGeneratingInviteFailed
+	}
+	code := base64.RawURLEncoding.EncodeToString(raw)
+
+	invite := &Invite{Code: hashCode(code), ExpiresAt: time.Now().Add(s.settings.InviteTTL)}
+	if err := s.inviteRepo.Create(ctx, invite); err != nil {
+		s.logger.Error("failed to store invite", zap.Error(err))
+		return nil, "", err
+	}
+	return invite, code, nil
+}
+
+func (s *registrationService) DeleteInvite(ctx context.Context, id uint) error {
+	return s.inviteRepo.Delete(ctx, id)
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,116 @@
+package reset
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	tollbooth "github.com/didip/tollbooth/v7"
+	limiter "github.com/didip/tollbooth/v7/limiter"
+	tollbooth_gin "github.com/didip/tollbooth_gin"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+)
+
+// ForgotPasswordRequest is the payload for asking for a reset.
+// @Description address of the account to reset
+// @Property email body string true "email address"
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest is the payload for choosing a new password.
+// @Description reset token and the new password
+// @Property token    body string true "token from the reset email"
+// @Property password body string true "new password (min 8, letters, digits and a special character)"
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// ResetHandler handles forgotten passwords.
+type ResetHandler struct {
+	router  *gin.RouterGroup
+	service PasswordResetService
+	logger  *zap.Logger
+}
+
+// NewResetHandler registers the rate limited password reset endpoints on
+// the given router group.
+func NewResetHandler(router *gin.RouterGroup, service PasswordResetService, logger *zap.Logger) *ResetHandler {
+	h := &ResetHandler{router: router, service: service, logger: logger}
+
+	// 5 requests per minute limiter
+	resetLimiter := tollbooth.NewLimiter(5, &limiter.ExpirableOptions{
+		DefaultExpirationTTL: time.Minute,
+	})
+
+	h.router.POST(
+		"/auth/password/forgot",
+		tollbooth_gin.LimitHandler(resetLimiter),
+		h.ForgotPassword,
+	)
+
+	h.router.POST(
+		"/auth/password/reset",
+		tollbooth_gin.LimitHandler(resetLimiter),
+		h.ResetPassword,
+	)
+	return h
+}
+
+// ForgotPassword godoc
+// @Summary      Forgot Password
+// @Description  Email a password reset token; the response is the same whether or not the address is registered
+// @Tags         auth
+// @Accept       json
+// @Param        payload  body      ForgotPasswordRequest  true  "Account email"
+// @Success      202
+// @Failure      400      {object}  map[string]string
+// @Router       /auth/password/forgot [post]
+func (h *ResetHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email format"})
+		return
+	}
+	// failures are only logged, so they do not tell registered addresses apart
+	if err := h.service.Forgot(c.Request.Context(), req.Email); err != nil {
+		h.logger.Error("Forgot service failed", zap.Error(err))
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// ResetPassword godoc
+// @Summary      Reset Password
+// @Description  Set a new password with a reset token; all sessions of the person are ended
+// @Tags         auth
+// @Accept       json
+// @Param        payload  body      ResetPasswordRequest  true  "Reset payload"
+// @Success      204
+// @Failure      400      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /auth/password/reset [post]
+func (h *ResetHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token and password required"})
+		return
+	}
+	err := h.service.Reset(c.Request.Context(), req.Token, req.Password)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrInvalidToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+	case errors.Is(err, person.ErrPasswordShouldBeNCharacters),
+		errors.Is(err, person.ErrPasswordNotAlphanumeric),
+		errors.Is(err, person.ErrPasswordDoesNotHaveSpecialCharacter):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("Reset service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not reset password"})
+	}
+}
@@ -0,0 +1,16 @@
+package reset
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PasswordReset is an outstanding request to reset a person's password.
+type PasswordReset struct {
+	gorm.Model
+	// Token is the SHA-256 hash of the token mailed to the person
+	Token     string    `gorm:"uniqueIndex;not null"`
+	PersonID  uint      `gorm:"index;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
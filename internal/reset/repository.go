@@ -0,0 +1,90 @@
+package reset
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrResetNotFound        = errors.New("password reset not found")
+	ErrResetNotCreated      = errors.New("password reset not created")
+	ErrUnresponsiveDatabase = errors.New("error occurred during writing to password resets table")
+)
+
+type ResetRepository interface {
+	Create(ctx context.Context, reset *PasswordReset) error
+	// Consume reads and deletes a reset in one transaction, so a token can
+	// only be used once
+	Consume(ctx context.Context, token string) (*PasswordReset, error)
+	DeleteByPersonID(ctx context.Context, personID uint) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type resetRepository struct {
+	db *gorm.DB
+}
+
+func NewResetRepository(db *gorm.DB) ResetRepository {
+	return &resetRepository{db: db}
+}
+
+func (r *resetRepository) Create(ctx context.Context, reset *PasswordReset) error {
+	if err := r.db.WithContext(ctx).Create(reset).Error; err != nil {
+		return ErrResetNotCreated
+	}
+	return nil
+}
+
+func (r *resetRepository) Consume(ctx context.Context, token string) (*PasswordReset, error) {
+	var reset PasswordReset
+	err := r.db.
+		WithContext(ctx).
+		Transaction(func(tx *gorm.DB) error {
+			err := tx.
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("token = ?", token).
+				First(&reset).
+				Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrResetNotFound
+			}
+			if err != nil {
+				return ErrUnresponsiveDatabase
+			}
+
+			if err := tx.Unscoped().Delete(&reset).Error; err != nil {
+				return ErrUnresponsiveDatabase
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &reset, nil
+}
+
+func (r *resetRepository) DeleteByPersonID(ctx context.Context, personID uint) error {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("person_id = ?", personID).
+		Delete(&PasswordReset{})
+	if res.Error != nil {
+		return ErrUnresponsiveDatabase
+	}
+	return nil
+}
+
+func (r *resetRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("expires_at <= ?", now).
+		Delete(&PasswordReset{})
+	if res.Error != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	return res.RowsAffected, nil
+}
@@ -0,0 +1,170 @@
+package reset
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/mail"
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+)
+
+const (
+	resetTokenBytes = 32
+	// how often expired resets are purged
+	purgeInterval = time.Hour
+)
+
+var (
+	ErrInvalidToken           = errors.New("invalid or expired reset token")
+	ErrGeneratingTokenFailed  = errors.New("generating reset token failed")
+	ErrSendingResetMailFailed = errors.New("sending password reset email failed")
+)
+
+// Settings control the reset tokens.
+type Settings struct {
+	TTL time.Duration
+	// LinkURL is the page that completes a reset; the token is added as a
+	// query parameter. Without it the token itself is mailed.
+	LinkURL string
+}
+
+type PasswordResetService interface {
+	// Forgot mails a reset token if email belongs to a person; unknown
+	// addresses are not an error
+	Forgot(ctx context.Context, email string) error
+	// Reset sets a new password with a token from Forgot and ends all of
+	// the person's sessions
+	Reset(ctx context.Context, token, password string) error
+	Run(ctx context.Context)
+}
+
+type passwordResetService struct {
+	repo          ResetRepository
+	personService person.PersonService
+	mailer        mail.Mailer
+	logger        *zap.Logger
+	settings      Settings
+}
+
+func NewPasswordResetService(
+	repo ResetRepository,
+	personService person.PersonService,
+	mailer mail.Mailer,
+	logger *zap.Logger,
+	settings Settings,
+) PasswordResetService {
+	return &passwordResetService{
+		repo:          repo,
+		personService: personService,
+		mailer:        mailer,
+		logger:        logger,
+		settings:      settings,
+	}
+}
+
+func (s *passwordResetService) Forgot(ctx context.Context, email string) error {
+	user, err := s.personService.ReadPersonByEmail(ctx, email)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	raw := make([]byte, resetTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		s.logger.Error("failed to generate reset token", zap.Error(err))
+		return ErrGeneratingTokenFailed
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	err = s.repo.Create(ctx, &PasswordReset{
+		Token:     hashToken(token),
+		PersonID:  user.ID,
+		ExpiresAt: time.Now().Add(s.settings.TTL),
+	})
+	if err != nil {
+		s.logger.Error("failed to store password reset", zap.Error(err))
+		return err
+	}
+
+	link := ""
+	if s.settings.LinkURL != "" {
+		link = s.settings.LinkURL + "?token=" + url.QueryEscape(token)
+	}
+	err = s.mailer.Send(ctx, mail.Message{
+		To:       user.Email,
+		Template: "password_reset",
+		Data: map[string]any{
+			"Link":    link,
+			"Token":   token,
+			"Minutes": int(s.settings.TTL.Minutes()),
+		},
+	})
+	if err != nil {
+		s.logger.Error("failed to send password reset email", zap.Error(err))
+		return ErrSendingResetMailFailed
+	}
+	return nil
+}
+
+func (s *passwordResetService) Reset(ctx context.Context, token, password string) error {
+	// an unacceptable password must not use up the token
+	if err := person.CheckPassword(password); err != nil {
+		return err
+	}
+
+	reset, err := s.repo.Consume(ctx, hashToken(token))
+	if errors.Is(err, ErrResetNotFound) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	if time.Now().After(reset.ExpiresAt) {
+		return ErrInvalidToken
+	}
+
+	// updating the password also ends the person's sessions
+	err = s.personService.UpdatePassword(ctx, reset.PersonID, password)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteByPersonID(ctx, reset.PersonID); err != nil {
+		s.logger.Error("failed to drop outstanding password resets", zap.Uint("personID", reset.PersonID), zap.Error(err))
+	}
+	return nil
+}
+
+// Run purges expired resets until ctx is cancelled.
+func (s *passwordResetService) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.repo.DeleteExpired(ctx, time.Now()); err != nil {
+				s.logger.Error("failed to purge password resets", zap.Error(err))
+			}
+		}
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
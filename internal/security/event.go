@@ -0,0 +1,64 @@
+package security
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// EventType names a security relevant occurrence.
+type EventType string
+
+const (
+	// RefreshTokenReuse is emitted when a rotated-away refresh token is presented again
+	RefreshTokenReuse EventType = "refresh_token_reuse"
+	// PasskeyCloneSuspected is emitted when a passkey's signature counter goes backwards
+	PasskeyCloneSuspected EventType = "passkey_clone_suspected"
+	// AccountLocked is emitted when failed logins lock an account
+	AccountLocked EventType = "account_locked"
+)
+
+// Event is a security relevant occurrence concerning a person.
+type Event struct {
+	Type       EventType
+	PersonID   uint
+	Details    map[string]string
+	OccurredAt time.Time
+}
+
+// NewEvent stamps an event with the current time.
+func NewEvent(eventType EventType, personID uint, details map[string]string) Event {
+	return Event{
+		Type:       eventType,
+		PersonID:   personID,
+		Details:    details,
+		OccurredAt: time.Now().UTC(),
+	}
+}
+
+// EventPublisher delivers security events to whoever needs to act on them.
+type EventPublisher interface {
+	Publish(ctx context.Context, event Event)
+}
+
+type logPublisher struct {
+	logger *zap.Logger
+}
+
+// NewLogPublisher writes security events to the structured log.
+func NewLogPublisher(logger *zap.Logger) EventPublisher {
+	return &logPublisher{logger: logger}
+}
+
+func (p *logPublisher) Publish(ctx context.Context, event Event) {
+	fields := []zap.Field{
+		zap.String("event", string(event.Type)),
+		zap.Uint("personID", event.PersonID),
+		zap.Time("occurredAt", event.OccurredAt),
+	}
+	for k, v := range event.Details {
+		fields = append(fields, zap.String(k, v))
+	}
+	p.logger.Warn("security event", fields...)
+}
@@ -0,0 +1,58 @@
+// Package tenant carries the organization a request acts in, and limits
+// repository queries to it.
+package tenant
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type organizationKey struct{}
+
+// WithOrganization returns a context whose repository queries only see the
+// organization with the given ID and its members.
+func WithOrganization(ctx context.Context, organizationID uint) context.Context {
+	return context.WithValue(ctx, organizationKey{}, organizationID)
+}
+
+// WithoutOrganization returns a context whose repository queries see every
+// organization, for the few lookups that have to cross tenants, such as
+// finding a person to invite to an organization.
+func WithoutOrganization(ctx context.Context) context.Context {
+	return context.WithValue(ctx, organizationKey{}, uint(0))
+}
+
+// OrganizationFrom returns the organization ctx acts in, if any.
+func OrganizationFrom(ctx context.Context) (uint, bool) {
+	id, ok := ctx.Value(organizationKey{}).(uint)
+	return id, ok && id != 0
+}
+
+// Organizations is a gorm scope limiting a query to rows whose column is
+// the organization in ctx. Without one it changes nothing.
+func Organizations(ctx context.Context, column string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		id, ok := OrganizationFrom(ctx)
+		if !ok {
+			return db
+		}
+		return db.Where(column+" = ?", id)
+	}
+}
+
+// Members is a gorm scope limiting a query to rows whose personColumn is a
+// member of the organization in ctx. Without one it changes nothing.
+func Members(ctx context.Context, personColumn string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		id, ok := OrganizationFrom(ctx)
+		if !ok {
+			return db
+		}
+		members := db.Session(&gorm.Session{NewDB: true}).
+			Table("organization_members").
+			Select("person_id").
+			Where("organization_id = ?", id)
+		return db.Where(personColumn+" IN (?)", members)
+	}
+}
@@ -2,8 +2,10 @@ package utils
 
 import (
 	"errors"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -26,7 +28,8 @@ func (c *DatabaseConfig) DSN() string {
 }
 
 type ServerConfig struct {
-	Port string
+	Port   string
+	Issuer string // public base URL, used as the OpenID Connect issuer
 }
 
 type AdminConfig struct {
@@ -35,17 +38,67 @@ type AdminConfig struct {
 }
 
 type TokenConfig struct {
-	AccessTokenSecret  string
-	RefreshTokenSecret string
-	RefreshTokenExpiry int // in hours
-	AccessTokenExpiry  int // in minutes
+	SigningAlgorithm    string // RS256, ES256 or EdDSA
+	SigningKeyPath      string // optional PEM encoded private key seeding the access key ring
+	RefreshTokenSecret  string // optional secret seeding the refresh key ring
+	RefreshTokenExpiry  int    // in hours
+	AccessTokenExpiry   int    // in minutes
+	KeyRotationInterval int    // in hours, 0 disables scheduled rotation
+}
+
+type MFAConfig struct {
+	TOTPIssuer      string   // name shown for this service in authenticator apps
+	WebAuthnRPID    string   // domain passkeys are bound to
+	WebAuthnRPName  string   // name shown for this service by passkey prompts
+	WebAuthnOrigins []string // origins allowed to register and use passkeys
+}
+
+type RegistrationConfig struct {
+	Enabled        bool     // whether people may register themselves
+	AllowedDomains []string // email domains allowed to register, all if empty
+	InviteRequired bool     // whether registering needs an admin issued invite
+	InviteTTL      int      // in hours
+}
+
+type EmailConfig struct {
+	VerificationTTL  int    // in hours
+	RequireVerified  bool   // whether login is refused until the address is confirmed
+	PasswordResetTTL int    // in minutes
+	PasswordResetURL string // optional page that completes password resets
+	MagicLinkTTL     int    // in minutes
+	MagicLinkURL     string // optional page that completes magic link logins
+	MagicLinkBinding bool   // whether magic links only work in the browser that asked for them
+	Transport        string // log, smtp or file
+	From             string // sender address of outgoing mail
+	SMTPHost         string
+	SMTPPort         int
+	SMTPUsername     string
+	SMTPPassword     string
+	SMTPTLS          string // starttls, tls or none
+	MaildirPath      string // where the file transport stores messages
+	TemplateDir      string // optional directory replacing the built-in templates
+	DefaultLocale    string // template language used when none is known
+	QueueSize        int    // messages waiting for delivery
+	MaxAttempts      int    // delivery attempts per message
+}
+
+type LockoutConfig struct {
+	DelayAfter int // failed logins before further attempts are delayed, 0 disables delays
+	BaseDelay  int // in seconds, doubled with every further failure
+	MaxDelay   int // in seconds
+	Threshold  int // failed logins that lock the account, 0 disables lockouts
+	Duration   int // in minutes
 }
 
 type Config struct {
-	Database *DatabaseConfig
-	Server   *ServerConfig
-	Admin    *AdminConfig
-	Token    *TokenConfig
+	Database     *DatabaseConfig
+	Server       *ServerConfig
+	Admin        *AdminConfig
+	Token        *TokenConfig
+	MFA          *MFAConfig
+	Registration *RegistrationConfig
+	Email        *EmailConfig
+	Lockout      *LockoutConfig
 }
 
 func LoadConfig(dotenvPath string) (*Config, error) {
@@ -62,12 +115,23 @@ func LoadConfig(dotenvPath string) (*Config, error) {
 	serverCgf := &ServerConfig{
 		Port: os.Getenv("SERVER_PORT"),
 	}
+	serverCgf.Issuer = strings.TrimSuffix(os.Getenv("ISSUER"), "/")
+	if serverCgf.Issuer == "" {
+		serverCgf.Issuer = "http://localhost:" + serverCgf.Port
+	}
 	adminCfg := &AdminConfig{
 		Username: os.Getenv("ADMIN_USERNAME"),
 		Password: os.Getenv("ADMIN_PASSWORD"),
 	}
 	tokenCfg := &TokenConfig{
-		AccessTokenSecret:  os.Getenv("ACCESS_TOKEN_SECRET"),
+		SigningAlgorithm: func() string {
+			algorithm := os.Getenv("SIGNING_ALGORITHM")
+			if algorithm == "" {
+				return AlgorithmRS256
+			}
+			return algorithm
+		}(),
+		SigningKeyPath:     os.Getenv("SIGNING_KEY_PATH"),
 		RefreshTokenSecret: os.Getenv("REFRESH_TOKEN_SECRET"),
 		RefreshTokenExpiry: func() int {
 			expiry, err := strconv.Atoi(os.Getenv("REFRESH_TOKEN_EXPIRY"))
@@ -83,15 +147,186 @@ func LoadConfig(dotenvPath string) (*Config, error) {
 			}
 			return expiry
 		}(),
+		KeyRotationInterval: func() int {
+			interval, err := strconv.Atoi(os.Getenv("KEY_ROTATION_INTERVAL"))
+			if err != nil {
+				return 720 // default to 30 days if parsing fails
+			}
+			return interval
+		}(),
+	}
+
+	mfaCfg := &MFAConfig{
+		TOTPIssuer: func() string {
+			issuer := os.Getenv("TOTP_ISSUER")
+			if issuer == "" {
+				return "Authentication Service"
+			}
+			return issuer
+		}(),
+		WebAuthnRPID:   os.Getenv("WEBAUTHN_RP_ID"),
+		WebAuthnRPName: os.Getenv("WEBAUTHN_RP_NAME"),
+	}
+	// passkeys default to the issuer's host and origin
+	if mfaCfg.WebAuthnRPID == "" {
+		if issuer, err := url.Parse(serverCgf.Issuer); err == nil {
+			mfaCfg.WebAuthnRPID = issuer.Hostname()
+		}
+	}
+	if mfaCfg.WebAuthnRPName == "" {
+		mfaCfg.WebAuthnRPName = mfaCfg.TOTPIssuer
+	}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			mfaCfg.WebAuthnOrigins = append(mfaCfg.WebAuthnOrigins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	if len(mfaCfg.WebAuthnOrigins) == 0 {
+		mfaCfg.WebAuthnOrigins = []string{serverCgf.Issuer}
 	}
 
-	if len(tokenCfg.AccessTokenSecret) < 32 {
-		panic("access token too short. must be at least 32 characters")
+	registrationCfg := &RegistrationConfig{
+		Enabled:        os.Getenv("REGISTRATION_ENABLED") == "true",
+		InviteRequired: os.Getenv("REGISTRATION_INVITE_REQUIRED") == "true",
+		InviteTTL: func() int {
+			ttl, err := strconv.Atoi(os.Getenv("REGISTRATION_INVITE_TTL"))
+			if err != nil {
+				return 168 // default to 7 days if parsing fails
+			}
+			return ttl
+		}(),
+	}
+	for _, domain := range strings.Split(os.Getenv("REGISTRATION_ALLOWED_DOMAINS"), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			registrationCfg.AllowedDomains = append(registrationCfg.AllowedDomains, strings.ToLower(domain))
+		}
 	}
-	if len(tokenCfg.RefreshTokenSecret) < 32 {
+
+	emailCfg := &EmailConfig{
+		VerificationTTL: func() int {
+			ttl, err := strconv.Atoi(os.Getenv("EMAIL_VERIFICATION_TTL"))
+			if err != nil {
+				return 24 // default to 24 hours if parsing fails
+			}
+			return ttl
+		}(),
+		RequireVerified: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		PasswordResetTTL: func() int {
+			ttl, err := strconv.Atoi(os.Getenv("PASSWORD_RESET_TTL"))
+			if err != nil {
+				return 30 // default to 30 minutes if parsing fails
+			}
+			return ttl
+		}(),
+		PasswordResetURL: os.Getenv("PASSWORD_RESET_URL"),
+		MagicLinkTTL: func() int {
+			ttl, err := strconv.Atoi(os.Getenv("MAGIC_LINK_TTL"))
+			if err != nil {
+				return 15 // default to 15 minutes if parsing fails
+			}
+			return ttl
+		}(),
+		MagicLinkURL:     os.Getenv("MAGIC_LINK_URL"),
+		MagicLinkBinding: os.Getenv("MAGIC_LINK_SAME_BROWSER") != "false",
+		Transport: func() string {
+			if transport := os.Getenv("MAIL_TRANSPORT"); transport != "" {
+				return transport
+			}
+			return "log"
+		}(),
+		From: func() string {
+			if from := os.Getenv("MAIL_FROM"); from != "" {
+				return from
+			}
+			return "no-reply@localhost"
+		}(),
+		SMTPHost: os.Getenv("SMTP_HOST"),
+		SMTPPort: func() int {
+			port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+			if err != nil {
+				return 587 // default to the submission port if parsing fails
+			}
+			return port
+		}(),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		SMTPTLS: func() string {
+			if mode := os.Getenv("SMTP_TLS"); mode != "" {
+				return mode
+			}
+			return "starttls"
+		}(),
+		MaildirPath: func() string {
+			if path := os.Getenv("MAIL_MAILDIR"); path != "" {
+				return path
+			}
+			return "maildir"
+		}(),
+		TemplateDir: os.Getenv("MAIL_TEMPLATE_DIR"),
+		DefaultLocale: func() string {
+			if locale := os.Getenv("MAIL_LOCALE"); locale != "" {
+				return locale
+			}
+			return "en"
+		}(),
+		QueueSize: func() int {
+			size, err := strconv.Atoi(os.Getenv("MAIL_QUEUE_SIZE"))
+			if err != nil {
+				return 100 // default to 100 messages if parsing fails
+			}
+			return size
+		}(),
+		MaxAttempts: func() int {
+			attempts, err := strconv.Atoi(os.Getenv("MAIL_MAX_ATTEMPTS"))
+			if err != nil {
+				return 5 // default to 5 attempts if parsing fails
+			}
+			return attempts
+		}(),
+	}
+
+	lockoutCfg := &LockoutConfig{
+		DelayAfter: func() int {
+			n, err := strconv.Atoi(os.Getenv("LOGIN_DELAY_AFTER"))
+			if err != nil {
+				return 3 // default to 3 failures if parsing fails
+			}
+			return n
+		}(),
+		BaseDelay: func() int {
+			delay, err := strconv.Atoi(os.Getenv("LOGIN_DELAY_BASE"))
+			if err != nil {
+				return 1 // default to 1 second if parsing fails
+			}
+			return delay
+		}(),
+		MaxDelay: func() int {
+			delay, err := strconv.Atoi(os.Getenv("LOGIN_DELAY_MAX"))
+			if err != nil {
+				return 60 // default to 1 minute if parsing fails
+			}
+			return delay
+		}(),
+		Threshold: func() int {
+			n, err := strconv.Atoi(os.Getenv("LOCKOUT_THRESHOLD"))
+			if err != nil {
+				return 10 // default to 10 failures if parsing fails
+			}
+			return n
+		}(),
+		Duration: func() int {
+			duration, err := strconv.Atoi(os.Getenv("LOCKOUT_DURATION"))
+			if err != nil {
+				return 15 // default to 15 minutes if parsing fails
+			}
+			return duration
+		}(),
+	}
+
+	if tokenCfg.RefreshTokenSecret != "" && len(tokenCfg.RefreshTokenSecret) < 32 {
 		panic("refresh token too short. must be at least 32 characters")
 	}
 
-	cfg := &Config{dbCfg, serverCgf, adminCfg, tokenCfg}
+	cfg := &Config{dbCfg, serverCgf, adminCfg, tokenCfg, mfaCfg, registrationCfg, emailCfg, lockoutCfg}
 	return cfg, nil
 }
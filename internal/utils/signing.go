@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmHS256 = "HS256"
+
+	minimumRSAKeyBits   = 2048
+	minimumSecretLength = 32
+)
+
+var (
+	ErrUnsupportedSigningAlgorithm = errors.New("unsupported signing algorithm")
+	ErrInvalidSigningKey           = errors.New("signing key does not match signing algorithm")
+	ErrMalformedSigningKey         = errors.New("signing key is not a PEM encoded private key")
+	ErrUnknownSigningKey           = errors.New("no signing key found for given key id")
+)
+
+// SigningKey is a key tokens are signed with, paired with the JWT signing
+// method it is used for and the key ID written to the token's kid header.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Private is a crypto.Signer for asymmetric methods and the raw
+	// secret ([]byte) for HMAC.
+	Private crypto.PrivateKey
+}
+
+// KeyRing resolves the key new tokens are signed with and the key a
+// presented token is verified against, selected by its kid header.
+type KeyRing interface {
+	SigningKey() (*SigningKey, error)
+	VerificationKey(kid string) (*SigningKey, error)
+}
+
+// Public returns the verification half of an asymmetric key, or nil for HMAC keys.
+func (k *SigningKey) Public() crypto.PublicKey {
+	if signer, ok := k.Private.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return nil
+}
+
+func (k *SigningKey) verificationKey() interface{} {
+	if secret, ok := k.Private.([]byte); ok {
+		return secret
+	}
+	return k.Public()
+}
+
+// LoadSigningKey reads a PEM encoded private key from path and checks that it
+// can be used with the given algorithm.
+func LoadSigningKey(algorithm, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSigningKey(algorithm, data)
+}
+
+// ParseSigningKey decodes a PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) PEM block.
+func ParseSigningKey(algorithm string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrMalformedSigningKey
+	}
+
+	var (
+		key any
+		err error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, ErrMalformedSigningKey
+	}
+	if err != nil {
+		return nil, ErrMalformedSigningKey
+	}
+
+	return newSigningKey(algorithm, key)
+}
+
+// NewSecretKey wraps a shared HMAC secret.
+func NewSecretKey(secret []byte) (*SigningKey, error) {
+	return newSigningKey(AlgorithmHS256, secret)
+}
+
+// GenerateSigningKey creates a fresh key for the given algorithm.
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var (
+		key any
+		err error
+	)
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err = rsa.GenerateKey(rand.Reader, minimumRSAKeyBits)
+	case AlgorithmES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case AlgorithmHS256:
+		secret := make([]byte, minimumSecretLength)
+		_, err = rand.Read(secret)
+		key = secret
+	default:
+		return nil, ErrUnsupportedSigningAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(algorithm, key)
+}
+
+// MarshalSigningKey encodes the key for storage: PKCS#8 DER for asymmetric
+// keys and the raw secret for HMAC.
+func MarshalSigningKey(key *SigningKey) ([]byte, error) {
+	if secret, ok := key.Private.([]byte); ok {
+		return secret, nil
+	}
+	return x509.MarshalPKCS8PrivateKey(key.Private)
+}
+
+// UnmarshalSigningKey is the inverse of MarshalSigningKey.
+func UnmarshalSigningKey(id, algorithm string, data []byte) (*SigningKey, error) {
+	var key any = data
+	if algorithm != AlgorithmHS256 {
+		parsed, err := x509.ParsePKCS8PrivateKey(data)
+		if err != nil {
+			return nil, ErrMalformedSigningKey
+		}
+		key = parsed
+	}
+	k, err := newSigningKey(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	k.ID = id
+	return k, nil
+}
+
+func newSigningKey(algorithm string, key any) (*SigningKey, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok || k.N.BitLen() < minimumRSAKeyBits {
+			return nil, ErrInvalidSigningKey
+		}
+		return &SigningKey{Method: jwt.SigningMethodRS256, Private: k}, nil
+	case AlgorithmES256:
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok || k.Curve != elliptic.P256() {
+			return nil, ErrInvalidSigningKey
+		}
+		return &SigningKey{Method: jwt.SigningMethodES256, Private: k}, nil
+	case AlgorithmEdDSA:
+		k, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, ErrInvalidSigningKey
+		}
+		return &SigningKey{Method: jwt.SigningMethodEdDSA, Private: k}, nil
+	case AlgorithmHS256:
+		k, ok := key.([]byte)
+		if !ok || len(k) < minimumSecretLength {
+			return nil, ErrInvalidSigningKey
+		}
+		return &SigningKey{Method: jwt.SigningMethodHS256, Private: k}, nil
+	default:
+		return nil, ErrUnsupportedSigningAlgorithm
+	}
+}
@@ -2,14 +2,58 @@ package utils
 
 import (
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/mehmetcc/definitive-authentication-service/internal/person"
 )
 
+// PrincipalType tells what the subject of an access token is.
+type PrincipalType string
+
+const (
+	// PrincipalPerson tokens have a person ID as subject
+	PrincipalPerson PrincipalType = "person"
+	// PrincipalClient tokens have a client_id as subject
+	PrincipalClient PrincipalType = "client"
+)
+
 type AccessClaims struct {
-	Role person.Role `json:"role"`
+	// Roles are the names of the person's roles when the token was issued
+	Roles []string `json:"roles,omitempty"`
+	// Groups are the names of the person's groups when the token was issued
+	Groups []string `json:"groups,omitempty"`
+	// Principal is empty in tokens issued to persons before clients existed
+	Principal PrincipalType `json:"principal,omitempty"`
+	// Scope limits what the token may be used for. Tokens issued to
+	// persons carry the permissions and identity scopes they were granted.
+	Scope string `json:"scope,omitempty"`
+	// OrganizationID is the organization the person acts in, absent when
+	// they act in none
+	OrganizationID uint `json:"tid,omitempty"`
+	// SessionID is the refresh token family the token was issued with
+	SessionID string `json:"sid,omitempty"`
+	// ClientID is the OAuth client the token was issued to, absent in
+	// tokens of logins at this service's own endpoints
+	ClientID string `json:"client_id,omitempty"`
+	// TokenVersion is the person's token version when the token was issued
+	TokenVersion uint `json:"ver,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// IsClient reports whether the token was issued to a client acting on its own behalf.
+func (c *AccessClaims) IsClient() bool {
+	return c.Principal == PrincipalClient
+}
+
+// IDClaims are the OpenID Connect ID token claims.
+type IDClaims struct {
+	Nonce         string `json:"nonce,omitempty"`
+	AuthTime      int64  `json:"auth_time"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
 	jwt.RegisteredClaims
 }
 
@@ -17,21 +61,93 @@ type RefreshClaims struct {
 	jwt.RegisteredClaims
 }
 
-func IssueAccessToken(subject string, role person.Role, secret string, ttl time.Duration) (string, error) {
+// ActionClaims are the claims of single purpose tokens mailed to people,
+// such as email verification links. Purpose keeps a token issued for one
+// action from being accepted by another.
+type ActionClaims struct {
+	Purpose string `json:"purpose"`
+	Email   string `json:"email,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func IssueAccessToken(
+	subject string,
+	roles []string,
+	groups []string,
+	scope string,
+	organizationID uint,
+	sessionID string,
+	clientID string,
+	tokenVersion uint,
+	keys KeyRing,
+	ttl time.Duration,
+) (string, error) {
 	now := time.Now()
 	claims := AccessClaims{
-		Role: role,
+		Roles:          roles,
+		Groups:         groups,
+		Principal:      PrincipalPerson,
+		Scope:          scope,
+		OrganizationID: organizationID,
+		SessionID:      sessionID,
+		ClientID:       clientID,
+		TokenVersion:   tokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			Subject:   subject,
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return sign(claims, keys)
+}
+
+// IssueClientAccessToken issues an access token whose subject is a client,
+// for the client credentials grant.
+func IssueClientAccessToken(clientID, scope string, keys KeyRing, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := AccessClaims{
+		Principal: PrincipalClient,
+		Scope:     scope,
+		ClientID:  clientID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   clientID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return sign(claims, keys)
 }
 
-func IssueRefreshToken(subject, jti, secret string, ttl time.Duration) (string, error) {
+// IssueIDToken issues an ID token for audience, the client the person
+// authenticated to at authTime.
+func IssueIDToken(
+	issuer, audience string,
+	user *person.Person,
+	nonce string,
+	authTime time.Time,
+	keys KeyRing,
+	ttl time.Duration,
+) (string, error) {
+	now := time.Now()
+	claims := IDClaims{
+		Nonce:         nonce,
+		AuthTime:      authTime.Unix(),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return sign(claims, keys)
+}
+
+func IssueRefreshToken(subject, jti string, keys KeyRing, ttl time.Duration) (string, error) {
 	now := time.Now()
 	claims := RefreshClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -41,17 +157,28 @@ func IssueRefreshToken(subject, jti, secret string, ttl time.Duration) (string,
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return sign(claims, keys)
 }
 
-func ParseAccessToken(tokenString, secret string) (*AccessClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &AccessClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(secret), nil
-	})
+// IssueActionToken issues a token for purpose to the person subject. The
+// jti identifies the server-side record that makes the token single use.
+func IssueActionToken(purpose, subject, email, jti string, keys KeyRing, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := ActionClaims{
+		Purpose: purpose,
+		Email:   email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return sign(claims, keys)
+}
+
+func ParseAccessToken(tokenString string, keys KeyRing) (*AccessClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &AccessClaims{}, keyFunc(keys))
 	if err != nil {
 		return nil, err
 	}
@@ -61,13 +188,8 @@ func ParseAccessToken(tokenString, secret string) (*AccessClaims, error) {
 	return nil, errors.New("invalid access token")
 }
 
-func ParseRefreshToken(tokenString, secret string) (*RefreshClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &RefreshClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(secret), nil
-	})
+func ParseRefreshToken(tokenString string, keys KeyRing) (*RefreshClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &RefreshClaims{}, keyFunc(keys))
 	if err != nil {
 		return nil, err
 	}
@@ -76,3 +198,43 @@ func ParseRefreshToken(tokenString, secret string) (*RefreshClaims, error) {
 	}
 	return nil, errors.New("invalid refresh token")
 }
+
+func ParseActionToken(tokenString, purpose string, keys KeyRing) (*ActionClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &ActionClaims{}, keyFunc(keys))
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := token.Claims.(*ActionClaims); ok && token.Valid && claims.Purpose == purpose {
+		return claims, nil
+	}
+	return nil, errors.New("invalid action token")
+}
+
+func sign(claims jwt.Claims, keys KeyRing) (string, error) {
+	key, err := keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// keyFunc selects the verification key by the token's kid header and
+// rejects tokens whose alg does not match the key's.
+func keyFunc(keys KeyRing) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, ErrUnknownSigningKey
+		}
+		key, err := keys.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.verificationKey(), nil
+	}
+}
@@ -0,0 +1,115 @@
+package verification
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	tollbooth "github.com/didip/tollbooth/v7"
+	limiter "github.com/didip/tollbooth/v7/limiter"
+	tollbooth_gin "github.com/didip/tollbooth_gin"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+)
+
+// VerifyEmailRequest carries the token from a verification link.
+// @Description token from the link in the verification email
+// @Property token body string true "verification token"
+type VerifyEmailRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// VerifyEmailResponse reports the confirmed address.
+// @Description the person's address after confirmation
+// @Property email          body string  true "confirmed email address"
+// @Property email_verified body boolean true "always true"
+type VerifyEmailResponse struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// VerificationHandler handles email verification.
+type VerificationHandler struct {
+	router  *gin.RouterGroup
+	service VerificationService
+	logger  *zap.Logger
+}
+
+// NewVerificationHandler registers the public, rate limited verification
+// endpoint on the given router group. Resend is mounted by the caller on a
+// group that requires an authenticated person.
+func NewVerificationHandler(router *gin.RouterGroup, service VerificationService, logger *zap.Logger) *VerificationHandler {
+	h := &VerificationHandler{router: router, service: service, logger: logger}
+
+	// 10 requests per minute limiter
+	verifyLimiter := tollbooth.NewLimiter(10, &limiter.ExpirableOptions{
+		DefaultExpirationTTL: time.Minute,
+	})
+
+	// GET serves the links in verification emails
+	h.router.GET("/auth/verify-email", tollbooth_gin.LimitHandler(verifyLimiter), h.VerifyEmail)
+	h.router.POST("/auth/verify-email", tollbooth_gin.LimitHandler(verifyLimiter), h.VerifyEmail)
+	return h
+}
+
+// VerifyEmail godoc
+// @Summary      Verify Email
+// @Description  Confirm an email address with the token from a verification link
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        token    query     string              false  "verification token, for links"
+// @Param        payload  body      VerifyEmailRequest  false  "verification token"
+// @Success      200      {object}  VerifyEmailResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /auth/verify-email [post]
+func (h *VerificationHandler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token required"})
+		return
+	}
+	user, err := h.service.VerifyEmail(c.Request.Context(), req.Token)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, VerifyEmailResponse{Email: user.Email, EmailVerified: user.EmailVerified})
+	case errors.Is(err, ErrInvalidToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification link"})
+	case errors.Is(err, person.ErrEmailAlreadyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "email already registered"})
+	default:
+		h.logger.Error("VerifyEmail service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not verify email"})
+	}
+}
+
+// Resend godoc
+// @Summary      Resend Verification
+// @Description  Send a new verification link for the current person's pending or unverified address
+// @Tags         auth
+// @Success      202
+// @Failure      401      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /auth/verify-email/resend [post]
+func (h *VerificationHandler) Resend(c *gin.Context) {
+	raw, exists := c.Get(person.ContextUserKey)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	err := h.service.Resend(c.Request.Context(), raw.(*person.Person))
+	switch {
+	case err == nil:
+		c.Status(http.StatusAccepted)
+	case errors.Is(err, ErrAlreadyVerified):
+		c.JSON(http.StatusConflict, gin.H{"error": "email already verified"})
+	default:
+		h.logger.Error("Resend service failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not send verification"})
+	}
+}
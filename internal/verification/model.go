@@ -0,0 +1,18 @@
+package verification
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EmailVerification is an outstanding confirmation link sent to an address
+// a person wants to use.
+type EmailVerification struct {
+	gorm.Model
+	// Token is the SHA-256 hash of the link token's jti
+	Token     string    `gorm:"uniqueIndex;not null"`
+	PersonID  uint      `gorm:"index;not null"`
+	Email     string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
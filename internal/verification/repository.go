@@ -0,0 +1,78 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrVerificationNotFound   = errors.New("email verification not found")
+	ErrVerificationNotCreated = errors.New("email verification not created")
+	ErrUnresponsiveDatabase   = errors.New("error occurred during writing to email verifications table")
+)
+
+type VerificationRepository interface {
+	Create(ctx context.Context, verification *EmailVerification) error
+	// Consume reads and deletes a verification in one transaction, so a
+	// link can only be used once
+	Consume(ctx context.Context, token string) (*EmailVerification, error)
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type verificationRepository struct {
+	db *gorm.DB
+}
+
+func NewVerificationRepository(db *gorm.DB) VerificationRepository {
+	return &verificationRepository{db: db}
+}
+
+func (r *verificationRepository) Create(ctx context.Context, verification *EmailVerification) error {
+	if err := r.db.WithContext(ctx).Create(verification).Error; err != nil {
+		return ErrVerificationNotCreated
+	}
+	return nil
+}
+
+func (r *verificationRepository) Consume(ctx context.Context, token string) (*EmailVerification, error) {
+	var verification EmailVerification
+	err := r.db.
+		WithContext(ctx).
+		Transaction(func(tx *gorm.DB) error {
+			err := tx.
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("token = ?", token).
+				First(&verification).
+				Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrVerificationNotFound
+			}
+			if err != nil {
+				return ErrUnresponsiveDatabase
+			}
+
+			if err := tx.Unscoped().Delete(&verification).Error; err != nil {
+				return ErrUnresponsiveDatabase
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &verification, nil
+}
+
+func (r *verificationRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Unscoped().
+		Where("expires_at <= ?", now).
+		Delete(&EmailVerification{})
+	if res.Error != nil {
+		return 0, ErrUnresponsiveDatabase
+	}
+	return res.RowsAffected, nil
+}
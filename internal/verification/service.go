@@ -0,0 +1,190 @@
+package verification
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/mehmetcc/definitive-authentication-service/internal/mail"
+	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+	"github.com/mehmetcc/definitive-authentication-service/internal/utils"
+)
+
+const (
+	purposeVerifyEmail = "verify_email"
+	// how often expired verifications are purged
+	purgeInterval = time.Hour
+)
+
+var (
+	ErrInvalidToken    = errors.New("invalid or expired verification link")
+	ErrAlreadyVerified = errors.New("email already verified")
+	ErrSendingFailed   = errors.New("sending verification email failed")
+)
+
+// Settings control the verification links.
+type Settings struct {
+	TTL time.Duration
+	// LinkURL is where links point; the token is added as a query parameter
+	LinkURL string
+}
+
+type VerificationService interface {
+	SendVerification(ctx context.Context, user *person.Person, email string) error
+	// Resend sends a new link for the person's pending address, or for
+	// their current one while it is unverified
+	Resend(ctx context.Context, user *person.Person) error
+	VerifyEmail(ctx context.Context, token string) (*person.Person, error)
+	Run(ctx context.Context)
+}
+
+type verificationService struct {
+	repo       VerificationRepository
+	personRepo person.PersonRepository
+	mailer     mail.Mailer
+	logger     *zap.Logger
+	keys       utils.KeyRing
+	settings   Settings
+}
+
+// NewVerificationService works on the person repository rather than the
+// person service, which depends on it to send verifications.
+func NewVerificationService(
+	repo VerificationRepository,
+	personRepo person.PersonRepository,
+	mailer mail.Mailer,
+	logger *zap.Logger,
+	keys utils.KeyRing,
+	settings Settings,
+) VerificationService {
+	return &verificationService{
+		repo:       repo,
+		personRepo: personRepo,
+		mailer:     mailer,
+		logger:     logger,
+		keys:       keys,
+		settings:   settings,
+	}
+}
+
+// SendVerification mails a signed, single use link confirming email for
+// the person.
+func (s *verificationService) SendVerification(ctx context.Context, user *person.Person, email string) error {
+	jti := uuid.NewString()
+	token, err := utils.IssueActionToken(
+		purposeVerifyEmail,
+		strconv.Itoa(int(user.ID)),
+		email,
+		jti,
+		s.keys,
+		s.settings.TTL,
+	)
+	if err != nil {
+		s.logger.Error("failed to sign verification token", zap.Error(err))
+		return err
+	}
+	err = s.repo.Create(ctx, &EmailVerification{
+		Token:     hashToken(jti),
+		PersonID:  user.ID,
+		Email:     email,
+		ExpiresAt: time.Now().Add(s.settings.TTL),
+	})
+	if err != nil {
+		s.logger.Error("failed to store email verification", zap.Error(err))
+		return err
+	}
+
+	link := s.settings.LinkURL + "?token=" + url.QueryEscape(token)
+	err = s.mailer.Send(ctx, mail.Message{
+		To:       email,
+		Template: "verify_email",
+		Data: map[string]any{
+			"Link":  link,
+			"Hours": int(s.settings.TTL.Hours()),
+		},
+	})
+	if err != nil {
+		s.logger.Error("failed to send verification email", zap.Error(err))
+		return ErrSendingFailed
+	}
+	return nil
+}
+
+func (s *verificationService) Resend(ctx context.Context, user *person.Person) error {
+	switch {
+	case user.PendingEmail != "":
+		return s.SendVerification(ctx, user, user.PendingEmail)
+	case !user.EmailVerified:
+		return s.SendVerification(ctx, user, user.Email)
+	}
+	return ErrAlreadyVerified
+}
+
+// VerifyEmail redeems a link token: the address it was sent to becomes the
+// person's verified address.
+func (s *verificationService) VerifyEmail(ctx context.Context, token string) (*person.Person, error) {
+	claims, err := utils.ParseActionToken(token, purposeVerifyEmail, s.keys)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	verification, err := s.repo.Consume(ctx, hashToken(claims.ID))
+	if errors.Is(err, ErrVerificationNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(verification.ExpiresAt) ||
+		verification.Email != claims.Email ||
+		strconv.Itoa(int(verification.PersonID)) != claims.Subject {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.personRepo.ReadByID(ctx, verification.PersonID)
+	if errors.Is(err, person.ErrPersonNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the link is stale if another address was requested since
+	if !user.ConfirmEmail(verification.Email) {
+		return nil, ErrInvalidToken
+	}
+	if err := s.personRepo.Update(ctx, user); err != nil {
+		if !errors.Is(err, person.ErrEmailAlreadyExists) {
+			s.logger.Error("failed to confirm email", zap.Uint("id", user.ID), zap.Error(err))
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// Run purges expired verifications until ctx is cancelled.
+func (s *verificationService) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.repo.DeleteExpired(ctx, time.Now()); err != nil {
+				s.logger.Error("failed to purge email verifications", zap.Error(err))
+			}
+		}
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
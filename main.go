@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,8 +17,22 @@ import (
 	ginSwagger "github.com/swaggo/gin-swagger"
 
 	"github.com/mehmetcc/definitive-authentication-service/internal/authentication"
+	"github.com/mehmetcc/definitive-authentication-service/internal/client"
+	"github.com/mehmetcc/definitive-authentication-service/internal/group"
+	"github.com/mehmetcc/definitive-authentication-service/internal/keys"
+	"github.com/mehmetcc/definitive-authentication-service/internal/magiclink"
+	"github.com/mehmetcc/definitive-authentication-service/internal/mail"
+	"github.com/mehmetcc/definitive-authentication-service/internal/mfa"
+	"github.com/mehmetcc/definitive-authentication-service/internal/oauth"
+	"github.com/mehmetcc/definitive-authentication-service/internal/organization"
+	"github.com/mehmetcc/definitive-authentication-service/internal/passkey"
 	"github.com/mehmetcc/definitive-authentication-service/internal/person"
+	"github.com/mehmetcc/definitive-authentication-service/internal/rbac"
+	"github.com/mehmetcc/definitive-authentication-service/internal/registration"
+	"github.com/mehmetcc/definitive-authentication-service/internal/reset"
+	"github.com/mehmetcc/definitive-authentication-service/internal/security"
 	"github.com/mehmetcc/definitive-authentication-service/internal/utils"
+	"github.com/mehmetcc/definitive-authentication-service/internal/verification"
 	"go.uber.org/zap"
 )
 
@@ -40,10 +55,57 @@ func main() {
 	if err != nil {
 		panic("Failed to connect to the database: " + err.Error())
 	}
-	if err := db.AutoMigrate(&person.Person{}, &authentication.RefreshTokenRecord{}); err != nil {
+	if err := db.AutoMigrate(
+		&person.Person{},
+		&rbac.Permission{},
+		&rbac.Role{},
+		&rbac.Assignment{},
+		&rbac.Inheritance{},
+		&rbac.GraphVersion{},
+		&rbac.OrganizationAssignment{},
+		&organization.Organization{},
+		&organization.Member{},
+		&organization.Invitation{},
+		&group.Group{},
+		&group.Member{},
+		&rbac.GroupAssignment{},
+		&authentication.RefreshTokenRecord{},
+		&authentication.SupersededRefreshToken{},
+		&authentication.RevokedAccessToken{},
+		&authentication.Lockout{},
+		&keys.SigningKeyRecord{},
+		&client.Client{},
+		&oauth.AuthorizationCode{},
+		&oauth.DeviceAuthorization{},
+		&mfa.TOTPFactor{},
+		&mfa.EmailFactor{},
+		&mfa.RecoveryCode{},
+		&mfa.Challenge{},
+		&passkey.Credential{},
+		&passkey.Ceremony{},
+		&registration.Invite{},
+		&verification.EmailVerification{},
+		&reset.PasswordReset{},
+		&magiclink.MagicLink{},
+	); err != nil {
 		panic("Failed to migrate database: " + err.Error())
 	}
 
+	// load optional seed keys for the key rings
+	var seedAccessKey, seedRefreshKey *utils.SigningKey
+	if cfg.Token.SigningKeyPath != "" {
+		seedAccessKey, err = utils.LoadSigningKey(cfg.Token.SigningAlgorithm, cfg.Token.SigningKeyPath)
+		if err != nil {
+			panic("Failed to load signing key: " + err.Error())
+		}
+	}
+	if cfg.Token.RefreshTokenSecret != "" {
+		seedRefreshKey, err = utils.NewSecretKey([]byte(cfg.Token.RefreshTokenSecret))
+		if err != nil {
+			panic("Failed to load refresh token secret: " + err.Error())
+		}
+	}
+
 	// init logger
 	logger, err := zap.NewProduction()
 	if err != nil {
@@ -52,7 +114,7 @@ func main() {
 
 	// init Gin router
 	router := gin.New()
-	router.Use(gin.Logger(), gin.Recovery())
+	router.Use(gin.Logger(), gin.Recovery(), authentication.ClientInfoMiddleware())
 
 	//
 	// SWAGGER (protected by Basic Auth, not JWT)
@@ -66,40 +128,289 @@ func main() {
 	//
 	// WIRE UP SERVICES
 	//
-	personRepo := person.NewPersonRepository(db)
-	personService := person.NewPersonService(personRepo, logger)
+	accessTTL := time.Duration(cfg.Token.AccessTokenExpiry) * time.Minute
+	refreshTTL := time.Duration(cfg.Token.RefreshTokenExpiry) * time.Hour
+	verificationTTL := time.Duration(cfg.Email.VerificationTTL) * time.Hour
+	magicLinkTTL := time.Duration(cfg.Email.MagicLinkTTL) * time.Minute
+
+	keyRepo := keys.NewKeyRepository(db)
+	keyService := keys.NewKeyService(keyRepo, logger, keys.RotationPolicy{
+		AccessAlgorithm: cfg.Token.SigningAlgorithm,
+		Interval:        time.Duration(cfg.Token.KeyRotationInterval) * time.Hour,
+		AccessOverlap:   accessTTL,
+		RefreshOverlap:  refreshTTL,
+		ActionOverlap:   max(verificationTTL, magicLinkTTL),
+		SeedAccessKey:   seedAccessKey,
+		SeedRefreshKey:  seedRefreshKey,
+	})
+	if err := keyService.Load(context.Background()); err != nil {
+		panic("Failed to load signing keys: " + err.Error())
+	}
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go keyService.Run(backgroundCtx)
 
+	var transport mail.Mailer
+	switch cfg.Email.Transport {
+	case "smtp":
+		transport, err = mail.NewSMTPMailer(mail.SMTPSettings{
+			Host:     cfg.Email.SMTPHost,
+			Port:     cfg.Email.SMTPPort,
+			Username: cfg.Email.SMTPUsername,
+			Password: cfg.Email.SMTPPassword,
+			From:     cfg.Email.From,
+			TLS:      cfg.Email.SMTPTLS,
+		})
+	case "file":
+		transport, err = mail.NewFileMailer(cfg.Email.MaildirPath, cfg.Email.From, logger)
+	case "log":
+		transport = mail.NewLogMailer(logger)
+	default:
+		err = fmt.Errorf("unknown mail transport %q", cfg.Email.Transport)
+	}
+	if err != nil {
+		panic("Failed to set up mail transport: " + err.Error())
+	}
+	templateFS := mail.DefaultTemplates()
+	if cfg.Email.TemplateDir != "" {
+		templateFS = os.DirFS(cfg.Email.TemplateDir)
+	}
+	templates, err := mail.LoadTemplates(templateFS, cfg.Email.DefaultLocale)
+	if err != nil {
+		panic("Failed to load mail templates: " + err.Error())
+	}
+	mailQueue := mail.NewQueue(transport, logger, mail.QueueSettings{
+		Size:        cfg.Email.QueueSize,
+		Workers:     2,
+		MaxAttempts: cfg.Email.MaxAttempts,
+		Backoff:     10 * time.Second,
+	})
+	go mailQueue.Run(backgroundCtx)
+	mailer := mail.NewTemplateMailer(mailQueue, templates)
+
+	personRepo := person.NewPersonRepository(db)
 	recordRepo := authentication.NewRecordRepository(db)
+	verificationRepo := verification.NewVerificationRepository(db)
+	verificationService := verification.NewVerificationService(
+		verificationRepo,
+		personRepo,
+		mailer,
+		logger,
+		keyService.ActionKeys(),
+		verification.Settings{
+			TTL:     verificationTTL,
+			LinkURL: cfg.Server.Issuer + "/api/v1/auth/verify-email",
+		},
+	)
+	go verificationService.Run(backgroundCtx)
+	sessionStore := authentication.NewSessionStore(recordRepo)
+	rbacRepo := rbac.NewRoleRepository(db)
+	rbacService := rbac.NewRBACService(rbacRepo, personRepo, sessionStore, logger)
+	if err := rbacService.Seed(context.Background()); err != nil {
+		panic("Failed to seed roles: " + err.Error())
+	}
+	personService := person.NewPersonService(
+		personRepo,
+		verificationService,
+		sessionStore,
+		rbacService,
+		logger,
+	)
+
+	organizationRepo := organization.NewOrganizationRepository(db)
+	organizationService := organization.NewOrganizationService(organizationRepo, personService, rbacService, logger)
+	groupRepo := group.NewGroupRepository(db)
+	groupService := group.NewGroupService(groupRepo, personService, rbacService, logger)
+
+	inviteRepo := registration.NewInviteRepository(db)
+	registrationService := registration.NewRegistrationService(
+		inviteRepo,
+		personService,
+		logger,
+		registration.Settings{
+			Enabled:        cfg.Registration.Enabled,
+			AllowedDomains: cfg.Registration.AllowedDomains,
+			InviteRequired: cfg.Registration.InviteRequired,
+			InviteTTL:      time.Duration(cfg.Registration.InviteTTL) * time.Hour,
+		},
+	)
+
+	clientRepo := client.NewClientRepository(db)
+	clientService := client.NewClientService(clientRepo, logger)
+
+	factorRepo := mfa.NewFactorRepository(db)
+	emailFactorRepo := mfa.NewEmailFactorRepository(db)
+	recoveryCodeRepo := mfa.NewRecoveryCodeRepository(db)
+	challengeRepo := mfa.NewChallengeRepository(db)
+	mfaService := mfa.NewMFAService(
+		factorRepo,
+		emailFactorRepo,
+		recoveryCodeRepo,
+		challengeRepo,
+		mailer,
+		logger,
+		cfg.MFA.TOTPIssuer,
+	)
+	go mfaService.Run(backgroundCtx)
+
+	securityEvents := security.NewLogPublisher(logger)
+
+	credentialRepo := passkey.NewCredentialRepository(db)
+	ceremonyRepo := passkey.NewCeremonyRepository(db)
+	passkeyService := passkey.NewPasskeyService(
+		credentialRepo,
+		ceremonyRepo,
+		securityEvents,
+		logger,
+		passkey.Settings{
+			RPID:    cfg.MFA.WebAuthnRPID,
+			RPName:  cfg.MFA.WebAuthnRPName,
+			Origins: cfg.MFA.WebAuthnOrigins,
+		},
+	)
+	go passkeyService.Run(backgroundCtx)
+
+	denylistRepo := authentication.NewDenylistRepository(db)
+	lockoutRepo := authentication.NewLockoutRepository(db)
 	authService := authentication.NewAuthenticationService(
 		personService,
+		clientService,
+		mfaService,
+		passkeyService,
+		rbacService,
+		organizationService,
+		groupService,
 		recordRepo,
+		denylistRepo,
+		lockoutRepo,
+		securityEvents,
 		logger,
 		// access token settings
-		cfg.Token.AccessTokenSecret,
-		time.Duration(cfg.Token.AccessTokenExpiry)*time.Minute,
+		keyService.AccessKeys(),
+		accessTTL,
 		// refresh token settings
-		cfg.Token.RefreshTokenSecret,
-		time.Duration(cfg.Token.RefreshTokenExpiry)*time.Hour,
+		keyService.RefreshKeys(),
+		refreshTTL,
+		cfg.Email.RequireVerified,
+		authentication.LockoutPolicy{
+			DelayAfter: cfg.Lockout.DelayAfter,
+			BaseDelay:  time.Duration(cfg.Lockout.BaseDelay) * time.Second,
+			MaxDelay:   time.Duration(cfg.Lockout.MaxDelay) * time.Second,
+			Threshold:  cfg.Lockout.Threshold,
+			Duration:   time.Duration(cfg.Lockout.Duration) * time.Minute,
+		},
+	)
+	go authService.Run(backgroundCtx)
+
+	resetRepo := reset.NewResetRepository(db)
+	resetService := reset.NewPasswordResetService(
+		resetRepo,
+		personService,
+		mailer,
+		logger,
+		reset.Settings{
+			TTL:     time.Duration(cfg.Email.PasswordResetTTL) * time.Minute,
+			LinkURL: cfg.Email.PasswordResetURL,
+		},
 	)
+	go resetService.Run(backgroundCtx)
+
+	magicLinkURL := cfg.Email.MagicLinkURL
+	if magicLinkURL == "" {
+		magicLinkURL = cfg.Server.Issuer + "/api/v1/auth/magic-link/exchange"
+	}
+	magicLinkRepo := magiclink.NewMagicLinkRepository(db)
+	magicLinkService := magiclink.NewMagicLinkService(
+		magicLinkRepo,
+		personService,
+		authService,
+		mailer,
+		logger,
+		keyService.ActionKeys(),
+		magiclink.Settings{
+			TTL:         magicLinkTTL,
+			LinkURL:     magicLinkURL,
+			SameBrowser: cfg.Email.MagicLinkBinding,
+		},
+	)
+	go magicLinkService.Run(backgroundCtx)
+
+	authentication.NewWellKnownHandler(router.Group("/"), keyService, logger)
+	oauth.NewDiscoveryHandler(
+		router.Group("/"),
+		oauth.NewDiscovery(cfg.Server.Issuer, "/api/v1", cfg.Token.SigningAlgorithm),
+		logger,
+	)
+
+	codeRepo := oauth.NewCodeRepository(db)
+	deviceRepo := oauth.NewDeviceRepository(db)
+	oauthService := oauth.NewOAuthService(
+		codeRepo,
+		deviceRepo,
+		authService,
+		clientService,
+		personService,
+		logger,
+		// OpenID Connect settings
+		cfg.Server.Issuer,
+		keyService.AccessKeys(),
+		// device verification page
+		cfg.Server.Issuer+"/api/v1/oauth/device",
+	)
+	go oauthService.Run(backgroundCtx)
 
 	api := router.Group("/api/v1")
-	authentication.NewAuthHandler(api, authService, logger)
+	authHandler := authentication.NewAuthHandler(api, authService, logger)
+	registrationHandler := registration.NewRegistrationHandler(api, registrationService, logger)
+	verificationHandler := verification.NewVerificationHandler(api, verificationService, logger)
+	reset.NewResetHandler(api, resetService, logger)
+	magiclink.NewMagicLinkHandler(api, magicLinkService, logger, strings.HasPrefix(cfg.Server.Issuer, "https://"))
+	oauthHandler := oauth.NewOAuthHandler(api, oauthService, authService, clientService, logger)
 
 	api.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	// admin routes each require a permission
+	authorize := func(permission string) gin.HandlerFunc {
+		return authentication.RequirePermission(rbacService, permission, logger)
+	}
 	adminGroup := api.Group("/")
 	adminGroup.Use(authentication.AuthMiddleware(personService,
-		cfg.Token.AccessTokenSecret, logger),
-		authentication.RoleMiddleware(person.Admin, logger))
-	personHandler := person.NewPersonHandler(adminGroup, personService, logger)
+		clientService, authService, logger))
+	personHandler := person.NewPersonHandler(adminGroup, personService, logger, authorize)
+	keys.NewKeyHandler(adminGroup, keyService, logger, authorize)
+	client.NewClientHandler(adminGroup, clientService, logger, authorize)
+	rbac.NewRBACHandler(adminGroup, rbacService, logger, authorize)
+	organizationHandler := organization.NewOrganizationHandler(adminGroup, organizationService, logger, authorize)
+	group.NewGroupHandler(adminGroup, groupService, logger, authorize)
+	adminGroup.POST("/invites", authorize(rbac.InvitesManage), registrationHandler.CreateInvite)
+	adminGroup.DELETE("/invites/:id", authorize(rbac.InvitesManage), registrationHandler.DeleteInvite)
+	adminGroup.GET("/persons/:id/lockout", authorize(rbac.LockoutsRead), authHandler.ReadLockout)
+	adminGroup.DELETE("/persons/:id/lockout", authorize(rbac.LockoutsClear), authHandler.ClearLockout)
+	adminGroup.GET("/persons/:id/sessions", authorize(rbac.SessionsRead), authHandler.ListSessions)
+	adminGroup.DELETE("/persons/:id/sessions", authorize(rbac.SessionsRevoke), authHandler.RevokeAllSessions)
+	adminGroup.DELETE("/persons/:id/sessions/:session_id", authorize(rbac.SessionsRevoke), authHandler.RevokeSession)
 
 	authGroup := api.Group("/")
 	authGroup.Use(
-		authentication.AuthMiddleware(personService, cfg.Token.AccessTokenSecret, logger),
+		authentication.AuthMiddleware(personService, clientService, authService, logger),
 	)
 	authGroup.GET("/persons/me", personHandler.ReadCurrentPerson)
+	authGroup.PUT("/persons/me/email", personHandler.UpdateCurrentEmail)
+	authGroup.GET("/persons/me/sessions", authHandler.ListCurrentSessions)
+	authGroup.DELETE("/persons/me/sessions", authHandler.RevokeOtherSessions)
+	authGroup.DELETE("/persons/me/sessions/:session_id", authHandler.RevokeCurrentSession)
+	authGroup.GET("/persons/me/organizations", organizationHandler.ReadCurrentOrganizations)
+	authGroup.GET("/persons/me/invitations", organizationHandler.ReadCurrentInvitations)
+	authGroup.POST("/persons/me/invitations/:id", organizationHandler.AcceptCurrentInvitation)
+	authGroup.DELETE("/persons/me/invitations/:id", organizationHandler.DeclineCurrentInvitation)
+	authGroup.PUT("/persons/me/organization", authHandler.SwitchOrganization)
+	authGroup.POST("/auth/verify-email/resend", verificationHandler.Resend)
+	authGroup.GET("/userinfo", oauthHandler.UserInfo)
+	authGroup.POST("/userinfo", oauthHandler.UserInfo)
+	mfa.NewMFAHandler(authGroup, mfaService, logger)
+	passkey.NewPasskeyHandler(authGroup, passkeyService, logger)
 
 	router.Use(cors.Default())
 
@@ -123,6 +434,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	logger.Info("shutting down server...")
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()